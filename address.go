@@ -0,0 +1,48 @@
+package user
+
+import "encoding/json"
+
+// Address is a shipping or billing address in the address book of a user
+type Address struct {
+	// ID is the identifier of the address, unique within the address book of the user
+	ID string `json:"id"`
+
+	// Name is the name of the person or company the address belongs to
+	Name string `json:"name"`
+
+	// Line1 is the street and house number
+	Line1 string `json:"line1"`
+
+	// Line2 is the optional second line, like an apartment or suite number
+	Line2 string `json:"line2,omitempty"`
+
+	// City is the city or town
+	City string `json:"city"`
+
+	// Region is the state, province or prefecture, depending on the country
+	Region string `json:"region,omitempty"`
+
+	// PostalCode is the postal code or ZIP code
+	PostalCode string `json:"postalCode,omitempty"`
+
+	// Country is the two letter ISO 3166-1 country code
+	Country string `json:"country"`
+
+	// DefaultShipping is true for the address orders are shipped to by default
+	DefaultShipping bool `json:"defaultShipping"`
+
+	// DefaultBilling is true for the address invoices are sent to by default
+	DefaultBilling bool `json:"defaultBilling"`
+}
+
+// UnmarshalAddress parses the JSON-encoded data and stores the result in an Address
+func UnmarshalAddress(data string) (Address, error) {
+	var r Address
+	err := json.Unmarshal([]byte(data), &r)
+	return r, err
+}
+
+// Marshal returns the JSON encoding of Address
+func (r *Address) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
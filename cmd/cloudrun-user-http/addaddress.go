@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/validation"
+	"github.com/valyala/fasthttp"
+)
+
+// AddAddress adds an address to the address book of a user. Users can only add addresses
+// to their own address book, admins can add addresses for all users.
+func AddAddress(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	addr, err := validation.Address(ctx.Request.Body())
+	if verr, ok := err.(*validation.Error); ok {
+		ValidationErrorHandler(ctx, verr)
+		return
+	}
+
+	addr, err = datastore.AddAddress(ctx, ab, userID, addr)
+	if err != nil {
+		ErrorHandler(ctx, "AddAddress", "AddAddress", err)
+		return
+	}
+
+	res := user.AddressResponse{
+		Address: addr,
+		Status:  http.StatusCreated,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "AddAddress", "Marshal", err)
+		return
+	}
+
+	ctx.SetStatusCode(http.StatusCreated)
+	ctx.Write(payload)
+}
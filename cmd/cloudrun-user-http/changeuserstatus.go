@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/etag"
+	"github.com/retgits/acme-serverless-user/internal/validation"
+	"github.com/valyala/fasthttp"
+)
+
+// ChangeUserStatus sets the status of a user, together with the reason for the change. Only
+// admins can change the status of users. The If-Match header must have the ETag of the user.
+// The response contains the admin view of the user.
+func ChangeUserStatus(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+
+	admin, err := auth.AuthorizeAdmin(ctx, db, string(ctx.Request.Header.Peek("Authorization")))
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	status, reason, err := validation.StatusChange(ctx.Request.Body())
+	if verr, ok := err.(*validation.Error); ok {
+		ValidationErrorHandler(ctx, verr)
+		return
+	}
+
+	acct, err := db.GetUser(ctx, userID)
+	if err != nil {
+		ErrorHandler(ctx, "ChangeUserStatus", "GetUser", err)
+		return
+	}
+
+	// The personal data of erased users is gone, so their account can't be reactivated
+	if acct.Erased() {
+		ErrorHandler(ctx, "ChangeUserStatus", "GetUser", datastore.NotFound("no user found with id %s", userID))
+		return
+	}
+
+	if err := etag.Check(string(ctx.Request.Header.Peek("If-Match")), acct.Version); err != nil {
+		PreconditionErrorHandler(ctx, err)
+		return
+	}
+
+	acct.ChangeStatus(status, reason, admin.ID, time.Now())
+
+	err = db.UpdateUser(ctx, acct)
+	if err == datastore.ErrVersionConflict {
+		PreconditionErrorHandler(ctx, err)
+		return
+	}
+	if err != nil {
+		ErrorHandler(ctx, "ChangeUserStatus", "UpdateUser", err)
+		return
+	}
+
+	res := user.UserDetailsResponse{
+		User:   acct.Admin(),
+		Status: http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "ChangeUserStatus", "Marshal", err)
+		return
+	}
+
+	ctx.Response.Header.Set("ETag", etag.Format(acct.Version+1))
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
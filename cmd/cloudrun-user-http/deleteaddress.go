@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/valyala/fasthttp"
+)
+
+// DeleteAddress removes an address from the address book of a user. Users can only remove
+// their own addresses, admins can remove the addresses of all users.
+func DeleteAddress(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+	addressID := ctx.UserValue("addressId").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	err = datastore.RemoveAddress(ctx, ab, userID, addressID)
+	if err != nil {
+		ErrorHandler(ctx, "DeleteAddress", "RemoveAddress", err)
+		return
+	}
+
+	res := user.DeleteAddressResponse{
+		Message:    "Address deleted successfully!",
+		ResourceID: addressID,
+		Status:     http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "DeleteAddress", "Marshal", err)
+		return
+	}
+
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
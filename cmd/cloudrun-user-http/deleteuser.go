@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/valyala/fasthttp"
+)
+
+// DeleteUser removes a user, or erases all personal data of a user when the query parameter
+// anonymize is set to true. Users can only delete their own account, admins can delete all accounts.
+func DeleteUser(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	anonymize := ctx.QueryArgs().GetBool("anonymize")
+
+	// Only users that exist are deleted, so other services aren't told to remove the data of
+	// an ID that doesn't belong to anyone
+	if _, err := db.GetUser(ctx, userID); err != nil {
+		ErrorHandler(ctx, "DeleteUser", "GetUser", err)
+		return
+	}
+
+	// Let the other services know they need to remove the data of the user. The event is sent
+	// before the user is deleted: once the user is gone the request can't be authorized again,
+	// so a failure to send it afterwards could never be retried. When sending fails nothing has
+	// been deleted and the request gets an HTTP/503, and when deleting fails the retry sends the
+	// event again.
+	evt := user.UserDeleted{
+		Metadata: acmeserverless.Metadata{
+			Domain: user.UserDomain,
+			Source: "DeleteUser",
+			Type:   user.UserDeletedEventName,
+			Status: acmeserverless.DefaultSuccessStatus,
+		},
+		Data: user.UserDeletedData{
+			UserID:     userID,
+			Anonymized: anonymize,
+			DeletedAt:  time.Now().Unix(),
+		},
+	}
+
+	if err := em.Send(evt); err != nil {
+		ErrorHandler(ctx, "DeleteUser", "Send", datastore.Unavailable(err))
+		return
+	}
+
+	err = db.DeleteUser(ctx, userID, anonymize)
+	if err != nil {
+		ErrorHandler(ctx, "DeleteUser", "DeleteUser", err)
+		return
+	}
+
+	res := user.DeleteUserResponse{
+		Message:    "User deleted successfully!",
+		ResourceID: userID,
+		Status:     http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "DeleteUser", "Marshal", err)
+		return
+	}
+
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
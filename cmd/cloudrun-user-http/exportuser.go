@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/valyala/fasthttp"
+)
+
+// ExportUser returns all data the User service keeps about a user as a single document.
+// Users can only export their own data, admins can export the data of all users.
+func ExportUser(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	data, err := datastore.Export(ctx, db, userID)
+	if err != nil {
+		ErrorHandler(ctx, "ExportUser", "Export", err)
+		return
+	}
+
+	res := user.UserExport{
+		UserID:     userID,
+		ExportedAt: time.Now().Unix(),
+		Data:       data,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "ExportUser", "Marshal", err)
+		return
+	}
+
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"user-%s.json\"", userID))
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
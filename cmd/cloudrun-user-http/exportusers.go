@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/bulk"
+	"github.com/valyala/fasthttp"
+)
+
+// contentTypes are the content types of the export formats
+var contentTypes = map[string]string{
+	bulk.FormatCSV:   "text/csv",
+	bulk.FormatJSONL: "application/x-ndjson",
+}
+
+// ExportUsers streams all users that have not been erased as a CSV or JSON Lines file,
+// chosen with the query parameter format. Only admins can export users. Passwords are
+// never exported.
+func ExportUsers(ctx *fasthttp.RequestCtx) {
+	_, err := auth.AuthorizeAdmin(ctx, db, string(ctx.Request.Header.Peek("Authorization")))
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	format := string(ctx.QueryArgs().Peek("format"))
+	if len(format) == 0 {
+		format = bulk.FormatCSV
+	}
+
+	contentType, ok := contentTypes[format]
+	if !ok {
+		ErrorHandler(ctx, "ExportUsers", "Format", bulk.ErrUnknownFormat)
+		return
+	}
+
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.SetContentType(contentType)
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"users.%s\"", format))
+
+	// The status code has been sent by the time an error occurs, so errors can only be
+	// reported to Sentry and the file ends early. The body is written after ExportUsers has
+	// returned, when the request context can no longer be used, so the export gets its own
+	// context. It stops when a page can't be written because the client has gone away.
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		n, err := bulk.Export(context.Background(), db, w, format)
+		if err != nil {
+			sentry.CaptureException(fmt.Errorf("error in ExportUsers::Export %s", err.Error()))
+			log.Printf("export stopped after %d users: %s", n, err.Error())
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/valyala/fasthttp"
+)
+
+// GetAddress returns a single address from the address book of a user. Users can only see
+// their own addresses, admins can see the addresses of all users.
+func GetAddress(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+	addressID := ctx.UserValue("addressId").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	addr, err := datastore.GetAddress(ctx, ab, userID, addressID)
+	if err != nil {
+		ErrorHandler(ctx, "GetAddress", "GetAddress", err)
+		return
+	}
+
+	res := user.AddressResponse{
+		Address: addr,
+		Status:  http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "GetAddress", "Marshal", err)
+		return
+	}
+
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
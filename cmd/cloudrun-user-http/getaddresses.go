@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/valyala/fasthttp"
+)
+
+// GetAddresses returns the address book of a user. Users can only see their own addresses,
+// admins can see the addresses of all users.
+func GetAddresses(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	book, err := ab.Addresses(ctx, userID)
+	if err != nil {
+		ErrorHandler(ctx, "GetAddresses", "Addresses", err)
+		return
+	}
+
+	res := user.AddressList{
+		Data:   book,
+		Status: http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "GetAddresses", "Marshal", err)
+		return
+	}
+
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
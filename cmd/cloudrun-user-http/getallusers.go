@@ -1,22 +1,69 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 
-	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
 	"github.com/valyala/fasthttp"
 )
 
-// GetAllUsers ...
+// GetAllUsers returns a page of users. The query parameter limit sets the size of the
+// page and cursor is the next cursor of the previous page. The query parameters email, q,
+// status and sort search for users, which only admins are allowed to do.
 func GetAllUsers(ctx *fasthttp.RequestCtx) {
-	users, err := db.AllUsers()
+	limit := ctx.QueryArgs().GetUintOrZero("limit")
+	cursor := string(ctx.QueryArgs().Peek("cursor"))
+
+	q := datastore.Query{
+		Email:  string(ctx.QueryArgs().Peek("email")),
+		Name:   string(ctx.QueryArgs().Peek("q")),
+		Status: string(ctx.QueryArgs().Peek("status")),
+		Sort:   string(ctx.QueryArgs().Peek("sort")),
+	}
+
+	if q.Empty() {
+		page, err := db.ListUsers(ctx, limit, cursor)
+		if err != nil {
+			ErrorHandler(ctx, "GetAllUsers", "ListUsers", err)
+			return
+		}
+		writeUserList(ctx, page, auth.IsAdmin(ctx, db, string(ctx.Request.Header.Peek("Authorization"))))
+		return
+	}
+
+	_, err := auth.AuthorizeAdmin(ctx, db, string(ctx.Request.Header.Peek("Authorization")))
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	searcher, ok := db.(datastore.Searcher)
+	if !ok {
+		ErrorHandler(ctx, "GetAllUsers", "SearchUsers", errors.New("searching users is not supported by the data store"))
+		return
+	}
+
+	page, err := searcher.SearchUsers(ctx, q, limit, cursor)
 	if err != nil {
-		ErrorHandler(ctx, "GetAllUsers", "AllUsers", err)
+		ErrorHandler(ctx, "GetAllUsers", "SearchUsers", err)
 		return
 	}
+	writeUserList(ctx, page, true)
+}
+
+// writeUserList writes a page of users to the response. Admins get the admin view of
+// the users, everyone else gets the public view.
+func writeUserList(ctx *fasthttp.RequestCtx, page datastore.Page, admin bool) {
+	res := user.UserList{
+		Data: make([]interface{}, len(page.Users)),
+		Next: page.Next,
+	}
 
-	res := acmeserverless.AllUsers{
-		Data: users,
+	for idx, usr := range page.Users {
+		res.Data[idx] = usr.View(admin)
 	}
 
 	payload, err := res.Marshal()
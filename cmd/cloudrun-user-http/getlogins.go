@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/valyala/fasthttp"
+)
+
+// GetLogins returns the most recent login attempts of a user, newest first. The query
+// parameter limit sets the number of attempts. Users can only see their own login history,
+// admins can see the login history of all users.
+func GetLogins(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+	limit := ctx.QueryArgs().GetUintOrZero("limit")
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	history, ok := db.(datastore.LoginHistory)
+	if !ok {
+		ErrorHandler(ctx, "GetLogins", "Logins", errors.New("the login history is not supported by the data store"))
+		return
+	}
+
+	logins, err := history.Logins(ctx, userID, datastore.PageSize(limit))
+	if err != nil {
+		ErrorHandler(ctx, "GetLogins", "Logins", err)
+		return
+	}
+
+	res := user.LoginList{
+		Data:   logins,
+		Status: http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "GetLogins", "Marshal", err)
+		return
+	}
+
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/etag"
+	"github.com/valyala/fasthttp"
+)
+
+// GetPreferences returns the preferences of a user, or the default preferences if the user
+// hasn't changed them. Users can only see their own preferences, admins can see the
+// preferences of all users. The ETag header has the version of the user, which is needed
+// to change the preferences.
+func GetPreferences(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	acct, err := db.GetUser(ctx, userID)
+	if err != nil {
+		ErrorHandler(ctx, "GetPreferences", "GetUser", err)
+		return
+	}
+
+	res := user.PreferencesResponse{
+		Preferences: acct.Settings(),
+		Status:      http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "GetPreferences", "Marshal", err)
+		return
+	}
+
+	ctx.Response.Header.Set("ETag", etag.Format(acct.Version))
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
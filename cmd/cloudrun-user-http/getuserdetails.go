@@ -3,23 +3,28 @@ package main
 import (
 	"net/http"
 
-	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/etag"
 	"github.com/valyala/fasthttp"
 )
 
-// GetUserDetails ...
+// GetUserDetails returns the public view of a user, or the admin view when the request
+// carries the access token of an admin. The ETag header has the version of the user.
 func GetUserDetails(ctx *fasthttp.RequestCtx) {
 	// Create the key attributes
 	userID := ctx.UserValue("id").(string)
 
-	usr, err := db.GetUser(userID)
+	usr, err := db.GetUser(ctx, userID)
 	if err != nil {
 		ErrorHandler(ctx, "GetUserDetails", "GetUser", err)
 		return
 	}
 
-	res := acmeserverless.UserDetailsResponse{
-		User:   usr,
+	admin := auth.IsAdmin(ctx, db, string(ctx.Request.Header.Peek("Authorization")))
+
+	res := user.UserDetailsResponse{
+		User:   usr.View(admin),
 		Status: http.StatusOK,
 	}
 
@@ -29,6 +34,7 @@ func GetUserDetails(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	ctx.Response.Header.Set("ETag", etag.Format(usr.Version))
 	ctx.SetStatusCode(http.StatusOK)
 	ctx.Write(payload)
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/getsentry/sentry-go"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/bulk"
+	"github.com/valyala/fasthttp"
+)
+
+// ImportUsers loads the users in the body of the request, a CSV or JSON Lines file, in
+// batches. Only admins can import users. The query parameter format is csv, jsonl, auth0 or cognito,
+// dryRun=true validates the rows without storing any users and resumeAfter skips the rows
+// up to and including that row. The response contains the summary of the import and the
+// rows that were not imported.
+func ImportUsers(ctx *fasthttp.RequestCtx) {
+	_, err := auth.AuthorizeAdmin(ctx, db, string(ctx.Request.Header.Peek("Authorization")))
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	format := string(ctx.QueryArgs().Peek("format"))
+	if len(format) == 0 {
+		format = bulk.FormatCSV
+	}
+
+	opts := bulk.Options{
+		Format:      format,
+		DryRun:      ctx.QueryArgs().GetBool("dryRun"),
+		ResumeAfter: ctx.QueryArgs().GetUintOrZero("resumeAfter"),
+	}
+
+	res := user.ImportResponse{
+		Results: make([]user.ImportResult, 0),
+		Status:  http.StatusOK,
+	}
+
+	res.Summary, err = bulk.Import(ctx, db, bytes.NewReader(ctx.Request.Body()), opts, func(r user.ImportResult) error {
+		if r.Status != bulk.StatusImported || r.PasswordReset {
+			res.Results = append(res.Results, r)
+		}
+		return nil
+	})
+	if err != nil {
+		// The summary tells how far the import got, so it can be resumed after the last row
+		sentry.CaptureException(fmt.Errorf("error in ImportUsers::Import %s", err.Error()))
+		res.Message = err.Error()
+		res.Status = http.StatusBadRequest
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "ImportUsers", "Marshal", err)
+		return
+	}
+
+	ctx.SetStatusCode(res.Status)
+	ctx.Write(payload)
+}
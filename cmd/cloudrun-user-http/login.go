@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
 	"github.com/valyala/fasthttp"
 )
 
@@ -15,21 +23,53 @@ func Login(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	usr, err = db.FindUser(usr.Username)
+	// Users log in with their username or with their email address
+	identifier := usr.Username
+	if len(identifier) == 0 {
+		identifier = usr.Email
+	}
+
+	// Logins of users that don't exist get the same response as a wrong password, so a
+	// login doesn't tell whether a username or email address is registered
+	acct, err := auth.FindAccount(ctx, db, identifier)
+	if errors.Is(err, datastore.ErrNotFound) {
+		AuthErrorHandler(ctx, auth.ErrInvalidCredentials)
+		return
+	}
 	if err != nil {
-		ErrorHandler(ctx, "Login", "FindUser", err)
+		ErrorHandler(ctx, "Login", "FindAccount", err)
+		return
+	}
+
+	attempt := user.LoginAttempt{
+		At:        time.Now().Unix(),
+		IP:        clientIP(ctx),
+		UserAgent: string(ctx.UserAgent()),
+		Method:    auth.LoginMethod(identifier),
+	}
+
+	// Users with a wrong password, users that must reset their password and users whose
+	// account is pending, locked or disabled don't get new tokens
+	if err := auth.CheckCredentials(acct, usr.Password); err != nil {
+		attempt.Reason = err.Error()
+		recordLogin(ctx, acct, attempt)
+		AuthErrorHandler(ctx, err)
 		return
 	}
 
-	accessToken, refreshToken, err := GenerateTokenPair(usr.Username, usr.ID)
+	accessToken, refreshToken, err := auth.GenerateTokenPair(acct.Username, acct.ID)
 	if err != nil {
 		ErrorHandler(ctx, "Login", "GenerateTokenPair", err)
 		return
 	}
 
-	res := acmeserverless.LoginResponse{
+	attempt.Success = true
+	recordLogin(ctx, acct, attempt)
+
+	res := user.LoginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+		Preferences:  acct.Settings(),
 		Status:       http.StatusOK,
 	}
 
@@ -42,3 +82,25 @@ func Login(ctx *fasthttp.RequestCtx) {
 	ctx.SetStatusCode(http.StatusOK)
 	ctx.Write(payload)
 }
+
+// recordLogin adds the attempt to the login history of the user. A login doesn't fail
+// when the attempt can't be recorded.
+func recordLogin(ctx context.Context, acct datastore.Account, attempt user.LoginAttempt) {
+	if err := datastore.RecordLogin(ctx, db, acct, attempt); err != nil {
+		log.Printf("error recording login of user %s: %s", acct.ID, err.Error())
+	}
+}
+
+// clientIP returns the IP address of the client. Cloud Run sits behind a load balancer,
+// which appends the address it received the request from to the X-Forwarded-For header.
+// Clients can send the header with addresses of their own, so only the last entry, which
+// the load balancer added, is used.
+func clientIP(ctx *fasthttp.RequestCtx) string {
+	if xff := string(ctx.Request.Header.Peek("X-Forwarded-For")); len(xff) > 0 {
+		hops := strings.Split(xff, ",")
+		if ip := strings.TrimSpace(hops[len(hops)-1]); len(ip) > 0 {
+			return ip
+		}
+	}
+	return ctx.RemoteIP().String()
+}
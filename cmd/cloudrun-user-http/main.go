@@ -1,17 +1,27 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/fasthttp/router"
 	"github.com/getsentry/sentry-go"
 	sentryfasthttp "github.com/getsentry/sentry-go/fasthttp"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/auth"
 	"github.com/retgits/acme-serverless-user/internal/datastore"
-	"github.com/retgits/acme-serverless-user/internal/datastore/mongodb"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/backends"
+	"github.com/retgits/acme-serverless-user/internal/emitter"
+	"github.com/retgits/acme-serverless-user/internal/emitter/eventbridge"
+	"github.com/retgits/acme-serverless-user/internal/etag"
+	"github.com/retgits/acme-serverless-user/internal/httperror"
+	"github.com/retgits/acme-serverless-user/internal/validation"
 	gcrwavefront "github.com/retgits/gcr-wavefront"
 	"github.com/valyala/fasthttp"
 )
@@ -22,25 +32,84 @@ const (
 
 var (
 	db datastore.Manager
+	ab datastore.AddressBook
+	em emitter.EventEmitter
 )
 
 // CORSHandler sets CORS headers for the preflight request
 func CORSHandler(ctx *fasthttp.RequestCtx) {
 	ctx.Response.Header.Add("Access-Control-Allow-Credentials", "true")
-	ctx.Response.Header.Add("Access-Control-Allow-Headers", "Authorization")
-	ctx.Response.Header.Add("Access-Control-Allow-Methods", "GET, POST")
+	ctx.Response.Header.Add("Access-Control-Allow-Headers", "Authorization, If-Match")
+	ctx.Response.Header.Add("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
 	ctx.Response.Header.Add("Access-Control-Allow-Origin", "*")
 	ctx.Response.Header.Add("Access-Control-Max-Age", "3600")
 	ctx.Response.SetStatusCode(http.StatusNoContent)
 }
 
 // ErrorHandler takes the activity where the error occured and the error object and sends a message to sentry.
+// The status code of the response depends on the kind of error, for example users and addresses that don't
+// exist get an HTTP/404 and requests the data store can't handle right now get an HTTP/503.
 func ErrorHandler(ctx *fasthttp.RequestCtx, function string, method string, err error) {
 	sentry.CaptureException(fmt.Errorf("error in %s::%s %s", function, method, err.Error()))
-	ctx.SetStatusCode(http.StatusBadRequest)
+	ctx.SetStatusCode(httperror.StatusCode(err))
 	ctx.SetBodyString(err.Error())
 }
 
+// AuthErrorHandler responds to requests that are not allowed to access a resource. Requests without a valid
+// access token, logins with a wrong password and logins of users that must reset their password get an
+// HTTP/401, requests for resources of other users and from users whose account is not active get an HTTP/403.
+// When the user can't be read because the data store is unavailable, the request gets an HTTP/503.
+func AuthErrorHandler(ctx *fasthttp.RequestCtx, err error) {
+	if errors.Is(err, datastore.ErrUnavailable) {
+		ErrorHandler(ctx, "AuthErrorHandler", "Authorize", err)
+		return
+	}
+
+	res := acmeserverless.VerifyTokenResponse{
+		Message: "Invalid Key. User Not Authorized",
+		Status:  http.StatusUnauthorized,
+	}
+
+	switch err {
+	case auth.ErrForbidden:
+		res.Message = "User Not Allowed To Access This Resource"
+		res.Status = http.StatusForbidden
+	case auth.ErrInactive:
+		res.Message = "User Account Not Active"
+		res.Status = http.StatusForbidden
+	case auth.ErrInvalidCredentials, auth.ErrPasswordReset:
+		// Users that must reset their password can log in with no password at all, so they
+		// get the same response as a wrong password to not tell which accounts exist
+		res.Message = "Invalid Username Or Password"
+	}
+
+	payload, _ := res.Marshal()
+	ctx.SetStatusCode(res.Status)
+	ctx.Write(payload)
+}
+
+// PreconditionErrorHandler responds to updates that would overwrite a change the client hasn't seen.
+// Updates without an If-Match header get an HTTP/428 and updates of users that have been changed
+// since the client read them get an HTTP/412.
+func PreconditionErrorHandler(ctx *fasthttp.RequestCtx, err error) {
+	switch err {
+	case etag.ErrPreconditionRequired:
+		ctx.SetStatusCode(http.StatusPreconditionRequired)
+	default:
+		ctx.SetStatusCode(http.StatusPreconditionFailed)
+	}
+	ctx.SetBodyString(err.Error())
+}
+
+// ValidationErrorHandler responds to requests with a payload that didn't pass validation. The response
+// lists each field that failed, together with the rule it failed.
+func ValidationErrorHandler(ctx *fasthttp.RequestCtx, err *validation.Error) {
+	res := err.Response(http.StatusUnprocessableEntity)
+	payload, _ := res.Marshal()
+	ctx.SetStatusCode(res.Status)
+	ctx.Write(payload)
+}
+
 func main() {
 	// Get the version or set a default to "dev"
 	version := os.Getenv("VERSION")
@@ -105,16 +174,64 @@ func main() {
 
 	// Add routes to the router
 	router.GET("/users", cfg.WrapFastHTTPRequest(sentryHandler.Handle(GetAllUsers)))
+	router.GET("/users/export", cfg.WrapFastHTTPRequest(sentryHandler.Handle(ExportUsers)))
+	router.POST("/users/import", cfg.WrapFastHTTPRequest(sentryHandler.Handle(ImportUsers)))
 	router.GET("/users/{id}", cfg.WrapFastHTTPRequest(sentryHandler.Handle(GetUserDetails)))
+	router.DELETE("/users/{id}", cfg.WrapFastHTTPRequest(sentryHandler.Handle(DeleteUser)))
+	router.GET("/users/{id}/export", cfg.WrapFastHTTPRequest(sentryHandler.Handle(ExportUser)))
+	router.PUT("/users/{id}/status", cfg.WrapFastHTTPRequest(sentryHandler.Handle(ChangeUserStatus)))
+	router.GET("/users/{id}/preferences", cfg.WrapFastHTTPRequest(sentryHandler.Handle(GetPreferences)))
+	router.PUT("/users/{id}/preferences", cfg.WrapFastHTTPRequest(sentryHandler.Handle(UpdatePreferences)))
+	router.GET("/users/{id}/logins", cfg.WrapFastHTTPRequest(sentryHandler.Handle(GetLogins)))
+	router.GET("/users/{id}/addresses", cfg.WrapFastHTTPRequest(sentryHandler.Handle(GetAddresses)))
+	router.POST("/users/{id}/addresses", cfg.WrapFastHTTPRequest(sentryHandler.Handle(AddAddress)))
+	router.GET("/users/{id}/addresses/{addressId}", cfg.WrapFastHTTPRequest(sentryHandler.Handle(GetAddress)))
+	router.PUT("/users/{id}/addresses/{addressId}", cfg.WrapFastHTTPRequest(sentryHandler.Handle(UpdateAddress)))
+	router.DELETE("/users/{id}/addresses/{addressId}", cfg.WrapFastHTTPRequest(sentryHandler.Handle(DeleteAddress)))
 	router.POST("/register", cfg.WrapFastHTTPRequest(sentryHandler.Handle(RegisterUser)))
 	router.POST("/login", cfg.WrapFastHTTPRequest(sentryHandler.Handle(Login)))
 	router.POST("/refresh-token", cfg.WrapFastHTTPRequest(sentryHandler.Handle(RefreshJWTToken)))
 	router.POST("/verify-token", cfg.WrapFastHTTPRequest(sentryHandler.Handle(VerifyJWTToken)))
 
-	// Create an instance of the datastore manager
-	db = mongodb.New()
+	// Create an instance of the datastore manager. The datastore is selected with DATASTORE, and
+	// is MongoDB when it isn't set.
+	var err error
+	db, err = datastore.FromEnv("mongodb")
+	if err != nil {
+		log.Fatalf("error opening datastore: %s", err.Error())
+	}
+
+	// The address book is kept in the same datastore as the users
+	ab = db.(datastore.AddressBook)
+
+	// Create an instance of the event emitter
+	em = eventbridge.New()
+
+	server := &fasthttp.Server{
+		Handler: router.Handler,
+	}
+
+	// Cloud Run sends a SIGTERM before it stops an instance. Shutting down the server
+	// cancels the context of the requests that are still running, so their calls to the
+	// datastore stop as well.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		log.Printf("shutting down %s server", servicename)
+		if err := server.Shutdown(); err != nil {
+			log.Printf("error shutting down server: %s", err.Error())
+		}
+	}()
 
 	// Start the server
 	log.Printf("successfully started %s server", servicename)
-	log.Fatal(fasthttp.ListenAndServe(fmt.Sprintf(":%s", port), router.Handler))
+	if err := server.ListenAndServe(fmt.Sprintf(":%s", port)); err != nil {
+		log.Fatal(err)
+	}
+
+	// The server has shut down and no request uses the datastore anymore
+	if err := db.Close(); err != nil {
+		log.Printf("error closing datastore: %s", err.Error())
+	}
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 
 	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
 	"github.com/valyala/fasthttp"
 )
 
@@ -15,7 +19,21 @@ func RefreshJWTToken(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	valid, id, _, err := ValidateToken(login.RefreshToken)
+	valid, id, key, err := auth.ValidateToken(login.RefreshToken)
+
+	// Only refresh tokens can be exchanged for a new access token
+	valid = valid && key == auth.RefreshTokenKeyID
+
+	// Tokens of users that have been deleted or are no longer active can no longer be refreshed
+	var acct datastore.Account
+	if valid && id != "" {
+		acct, err = auth.Active(ctx, db, id)
+		if errors.Is(err, datastore.ErrUnavailable) {
+			ErrorHandler(ctx, "RefreshJWTToken", "Active", err)
+			return
+		}
+		valid = err == nil
+	}
 
 	if !valid || id == "" {
 		res := acmeserverless.VerifyTokenResponse{
@@ -34,15 +52,16 @@ func RefreshJWTToken(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	newToken, err := GenerateAccessToken("eric", id)
+	newToken, err := auth.GenerateAccessToken(acct.Username, id)
 	if err != nil {
 		ErrorHandler(ctx, "RefreshJWTToken", "GenerateAccessToken", err)
 		return
 	}
 
-	res := acmeserverless.LoginResponse{
+	res := user.LoginResponse{
 		AccessToken:  newToken,
 		RefreshToken: login.RefreshToken,
+		Preferences:  acct.Settings(),
 		Status:       http.StatusOK,
 	}
 
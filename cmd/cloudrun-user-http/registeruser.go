@@ -5,20 +5,33 @@ import (
 
 	"github.com/gofrs/uuid"
 	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/validation"
 	"github.com/valyala/fasthttp"
 )
 
 // RegisterUser ...
 func RegisterUser(ctx *fasthttp.RequestCtx) {
 	// Update the user with an ID
-	usr, err := acmeserverless.UnmarshalUser(string(ctx.Request.Body()))
-	if err != nil {
-		ErrorHandler(ctx, "RegisterUser", "UnmarshalUser", err)
+	usr, err := validation.Registration(ctx.Request.Body())
+	if verr, ok := err.(*validation.Error); ok {
+		ValidationErrorHandler(ctx, verr)
 		return
 	}
 	usr.ID = uuid.Must(uuid.NewV4()).String()
 
-	err = db.AddUser(usr)
+	err = db.AddUser(ctx, datastore.Account{User: usr})
+	if err == datastore.ErrUserExists {
+		res := acmeserverless.RegisterUserResponse{
+			Message: "A user with this username or email address already exists",
+			Status:  http.StatusConflict,
+		}
+
+		payload, _ := res.Marshal()
+		ctx.SetStatusCode(http.StatusConflict)
+		ctx.Write(payload)
+		return
+	}
 	if err != nil {
 		ErrorHandler(ctx, "RegisterUser", "AddUser", err)
 		return
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/validation"
+	"github.com/valyala/fasthttp"
+)
+
+// UpdateAddress replaces an address in the address book of a user. Users can only change
+// their own addresses, admins can change the addresses of all users.
+func UpdateAddress(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+	addressID := ctx.UserValue("addressId").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	addr, err := validation.Address(ctx.Request.Body())
+	if verr, ok := err.(*validation.Error); ok {
+		ValidationErrorHandler(ctx, verr)
+		return
+	}
+	addr.ID = addressID
+
+	addr, err = datastore.UpdateAddress(ctx, ab, userID, addr)
+	if err != nil {
+		ErrorHandler(ctx, "UpdateAddress", "UpdateAddress", err)
+		return
+	}
+
+	res := user.AddressResponse{
+		Address: addr,
+		Status:  http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "UpdateAddress", "Marshal", err)
+		return
+	}
+
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/etag"
+	"github.com/retgits/acme-serverless-user/internal/validation"
+	"github.com/valyala/fasthttp"
+)
+
+// UpdatePreferences replaces the preferences of a user. Preferences that are not part of the
+// request get their default value. Users can only change their own preferences, admins can
+// change the preferences of all users. The If-Match header must have the ETag of the user.
+func UpdatePreferences(ctx *fasthttp.RequestCtx) {
+	// Create the key attributes
+	userID := ctx.UserValue("id").(string)
+
+	_, err := auth.Authorize(ctx, db, string(ctx.Request.Header.Peek("Authorization")), userID)
+	if err != nil {
+		AuthErrorHandler(ctx, err)
+		return
+	}
+
+	prefs, err := validation.Preferences(ctx.Request.Body())
+	if verr, ok := err.(*validation.Error); ok {
+		ValidationErrorHandler(ctx, verr)
+		return
+	}
+
+	acct, err := db.GetUser(ctx, userID)
+	if err != nil {
+		ErrorHandler(ctx, "UpdatePreferences", "GetUser", err)
+		return
+	}
+
+	if err := etag.Check(string(ctx.Request.Header.Peek("If-Match")), acct.Version); err != nil {
+		PreconditionErrorHandler(ctx, err)
+		return
+	}
+
+	acct.Preferences = &prefs
+
+	err = db.UpdateUser(ctx, acct)
+	if err == datastore.ErrVersionConflict {
+		PreconditionErrorHandler(ctx, err)
+		return
+	}
+	if err != nil {
+		ErrorHandler(ctx, "UpdatePreferences", "UpdateUser", err)
+		return
+	}
+
+	res := user.PreferencesResponse{
+		Preferences: prefs,
+		Status:      http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		ErrorHandler(ctx, "UpdatePreferences", "Marshal", err)
+		return
+	}
+
+	ctx.Response.Header.Set("ETag", etag.Format(acct.Version+1))
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Write(payload)
+}
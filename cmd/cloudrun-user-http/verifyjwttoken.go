@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 
 	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
 	"github.com/valyala/fasthttp"
 )
 
@@ -15,14 +18,24 @@ func VerifyJWTToken(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	valid, _, key, err := ValidateToken(login.AccessToken)
+	valid, id, key, err := auth.ValidateToken(login.AccessToken)
+
+	// Tokens of users that have been deleted are no longer valid
+	if valid {
+		_, err = auth.Active(ctx, db, id)
+		if errors.Is(err, datastore.ErrUnavailable) {
+			ErrorHandler(ctx, "VerifyJWTToken", "Active", err)
+			return
+		}
+		valid = err == nil
+	}
 
 	res := acmeserverless.VerifyTokenResponse{
 		Message: "Token Valid. User Authorized",
 		Status:  http.StatusOK,
 	}
 
-	if !valid || key != "signin_1" {
+	if !valid || key != auth.AccessTokenKeyID {
 		res.Message = "Invalid Key. User Not Authorized"
 		res.Status = http.StatusForbidden
 	}
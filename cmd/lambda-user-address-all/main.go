@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/getsentry/sentry-go"
+	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/httperror"
+	wflambda "github.com/wavefronthq/wavefront-lambda-go"
+)
+
+// db is the datastore the users are kept in. The Lambda functions only link Amazon DynamoDB, so
+// the environment variable DATASTORE can only select dynamodb, which is also its default.
+var db datastore.Manager
+
+// handler handles the API Gateway events and returns an error if anything goes wrong.
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// Initiialize a connection to Sentry to capture errors and traces
+	sentry.Init(sentry.ClientOptions{
+		Dsn: os.Getenv("SENTRY_DSN"),
+		Transport: &sentry.HTTPSyncTransport{
+			Timeout: time.Second * 3,
+		},
+		ServerName:  os.Getenv("FUNCTION_NAME"),
+		Release:     os.Getenv("VERSION"),
+		Environment: os.Getenv("STAGE"),
+	})
+
+	// Create headers if they don't exist and add
+	// the CORS required headers, otherwise the response
+	// will not be accepted by browsers.
+	headers := request.Headers
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["Access-Control-Allow-Origin"] = "*"
+
+	// Create the key attributes
+	userID := request.PathParameters["id"]
+
+	// Users can only access their own address book, admins can access the address books of all users
+	_, err := auth.Authorize(ctx, db, auth.AuthorizationHeader(headers), userID)
+	if err != nil {
+		return handleAuthError(headers, err)
+	}
+	book := db.(datastore.AddressBook)
+
+	addrs, err := book.Addresses(ctx, userID)
+	if err != nil {
+		return handleError("getting addresses", headers, err)
+	}
+
+	res := user.AddressList{
+		Data:   addrs,
+		Status: http.StatusOK,
+	}
+
+	payload, err := res.Marshal()
+	if err != nil {
+		return handleError("marshalling response", headers, err)
+	}
+
+	response := events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(payload),
+		Headers:    headers,
+	}
+
+	return response, nil
+}
+
+// handleAuthError responds to requests that are not allowed to access a resource. Requests without a valid
+// access token get an HTTP/401, requests for resources of other users or from users whose account is not
+// active get an HTTP/403.
+// When the user can't be read because the data store is unavailable, the request gets an HTTP/503.
+func handleAuthError(headers map[string]string, err error) (events.APIGatewayProxyResponse, error) {
+	if errors.Is(err, datastore.ErrUnavailable) {
+		return handleError("authorizing request", headers, err)
+	}
+
+	res := acmeserverless.VerifyTokenResponse{
+		Message: "Invalid Key. User Not Authorized",
+		Status:  http.StatusUnauthorized,
+	}
+
+	switch err {
+	case auth.ErrForbidden:
+		res.Message = "User Not Allowed To Access This Resource"
+		res.Status = http.StatusForbidden
+	case auth.ErrInactive:
+		res.Message = "User Account Not Active"
+		res.Status = http.StatusForbidden
+	}
+
+	payload, _ := res.Marshal()
+	return events.APIGatewayProxyResponse{
+		StatusCode: res.Status,
+		Body:       string(payload),
+		Headers:    headers,
+	}, nil
+}
+
+// handleError takes the activity where the error occured and the error object and sends a message to sentry.
+// The original error, together with the appropriate API Gateway Proxy Response, is returned so it can be thrown.
+func handleError(area string, headers map[string]string, err error) (events.APIGatewayProxyResponse, error) {
+	sentry.CaptureException(fmt.Errorf("error %s: %s", area, err.Error()))
+	msg := fmt.Sprintf("error %s: %s", area, err.Error())
+	log.Println(msg)
+	return events.APIGatewayProxyResponse{
+		StatusCode: httperror.StatusCode(err),
+		Body:       msg,
+		Headers:    headers,
+	}, nil
+}
+
+// The main method is executed by AWS Lambda and points to the handler
+func main() {
+	var err error
+	db, err = datastore.FromEnv("dynamodb")
+	if err != nil {
+		log.Fatalf("error opening datastore: %s", err.Error())
+	}
+
+	lambda.Start(wflambda.Wrapper(handler))
+}
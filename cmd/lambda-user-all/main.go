@@ -1,22 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/getsentry/sentry-go"
 	acmeserverless "github.com/retgits/acme-serverless"
-	"github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/httperror"
 	wflambda "github.com/wavefronthq/wavefront-lambda-go"
 )
 
+// db is the datastore the users are kept in. The Lambda functions only link Amazon DynamoDB, so
+// the environment variable DATASTORE can only select dynamodb, which is also its default.
+var db datastore.Manager
+
 // handler handles the API Gateway events and returns an error if anything goes wrong.
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Initiialize a connection to Sentry to capture errors and traces
 	sentry.Init(sentry.ClientOptions{
 		Dsn: os.Getenv("SENTRY_DSN"),
@@ -37,14 +48,56 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 	}
 	headers["Access-Control-Allow-Origin"] = "*"
 
-	dynamoStore := dynamodb.New()
-	users, err := dynamoStore.AllUsers()
-	if err != nil {
-		return handleError("getting users", headers, err)
+	// The query parameter limit sets the size of the page and cursor is the
+	// next cursor of the previous page
+	limit, _ := strconv.Atoi(request.QueryStringParameters["limit"])
+	cursor := request.QueryStringParameters["cursor"]
+
+	// The query parameters email, q, status and sort search for users, which only
+	// admins are allowed to do
+	q := datastore.Query{
+		Email:  request.QueryStringParameters["email"],
+		Name:   request.QueryStringParameters["q"],
+		Status: request.QueryStringParameters["status"],
+		Sort:   request.QueryStringParameters["sort"],
+	}
+
+	var page datastore.Page
+	var err error
+	var admin bool
+
+	if q.Empty() {
+		page, err = db.ListUsers(ctx, limit, cursor)
+		if err != nil {
+			return handleError("getting users", headers, err)
+		}
+		admin = auth.IsAdmin(ctx, db, auth.AuthorizationHeader(request.Headers))
+	} else {
+		_, err = auth.AuthorizeAdmin(ctx, db, auth.AuthorizationHeader(request.Headers))
+		if err != nil {
+			return handleAuthError(headers, err)
+		}
+
+		searcher, ok := db.(datastore.Searcher)
+		if !ok {
+			return handleError("searching users", headers, errors.New("searching users is not supported by the data store"))
+		}
+
+		page, err = searcher.SearchUsers(ctx, q, limit, cursor)
+		if err != nil {
+			return handleError("searching users", headers, err)
+		}
+		admin = true
+	}
+
+	res := user.UserList{
+		Data: make([]interface{}, len(page.Users)),
+		Next: page.Next,
 	}
 
-	res := acmeserverless.AllUsers{
-		Data: users,
+	// Admins get the admin view of the users, everyone else gets the public view
+	for idx, usr := range page.Users {
+		res.Data[idx] = usr.View(admin)
 	}
 
 	payload, err := res.Marshal()
@@ -61,6 +114,37 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 	return response, nil
 }
 
+// handleAuthError responds to requests that are not allowed to access a resource. Requests without a valid
+// access token get an HTTP/401, requests from users that are not an admin or whose account is not active
+// get an HTTP/403.
+// When the user can't be read because the data store is unavailable, the request gets an HTTP/503.
+func handleAuthError(headers map[string]string, err error) (events.APIGatewayProxyResponse, error) {
+	if errors.Is(err, datastore.ErrUnavailable) {
+		return handleError("authorizing request", headers, err)
+	}
+
+	res := acmeserverless.VerifyTokenResponse{
+		Message: "Invalid Key. User Not Authorized",
+		Status:  http.StatusUnauthorized,
+	}
+
+	switch err {
+	case auth.ErrForbidden:
+		res.Message = "User Not Allowed To Access This Resource"
+		res.Status = http.StatusForbidden
+	case auth.ErrInactive:
+		res.Message = "User Account Not Active"
+		res.Status = http.StatusForbidden
+	}
+
+	payload, _ := res.Marshal()
+	return events.APIGatewayProxyResponse{
+		StatusCode: res.Status,
+		Body:       string(payload),
+		Headers:    headers,
+	}, nil
+}
+
 // handleError takes the activity where the error occured and the error object and sends a message to sentry.
 // The original error, together with the appropriate API Gateway Proxy Response, is returned so it can be thrown.
 func handleError(area string, headers map[string]string, err error) (events.APIGatewayProxyResponse, error) {
@@ -68,7 +152,7 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 	msg := fmt.Sprintf("error %s: %s", area, err.Error())
 	log.Println(msg)
 	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusBadRequest,
+		StatusCode: httperror.StatusCode(err),
 		Body:       msg,
 		Headers:    headers,
 	}, nil
@@ -76,5 +160,16 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 
 // The main method is executed by AWS Lambda and points to the handler
 func main() {
+	var err error
+	db, err = datastore.FromEnv("dynamodb")
+	if err != nil {
+		log.Fatalf("error opening datastore: %s", err.Error())
+	}
+
+	// Every page of users has a signed cursor to the next page
+	if err := datastore.CursorKeyFromEnv(); err != nil {
+		log.Fatalf("error reading cursor key: %s", err.Error())
+	}
+
 	lambda.Start(wflambda.Wrapper(handler))
 }
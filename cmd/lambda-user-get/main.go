@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,13 +11,21 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/getsentry/sentry-go"
-	acmeserverless "github.com/retgits/acme-serverless"
-	"github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/etag"
+	"github.com/retgits/acme-serverless-user/internal/httperror"
 	wflambda "github.com/wavefronthq/wavefront-lambda-go"
 )
 
+// db is the datastore the users are kept in. The Lambda functions only link Amazon DynamoDB, so
+// the environment variable DATASTORE can only select dynamodb, which is also its default.
+var db datastore.Manager
+
 // handler handles the API Gateway events and returns an error if anything goes wrong.
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Initiialize a connection to Sentry to capture errors and traces
 	sentry.Init(sentry.ClientOptions{
 		Dsn: os.Getenv("SENTRY_DSN"),
@@ -40,14 +49,16 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 	// Create the key attributes
 	userID := request.PathParameters["id"]
 
-	dynamoStore := dynamodb.New()
-	usr, err := dynamoStore.GetUser(userID)
+	usr, err := db.GetUser(ctx, userID)
 	if err != nil {
 		return handleError("getting products", headers, err)
 	}
 
-	res := acmeserverless.UserDetailsResponse{
-		User:   usr,
+	// Admins get the admin view of the user, everyone else gets the public view
+	admin := auth.IsAdmin(ctx, db, auth.AuthorizationHeader(request.Headers))
+
+	res := user.UserDetailsResponse{
+		User:   usr.View(admin),
 		Status: http.StatusOK,
 	}
 
@@ -56,6 +67,8 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 		return handleError("marshalling response", headers, err)
 	}
 
+	headers["ETag"] = etag.Format(usr.Version)
+
 	response := events.APIGatewayProxyResponse{
 		StatusCode: http.StatusOK,
 		Body:       string(payload),
@@ -72,7 +85,7 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 	msg := fmt.Sprintf("error %s: %s", area, err.Error())
 	log.Println(msg)
 	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusBadRequest,
+		StatusCode: httperror.StatusCode(err),
 		Body:       msg,
 		Headers:    headers,
 	}, nil
@@ -80,5 +93,11 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 
 // The main method is executed by AWS Lambda and points to the handler
 func main() {
+	var err error
+	db, err = datastore.FromEnv("dynamodb")
+	if err != nil {
+		log.Fatalf("error opening datastore: %s", err.Error())
+	}
+
 	lambda.Start(wflambda.Wrapper(handler))
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,15 +11,22 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/getsentry/sentry-go"
 	acmeserverless "github.com/retgits/acme-serverless"
-	"github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/httperror"
 	wflambda "github.com/wavefronthq/wavefront-lambda-go"
 )
 
+// db is the datastore the users are kept in. The Lambda functions only link Amazon DynamoDB, so
+// the environment variable DATASTORE can only select dynamodb, which is also its default.
+var db datastore.Manager
+
 // handler handles the API Gateway events and returns an error if anything goes wrong.
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Initiialize a connection to Sentry to capture errors and traces
 	sentry.Init(sentry.ClientOptions{
 		Dsn: os.Getenv("SENTRY_DSN"),
@@ -44,20 +53,49 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 		return handleError("unmarshalling user", headers, err)
 	}
 
-	dynamoStore := dynamodb.New()
-	usr, err = dynamoStore.FindUser(usr.Username)
+	// Users log in with their username or with their email address
+	identifier := usr.Username
+	if len(identifier) == 0 {
+		identifier = usr.Email
+	}
+
+	// Logins of users that don't exist get the same response as a wrong password, so a
+	// login doesn't tell whether a username or email address is registered
+	acct, err := auth.FindAccount(ctx, db, identifier)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return handleAuthError(headers, auth.ErrInvalidCredentials)
+	}
 	if err != nil {
 		return handleError("getting users", headers, err)
 	}
 
-	accessToken, refreshToken, err := GenerateTokenPair(usr.Username, usr.ID)
+	attempt := user.LoginAttempt{
+		At:        time.Now().Unix(),
+		IP:        request.RequestContext.Identity.SourceIP,
+		UserAgent: request.RequestContext.Identity.UserAgent,
+		Method:    auth.LoginMethod(identifier),
+	}
+
+	// Users with a wrong password, users that must reset their password and users whose
+	// account is pending, locked or disabled don't get new tokens
+	if err := auth.CheckCredentials(acct, usr.Password); err != nil {
+		attempt.Reason = err.Error()
+		recordLogin(ctx, db, acct, attempt)
+		return handleAuthError(headers, err)
+	}
+
+	accessToken, refreshToken, err := auth.GenerateTokenPair(acct.Username, acct.ID)
 	if err != nil {
 		return handleError("generating accesstoken", headers, err)
 	}
 
-	res := acmeserverless.LoginResponse{
+	attempt.Success = true
+	recordLogin(ctx, db, acct, attempt)
+
+	res := user.LoginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+		Preferences:  acct.Settings(),
 		Status:       http.StatusOK,
 	}
 
@@ -75,6 +113,14 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 	return response, nil
 }
 
+// recordLogin adds the attempt to the login history of the user. A login doesn't fail when the
+// attempt can't be recorded.
+func recordLogin(ctx context.Context, db datastore.Manager, acct datastore.Account, attempt user.LoginAttempt) {
+	if err := datastore.RecordLogin(ctx, db, acct, attempt); err != nil {
+		log.Printf("error recording login of user %s: %s", acct.ID, err.Error())
+	}
+}
+
 // handleError takes the activity where the error occured and the error object and sends a message to sentry.
 // The original error, together with the appropriate API Gateway Proxy Response, is returned so it can be thrown.
 func handleError(area string, headers map[string]string, err error) (events.APIGatewayProxyResponse, error) {
@@ -82,61 +128,41 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 	msg := fmt.Sprintf("error %s: %s", area, err.Error())
 	log.Println(msg)
 	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusBadRequest,
+		StatusCode: httperror.StatusCode(err),
 		Body:       msg,
 		Headers:    headers,
 	}, nil
 }
 
-// The main method is executed by AWS Lambda and points to the handler
-func main() {
-	lambda.Start(wflambda.Wrapper(handler))
-}
-
-// GenerateTokenPair creates and returns a new set of access_token and refresh_token.
-func GenerateTokenPair(username string, uuid string) (string, string, error) {
-
-	tokenString, err := GenerateAccessToken(username, uuid)
-	if err != nil {
-		return "", "", err
+// handleAuthError responds to logins that are refused. A wrong password and users that must
+// reset their password get an HTTP/401, users whose account is not active get an HTTP/403.
+func handleAuthError(headers map[string]string, err error) (events.APIGatewayProxyResponse, error) {
+	res := acmeserverless.VerifyTokenResponse{
+		Message: "Invalid Username Or Password",
+		Status:  http.StatusUnauthorized,
 	}
 
-	// Create Refresh token, this will be used to get new access token.
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshToken.Header["kid"] = "signin_2"
-
-	expirationTimeRefreshToken := time.Now().Add(15 * time.Minute).Unix()
-
-	rtClaims := refreshToken.Claims.(jwt.MapClaims)
-	rtClaims["sub"] = uuid
-	rtClaims["exp"] = expirationTimeRefreshToken
-
-	refreshTokenString, err := refreshToken.SignedString([]byte("my_secret_key_2"))
-	if err != nil {
-		return "", "", err
+	switch err {
+	case auth.ErrInactive:
+		res.Message = "User Account Not Active"
+		res.Status = http.StatusForbidden
 	}
 
-	return tokenString, refreshTokenString, nil
+	payload, _ := res.Marshal()
+	return events.APIGatewayProxyResponse{
+		StatusCode: res.Status,
+		Body:       string(payload),
+		Headers:    headers,
+	}, nil
 }
 
-func GenerateAccessToken(username string, uuid string) (string, error) {
-	// Declare the expiration time of the access token
-	// Here the expiration is 5 minutes
-	expirationTimeAccessToken := time.Now().Add(5 * time.Minute).Unix()
-
-	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.New(jwt.SigningMethodHS256)
-	token.Header["kid"] = "signin_1"
-	claims := token.Claims.(jwt.MapClaims)
-	claims["Username"] = username
-	claims["exp"] = expirationTimeAccessToken
-	claims["sub"] = uuid
-
-	// Create the JWT string
-	tokenString, err := token.SignedString([]byte("my_secret_key"))
+// The main method is executed by AWS Lambda and points to the handler
+func main() {
+	var err error
+	db, err = datastore.FromEnv("dynamodb")
 	if err != nil {
-		return "", err
+		log.Fatalf("error opening datastore: %s", err.Error())
 	}
 
-	return tokenString, nil
+	lambda.Start(wflambda.Wrapper(handler))
 }
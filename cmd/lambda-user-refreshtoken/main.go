@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,14 +11,22 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/getsentry/sentry-go"
 	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/httperror"
 	wflambda "github.com/wavefronthq/wavefront-lambda-go"
 )
 
+// db is the datastore the users are kept in. The Lambda functions only link Amazon DynamoDB, so
+// the environment variable DATASTORE can only select dynamodb, which is also its default.
+var db datastore.Manager
+
 // handler handles the API Gateway events and returns an error if anything goes wrong.
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Initiialize a connection to Sentry to capture errors and traces
 	sentry.Init(sentry.ClientOptions{
 		Dsn: os.Getenv("SENTRY_DSN"),
@@ -43,7 +53,20 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 		return handleError("unmarshalling login", headers, err)
 	}
 
-	valid, id, _, err := ValidateToken(login.RefreshToken)
+	valid, id, key, err := auth.ValidateToken(login.RefreshToken)
+
+	// Only refresh tokens can be exchanged for a new access token
+	valid = valid && key == auth.RefreshTokenKeyID
+
+	// Tokens of users that have been deleted or are no longer active can no longer be refreshed
+	var acct datastore.Account
+	if valid && id != "" {
+		acct, err = auth.Active(ctx, db, id)
+		if errors.Is(err, datastore.ErrUnavailable) {
+			return handleError("getting user", headers, err)
+		}
+		valid = err == nil
+	}
 
 	if !valid || id == "" {
 		res := acmeserverless.VerifyTokenResponse{
@@ -64,11 +87,15 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 		return response, nil
 	}
 
-	newToken, _ := GenerateAccessToken("eric", id)
+	newToken, err := auth.GenerateAccessToken(acct.Username, id)
+	if err != nil {
+		return handleError("generating accesstoken", headers, err)
+	}
 
-	res := acmeserverless.LoginResponse{
+	res := user.LoginResponse{
 		AccessToken:  newToken,
 		RefreshToken: login.RefreshToken,
+		Preferences:  acct.Settings(),
 		Status:       http.StatusOK,
 	}
 
@@ -93,7 +120,7 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 	msg := fmt.Sprintf("error %s: %s", area, err.Error())
 	log.Println(msg)
 	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusBadRequest,
+		StatusCode: httperror.StatusCode(err),
 		Body:       msg,
 		Headers:    headers,
 	}, nil
@@ -101,66 +128,11 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 
 // The main method is executed by AWS Lambda and points to the handler
 func main() {
-	lambda.Start(wflambda.Wrapper(handler))
-}
-
-func GenerateAccessToken(username string, uuid string) (string, error) {
-	// Declare the expiration time of the access token
-	// Here the expiration is 5 minutes
-	expirationTimeAccessToken := time.Now().Add(5 * time.Minute).Unix()
-
-	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.New(jwt.SigningMethodHS256)
-	token.Header["kid"] = "signin_1"
-	claims := token.Claims.(jwt.MapClaims)
-	claims["Username"] = username
-	claims["exp"] = expirationTimeAccessToken
-	claims["sub"] = uuid
-
-	// Create the JWT string
-	tokenString, err := token.SignedString([]byte("my_secret_key"))
+	var err error
+	db, err = datastore.FromEnv("dynamodb")
 	if err != nil {
-		return "", err
+		log.Fatalf("error opening datastore: %s", err.Error())
 	}
 
-	return tokenString, nil
-}
-
-// ValidateToken is used to validate both access_token and refresh_token. It is done based on the "Key ID" provided by the JWT
-func ValidateToken(tokenString string) (bool, string, string, error) {
-
-	var key []byte
-
-	var keyID string
-
-	claims := jwt.MapClaims{}
-
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-
-		keyID = token.Header["kid"].(string)
-		// If the "kid" (Key ID) is equal to signin_1, then it is compared against access_token secret key, else if it
-		// is equal to signin_2 , it is compared against refresh_token secret key.
-		if keyID == "signin_1" {
-			key = []byte("my_secret_key")
-		} else if keyID == "signin_2" {
-			key = []byte("my_secret_key_2")
-		}
-		return key, nil
-	})
-
-	// Check if signatures are valid.
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			log.Printf("Invalid Token Signature")
-			return false, "", keyID, err
-		}
-		return false, "", keyID, err
-	}
-
-	if !token.Valid {
-		log.Printf("Invalid Token")
-		return false, "", keyID, err
-	}
-
-	return true, claims["sub"].(string), keyID, nil
+	lambda.Start(wflambda.Wrapper(handler))
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,12 +13,19 @@ import (
 	"github.com/getsentry/sentry-go"
 	"github.com/gofrs/uuid"
 	acmeserverless "github.com/retgits/acme-serverless"
-	"github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/httperror"
+	"github.com/retgits/acme-serverless-user/internal/validation"
 	wflambda "github.com/wavefronthq/wavefront-lambda-go"
 )
 
+// db is the datastore the users are kept in. The Lambda functions only link Amazon DynamoDB, so
+// the environment variable DATASTORE can only select dynamodb, which is also its default.
+var db datastore.Manager
+
 // handler handles the API Gateway events and returns an error if anything goes wrong.
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Initiialize a connection to Sentry to capture errors and traces
 	sentry.Init(sentry.ClientOptions{
 		Dsn: os.Getenv("SENTRY_DSN"),
@@ -39,14 +47,26 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 	headers["Access-Control-Allow-Origin"] = "*"
 
 	// Update the user with an ID
-	usr, err := acmeserverless.UnmarshalUser(request.Body)
-	if err != nil {
-		return handleError("unmarshalling user", headers, err)
+	usr, err := validation.Registration([]byte(request.Body))
+	if verr, ok := err.(*validation.Error); ok {
+		return handleValidationError(headers, verr)
 	}
 	usr.ID = uuid.Must(uuid.NewV4()).String()
 
-	dynamoStore := dynamodb.New()
-	err = dynamoStore.AddUser(usr)
+	err = db.AddUser(ctx, datastore.Account{User: usr})
+	if err == datastore.ErrUserExists {
+		res := acmeserverless.RegisterUserResponse{
+			Message: "A user with this username or email address already exists",
+			Status:  http.StatusConflict,
+		}
+
+		payload, _ := res.Marshal()
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusConflict,
+			Body:       string(payload),
+			Headers:    headers,
+		}, nil
+	}
 	if err != nil {
 		return handleError("getting users", headers, err)
 	}
@@ -71,6 +91,18 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 	return response, nil
 }
 
+// handleValidationError responds to requests with a payload that didn't pass validation. The response
+// lists each field that failed, together with the rule it failed.
+func handleValidationError(headers map[string]string, err *validation.Error) (events.APIGatewayProxyResponse, error) {
+	res := err.Response(http.StatusUnprocessableEntity)
+	payload, _ := res.Marshal()
+	return events.APIGatewayProxyResponse{
+		StatusCode: res.Status,
+		Body:       string(payload),
+		Headers:    headers,
+	}, nil
+}
+
 // handleError takes the activity where the error occured and the error object and sends a message to sentry.
 // The original error, together with the appropriate API Gateway Proxy Response, is returned so it can be thrown.
 func handleError(area string, headers map[string]string, err error) (events.APIGatewayProxyResponse, error) {
@@ -78,7 +110,7 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 	msg := fmt.Sprintf("error %s: %s", area, err.Error())
 	log.Println(msg)
 	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusBadRequest,
+		StatusCode: httperror.StatusCode(err),
 		Body:       msg,
 		Headers:    headers,
 	}, nil
@@ -86,5 +118,11 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 
 // The main method is executed by AWS Lambda and points to the handler
 func main() {
+	var err error
+	db, err = datastore.FromEnv("dynamodb")
+	if err != nil {
+		log.Fatalf("error opening datastore: %s", err.Error())
+	}
+
 	lambda.Start(wflambda.Wrapper(handler))
 }
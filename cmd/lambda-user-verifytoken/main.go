@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,14 +11,21 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/getsentry/sentry-go"
 	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/httperror"
 	wflambda "github.com/wavefronthq/wavefront-lambda-go"
 )
 
+// db is the datastore the users are kept in. The Lambda functions only link Amazon DynamoDB, so
+// the environment variable DATASTORE can only select dynamodb, which is also its default.
+var db datastore.Manager
+
 // handler handles the API Gateway events and returns an error if anything goes wrong.
-func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// Initiialize a connection to Sentry to capture errors and traces
 	sentry.Init(sentry.ClientOptions{
 		Dsn: os.Getenv("SENTRY_DSN"),
@@ -43,14 +52,23 @@ func handler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyRespo
 		return handleError("unmarshalling login", headers, err)
 	}
 
-	valid, _, key, err := ValidateToken(login.AccessToken)
+	valid, id, key, err := auth.ValidateToken(login.AccessToken)
+
+	// Tokens of users that have been deleted are no longer valid
+	if valid {
+		_, err = auth.Active(ctx, db, id)
+		if errors.Is(err, datastore.ErrUnavailable) {
+			return handleError("getting user", headers, err)
+		}
+		valid = err == nil
+	}
 
 	res := acmeserverless.VerifyTokenResponse{
 		Message: "Token Valid. User Authorized",
 		Status:  http.StatusOK,
 	}
 
-	if !valid || key != "signin_1" {
+	if !valid || key != auth.AccessTokenKeyID {
 		res.Message = "Invalid Key. User Not Authorized"
 		res.Status = http.StatusForbidden
 	}
@@ -76,7 +94,7 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 	msg := fmt.Sprintf("error %s: %s", area, err.Error())
 	log.Println(msg)
 	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusBadRequest,
+		StatusCode: httperror.StatusCode(err),
 		Body:       msg,
 		Headers:    headers,
 	}, nil
@@ -84,44 +102,11 @@ func handleError(area string, headers map[string]string, err error) (events.APIG
 
 // The main method is executed by AWS Lambda and points to the handler
 func main() {
-	lambda.Start(wflambda.Wrapper(handler))
-}
-
-// ValidateToken is used to validate both access_token and refresh_token. It is done based on the "Key ID" provided by the JWT
-func ValidateToken(tokenString string) (bool, string, string, error) {
-
-	var key []byte
-
-	var keyID string
-
-	claims := jwt.MapClaims{}
-
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-
-		keyID = token.Header["kid"].(string)
-		// If the "kid" (Key ID) is equal to signin_1, then it is compared against access_token secret key, else if it
-		// is equal to signin_2 , it is compared against refresh_token secret key.
-		if keyID == "signin_1" {
-			key = []byte("my_secret_key")
-		} else if keyID == "signin_2" {
-			key = []byte("my_secret_key_2")
-		}
-		return key, nil
-	})
-
-	// Check if signatures are valid.
+	var err error
+	db, err = datastore.FromEnv("dynamodb")
 	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			log.Printf("Invalid Token Signature")
-			return false, "", keyID, err
-		}
-		return false, "", keyID, err
-	}
-
-	if !token.Valid {
-		log.Printf("Invalid Token")
-		return false, "", keyID, err
+		log.Fatalf("error opening datastore: %s", err.Error())
 	}
 
-	return true, claims["sub"].(string), keyID, nil
+	lambda.Start(wflambda.Wrapper(handler))
 }
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/bulk"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/validation"
+)
+
+// changedBy is recorded as the author of status changes made with the user-admin tool
+const changedBy = "user-admin"
+
+// generatedPasswordBytes is the number of random bytes in a generated password
+const generatedPasswordBytes = 18
+
+// listFlag is a flag that can be set more than once
+type listFlag []string
+
+// String returns the values of the flag
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set adds a value to the flag
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parse parses the flags of a command and returns the single ID that follows them
+func parse(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("%s needs the ID of a user", fs.Name())
+	}
+	return fs.Arg(0), nil
+}
+
+// create adds a new user. The user is validated with the same rules as registrations.
+func create(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	var roles listFlag
+
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	username := fs.String("username", "", "the username of the new user")
+	password := fs.String("password", "", "the password of the new user")
+	firstname := fs.String("firstname", "", "the first name of the new user")
+	lastname := fs.String("lastname", "", "the last name of the new user")
+	email := fs.String("email", "", "the email address of the new user")
+	fs.Var(&roles, "role", "a role to assign to the new user, can be set more than once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"username":  *username,
+		"password":  *password,
+		"firstname": *firstname,
+		"lastname":  *lastname,
+		"email":     *email,
+	})
+	if err != nil {
+		return err
+	}
+
+	usr, err := validation.Registration(payload)
+	if err != nil {
+		return err
+	}
+	usr.ID = uuid.Must(uuid.NewV4()).String()
+
+	acct := datastore.Account{User: usr}
+	for _, role := range roles {
+		if !datastore.ValidRole(role) {
+			return fmt.Errorf("unknown role %s", role)
+		}
+		acct.SetRole(role, true)
+	}
+
+	if err := db.AddUser(ctx, acct); err != nil {
+		return err
+	}
+
+	return out.User(acct)
+}
+
+// get shows a single user
+func get(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	userID, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	acct, err := db.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return out.User(acct)
+}
+
+// list shows a page of users
+func list(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	limit := fs.Int("limit", datastore.DefaultPageSize, "the number of users in a page")
+	cursor := fs.String("cursor", "", "the cursor of the page to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	page, err := db.ListUsers(ctx, *limit, *cursor)
+	if err != nil {
+		return err
+	}
+
+	return out.Users(page.Users, page.Next)
+}
+
+// search shows a page of users that match the query
+func search(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	email := fs.String("email", "", "the email address of the user")
+	name := fs.String("q", "", "the start of the name of the user, in the form \"lastname firstname\"")
+	status := fs.String("status", "", "the status of the user")
+	order := fs.String("sort", "", "the order of the users, id or name, prefix with - to sort descending")
+	limit := fs.Int("limit", datastore.DefaultPageSize, "the number of users in a page")
+	cursor := fs.String("cursor", "", "the cursor of the page to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	searcher, ok := db.(datastore.Searcher)
+	if !ok {
+		return errors.New("searching users is not supported by the datastore")
+	}
+
+	q := datastore.Query{
+		Email:  *email,
+		Name:   *name,
+		Status: *status,
+		Sort:   *order,
+	}
+
+	page, err := searcher.SearchUsers(ctx, q, *limit, *cursor)
+	if err != nil {
+		return err
+	}
+
+	return out.Users(page.Users, page.Next)
+}
+
+// disable disables the account of a user, so the user can no longer log in or use tokens
+func disable(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	return changeStatus(ctx, db, out, "disable", datastore.StatusDisabled, args)
+}
+
+// enable reactivates the account of a user
+func enable(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	return changeStatus(ctx, db, out, "enable", datastore.StatusActive, args)
+}
+
+// changeStatus sets the status of a user and records the reason for the change
+func changeStatus(ctx context.Context, db datastore.Manager, out printer, name string, status string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	reason := fs.String("reason", "", "why the status of the user is changed")
+	userID, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(strings.TrimSpace(*reason)) == 0 {
+		return fmt.Errorf("%s needs a reason", name)
+	}
+
+	acct, err := db.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	acct.ChangeStatus(status, strings.TrimSpace(*reason), changedBy, time.Now())
+
+	if err := db.UpdateUser(ctx, acct); err != nil {
+		return err
+	}
+
+	return out.User(acct)
+}
+
+// resetPassword sets a new password for a user. When no password is given a random
+// password is generated and printed. Users that have been imported without a usable
+// password can log in again once their password has been reset.
+func resetPassword(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	password := fs.String("password", "", "the new password, a random password is generated if none is given")
+	userID, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	generated := len(*password) == 0
+	if generated {
+		b := make([]byte, generatedPasswordBytes)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		*password = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	if err := validation.Password(*password); err != nil {
+		return err
+	}
+
+	acct, err := db.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	acct.SetPassword(*password)
+
+	if err := db.UpdateUser(ctx, acct); err != nil {
+		return err
+	}
+
+	if generated {
+		return out.Password(acct.ID, *password)
+	}
+
+	return out.User(acct)
+}
+
+// roles assigns roles to and removes roles from a user
+func roles(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	var add, remove listFlag
+
+	fs := flag.NewFlagSet("roles", flag.ContinueOnError)
+	fs.Var(&add, "add", "a role to assign to the user, can be set more than once")
+	fs.Var(&remove, "remove", "a role to remove from the user, can be set more than once")
+	userID, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	acct, err := db.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range add {
+		if !datastore.ValidRole(role) {
+			return fmt.Errorf("unknown role %s", role)
+		}
+		acct.SetRole(role, true)
+	}
+
+	for _, role := range remove {
+		acct.SetRole(role, false)
+	}
+
+	if err := db.UpdateUser(ctx, acct); err != nil {
+		return err
+	}
+
+	return out.User(acct)
+}
+
+// importUsers loads users from a CSV or JSON Lines file, or from an Auth0 or Cognito export,
+// in batches. With a checkpoint file
+// the number of the last handled row is saved after each batch, and running the same
+// command again resumes after that row.
+func importUsers(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", bulk.FormatCSV, "the format of the file, csv, jsonl, auth0 or cognito")
+	dryRun := fs.Bool("dry-run", false, "validate the file without storing any users")
+	resumeAfter := fs.Int("resume-after", 0, "skip the rows up to and including this row")
+	checkpoint := fs.String("checkpoint", "", "a file to save the last handled row in, and to resume from")
+	batchSize := fs.Int("batch-size", bulk.DefaultBatchSize, "the number of users to store at once")
+	filename, err := parseFile(fs, args)
+	if err != nil {
+		return err
+	}
+
+	opts := bulk.Options{
+		Format:      *format,
+		DryRun:      *dryRun,
+		ResumeAfter: *resumeAfter,
+		BatchSize:   *batchSize,
+	}
+
+	if len(*checkpoint) > 0 {
+		if opts.ResumeAfter == 0 {
+			opts.ResumeAfter, err = readCheckpoint(*checkpoint)
+			if err != nil {
+				return err
+			}
+		}
+		opts.Checkpoint = func(row int) error {
+			return ioutil.WriteFile(*checkpoint, []byte(strconv.Itoa(row)), 0644)
+		}
+	}
+
+	in, err := open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	summary, err := bulk.Import(ctx, db, in, opts, func(res user.ImportResult) error {
+		if (res.Status == bulk.StatusImported && !res.PasswordReset) || res.Status == bulk.StatusValid {
+			return nil
+		}
+		return out.Result(res)
+	})
+	if perr := out.Summary(summary); perr != nil && err == nil {
+		err = perr
+	}
+
+	return err
+}
+
+// exportUsers writes all users to a CSV or JSON Lines file. Passwords are never exported.
+func exportUsers(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", bulk.FormatCSV, "the format of the file, csv or jsonl")
+	filename, err := parseFile(fs, args)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if filename != "-" {
+		w, err = os.Create(filename)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+
+	n, err := bulk.Export(ctx, db, w, *format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d users\n", n)
+	return nil
+}
+
+// migrate rewrites the users that the datastore keeps in an older layout. Datastores that
+// change their layout by themselves have nothing to migrate.
+func migrate(ctx context.Context, db datastore.Manager, out printer, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	migrator, ok := db.(datastore.Migrator)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "the datastore has nothing to migrate")
+		return nil
+	}
+
+	n, err := migrator.Migrate(ctx)
+	fmt.Fprintf(os.Stderr, "migrated %d users\n", n)
+
+	return err
+}
+
+// parseFile parses the flags of a command and returns the single file name that follows
+// them. The file name - stands for standard input or output.
+func parseFile(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("%s needs the name of a file, or - for standard input or output", fs.Name())
+	}
+	return fs.Arg(0), nil
+}
+
+// open opens the file to import, or standard input for -
+func open(filename string) (io.ReadCloser, error) {
+	if filename == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(filename)
+}
+
+// readCheckpoint returns the row saved in a checkpoint file, or 0 if the file doesn't exist
+func readCheckpoint(filename string) (int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	row, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint file %s doesn't contain a row number", filename)
+	}
+
+	return row, nil
+}
@@ -0,0 +1,144 @@
+// Command user-admin manages the users of the User service in the ACME Serverless Fitness Shop.
+// It works directly on the datastore, using the same environment variables as the services to
+// connect to it: TABLE, REGION and DYNAMO_URL for Amazon DynamoDB, the MONGO_* variables for
+// MongoDB, the POSTGRES_* variables for PostgreSQL and SQLITE_PATH for SQLite. The memory backend
+// keeps users in the file MEMORY_FILE, which is useful to prepare users for local development.
+// Without -backend, the tool uses the datastore in DATASTORE, or Amazon DynamoDB when that isn't
+// set.
+//
+// Usage:
+//
+//	user-admin [-backend dynamodb|mongodb|postgres|sqlite|memory] [-output table|json] <command> [arguments]
+//
+// The commands are:
+//
+//	create     create a new user
+//	get        show a single user
+//	list       list users, one page at a time
+//	search     search users by email address, name and status
+//	disable    disable the account of a user
+//	enable     reactivate the account of a user
+//	reset-password
+//	           set a new password for a user
+//	roles      add roles to or remove roles from a user
+//	import     load users from a CSV or JSON Lines file or an Auth0 or Cognito export
+//	export     write all users to a CSV or JSON Lines file
+//	migrate    rewrite users stored in an older layout of the datastore
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/backends"
+)
+
+// command is a subcommand of the user-admin tool
+type command struct {
+	// usage is the list of arguments of the command
+	usage string
+
+	// help is a short description of the command
+	help string
+
+	// run executes the command with the arguments that follow the name of the command
+	run func(ctx context.Context, db datastore.Manager, out printer, args []string) error
+}
+
+// commands are the subcommands of the user-admin tool, keyed by their name
+var commands = map[string]command{
+	"create":         {usage: "-username <name> -password <password> -firstname <name> -lastname <name> -email <email> [-role <role>]", help: "create a new user", run: create},
+	"get":            {usage: "<id>", help: "show a single user", run: get},
+	"list":           {usage: "[-limit <n>] [-cursor <cursor>]", help: "list users, one page at a time", run: list},
+	"search":         {usage: "[-email <email>] [-q <name>] [-status <status>] [-sort <order>] [-limit <n>] [-cursor <cursor>]", help: "search users by email address, name and status", run: search},
+	"disable":        {usage: "-reason <reason> <id>", help: "disable the account of a user", run: disable},
+	"enable":         {usage: "-reason <reason> <id>", help: "reactivate the account of a user", run: enable},
+	"reset-password": {usage: "[-password <password>] <id>", help: "set a new password for a user, a random password is generated if none is given", run: resetPassword},
+	"roles":          {usage: "[-add <role>] [-remove <role>] <id>", help: "add roles to or remove roles from a user", run: roles},
+	"import":         {usage: "[-format csv|jsonl|auth0|cognito] [-dry-run] [-batch-size <n>] [-resume-after <row>] [-checkpoint <file>] <file>", help: "load users from a CSV or JSON Lines file or an Auth0 or Cognito export, - reads standard input", run: importUsers},
+	"export":         {usage: "[-format csv|jsonl] <file>", help: "write all users to a CSV or JSON Lines file, - writes standard output", run: exportUsers},
+	"migrate":        {usage: "", help: "rewrite users stored in an older layout of the datastore", run: migrate},
+}
+
+// order is the order in which the commands are shown in the usage message
+var order = []string{"create", "get", "list", "search", "disable", "enable", "reset-password", "roles", "import", "export", "migrate"}
+
+func main() {
+	// Like the services, the tool uses the datastore in DATASTORE unless another one is given
+	def := os.Getenv("DATASTORE")
+	if len(def) == 0 {
+		def = "dynamodb"
+	}
+
+	backend := flag.String("backend", def, "the datastore to manage users in, one of "+strings.Join(datastore.Backends(), ", "))
+	output := flag.String("output", "table", "the output format, table or json")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	out, err := newPrinter(*output)
+	if err != nil {
+		fatal(err)
+	}
+
+	db, err := datastore.Open(*backend)
+	if err != nil {
+		fatal(err)
+	}
+
+	// Interrupting the tool cancels the context of the command, so requests to the
+	// datastore stop and an import stops at the batch it is writing
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	err = cmd.run(ctx, db, out, flag.Args()[1:])
+
+	// fatal exits without running deferred calls, so the datastore is closed before it
+	if cerr := db.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// usage prints how to use the user-admin tool
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: user-admin [-backend %s] [-output table|json] <command> [arguments]\n\n", strings.Join(datastore.Backends(), "|"))
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	for _, name := range order {
+		cmd := commands[name]
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, cmd.usage)
+		fmt.Fprintf(os.Stderr, "      %s\n", cmd.help)
+	}
+}
+
+// fatal prints the error and exits
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "user-admin: %s\n", err.Error())
+	os.Exit(1)
+}
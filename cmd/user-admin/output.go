@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// printer writes the results of a command. Users are always printed using the admin view,
+// so passwords are never printed.
+type printer interface {
+	// Users prints a page of users and the cursor of the next page
+	Users(users []datastore.Account, next string) error
+
+	// User prints a single user
+	User(acct datastore.Account) error
+
+	// Password prints a password that has been generated for a user
+	Password(userID string, password string) error
+
+	// Result prints the outcome of a row of an import
+	Result(res user.ImportResult) error
+
+	// Summary prints the summary of an import
+	Summary(s user.ImportSummary) error
+}
+
+// newPrinter returns the printer for the output format
+func newPrinter(format string) (printer, error) {
+	switch format {
+	case "table":
+		return tablePrinter{}, nil
+	case "json":
+		return jsonPrinter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %s", format)
+	}
+}
+
+// tablePrinter prints results as a table with aligned columns
+type tablePrinter struct{}
+
+// Users prints a row for each user, followed by the cursor of the next page
+func (p tablePrinter) Users(users []datastore.Account, next string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tNAME\tEMAIL\tSTATUS\tROLES")
+	for _, acct := range users {
+		name := strings.TrimSpace(fmt.Sprintf("%s %s", acct.Firstname, acct.Lastname))
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", acct.ID, acct.Username, name, acct.Email, acct.State(), strings.Join(acct.Roles, ","))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(next) > 0 {
+		fmt.Printf("\nnext page: -cursor %s\n", next)
+	}
+	return nil
+}
+
+// User prints a single user as a table with one row
+func (p tablePrinter) User(acct datastore.Account) error {
+	return p.Users([]datastore.Account{acct}, "")
+}
+
+// Password prints the ID of the user and the password
+func (p tablePrinter) Password(userID string, password string) error {
+	fmt.Printf("new password for %s: %s\n", userID, password)
+	return nil
+}
+
+// Result prints the row, its outcome and the reason the row wasn't imported, or what must be
+// done for a user that was
+func (p tablePrinter) Result(res user.ImportResult) error {
+	msgs := make([]string, 0, len(res.Errors)+1)
+	for _, f := range res.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	if len(msgs) == 0 {
+		msgs = append(msgs, res.Message)
+	}
+
+	fmt.Printf("row %d: %s: %s\n", res.Row, res.Status, strings.Join(msgs, ", "))
+	return nil
+}
+
+// Summary prints the number of rows for each outcome, and the number of imported users that
+// need a new password
+func (p tablePrinter) Summary(s user.ImportSummary) error {
+	fmt.Printf("%d rows: %d imported, %d valid, %d invalid, %d exist, %d failed, last row %d\n", s.Rows, s.Imported, s.Valid, s.Invalid, s.Exists, s.Failed, s.LastRow)
+	if s.PasswordReset > 0 {
+		fmt.Printf("%d imported users can't log in until reset-password sets a new password\n", s.PasswordReset)
+	}
+	return nil
+}
+
+// jsonPrinter prints results as JSON documents, in the same format the User service uses
+type jsonPrinter struct{}
+
+// Users prints a page of users as a UserList
+func (p jsonPrinter) Users(users []datastore.Account, next string) error {
+	res := user.UserList{
+		Data: make([]interface{}, len(users)),
+		Next: next,
+	}
+
+	for idx, acct := range users {
+		res.Data[idx] = acct.Admin()
+	}
+
+	return p.print(res)
+}
+
+// User prints a single user
+func (p jsonPrinter) User(acct datastore.Account) error {
+	return p.print(acct.Admin())
+}
+
+// Password prints the ID of the user and the password
+func (p jsonPrinter) Password(userID string, password string) error {
+	return p.print(map[string]string{
+		"id":       userID,
+		"password": password,
+	})
+}
+
+// Result prints the outcome of a row
+func (p jsonPrinter) Result(res user.ImportResult) error {
+	return p.print(res)
+}
+
+// Summary prints the summary of an import
+func (p jsonPrinter) Summary(s user.ImportSummary) error {
+	return p.print(s)
+}
+
+// print writes v as indented JSON
+func (p jsonPrinter) print(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	return enc.Encode(v)
+}
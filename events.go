@@ -0,0 +1,51 @@
+package user
+
+import (
+	"encoding/json"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+)
+
+const (
+	// UserDomain is the name used for the user domain
+	UserDomain = "User"
+
+	// UserDeletedEventName is the event name of UserDeleted
+	UserDeletedEventName = "UserDeleted"
+)
+
+// UserDeleted is the event sent by the User service when a user has been deleted or when the
+// personal data of a user has been erased. Other services should remove the personal data
+// they keep about the user when they receive this event.
+type UserDeleted struct {
+	// Metadata for the event.
+	Metadata acmeserverless.Metadata `json:"metadata"`
+
+	// Data contains the payload data for the event.
+	Data UserDeletedData `json:"data"`
+}
+
+// UserDeletedData is the data the user service emits when a user is deleted.
+type UserDeletedData struct {
+	// UserID is the unique identifier of the user that has been deleted.
+	UserID string `json:"userId"`
+
+	// Anonymized is true when the user record has been kept, but the personal data has been
+	// erased. Services can keep data, like order history, that refers to the user ID.
+	Anonymized bool `json:"anonymized"`
+
+	// DeletedAt is the Unix timestamp at which the user has been deleted.
+	DeletedAt int64 `json:"deletedAt"`
+}
+
+// UnmarshalUserDeleted parses the JSON-encoded data and stores the result in a UserDeleted.
+func UnmarshalUserDeleted(data []byte) (UserDeleted, error) {
+	var r UserDeleted
+	err := json.Unmarshal(data, &r)
+	return r, err
+}
+
+// Marshal returns the JSON encoding of UserDeleted.
+func (e *UserDeleted) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
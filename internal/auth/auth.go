@@ -0,0 +1,273 @@
+// Package auth contains the logic the User service in the ACME Serverless Fitness Shop
+// uses to create and validate JWT tokens, and to decide whether a request is allowed
+// to access the data of a user.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+const (
+	// AccessTokenKeyID is the "Key ID" of access tokens
+	AccessTokenKeyID = "signin_1"
+
+	// RefreshTokenKeyID is the "Key ID" of refresh tokens
+	RefreshTokenKeyID = "signin_2"
+)
+
+var (
+	// ErrUnauthorized is returned when a request doesn't carry a valid access token, or when
+	// the user the token was issued for can no longer use it
+	ErrUnauthorized = errors.New("invalid key. user not authorized")
+
+	// ErrForbidden is returned when a valid access token is used to access data of another user,
+	// and the user the token was issued for is not an admin
+	ErrForbidden = errors.New("user is not allowed to access this resource")
+
+	// ErrInactive is returned when the account of the user is pending, locked or disabled
+	ErrInactive = errors.New("user account is not active")
+
+	// ErrInvalidCredentials is returned when a user logs in with a username or password that
+	// is not correct
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	// ErrPasswordReset is returned when a user logs in that must set a new password first,
+	// because the password couldn't be imported from another identity provider. It is kept
+	// in the login history, but handlers respond to it like to ErrInvalidCredentials.
+	ErrPasswordReset = errors.New("the password of the user must be reset")
+)
+
+// GenerateTokenPair creates and returns a new set of access_token and refresh_token.
+func GenerateTokenPair(username string, uuid string) (string, string, error) {
+
+	tokenString, err := GenerateAccessToken(username, uuid)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Create Refresh token, this will be used to get new access token.
+	refreshToken := jwt.New(jwt.SigningMethodHS256)
+	refreshToken.Header["kid"] = RefreshTokenKeyID
+
+	expirationTimeRefreshToken := time.Now().Add(15 * time.Minute).Unix()
+
+	rtClaims := refreshToken.Claims.(jwt.MapClaims)
+	rtClaims["sub"] = uuid
+	rtClaims["exp"] = expirationTimeRefreshToken
+
+	refreshTokenString, err := refreshToken.SignedString(user.RTJWTKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	return tokenString, refreshTokenString, nil
+}
+
+// GenerateAccessToken creates and returns a new access_token.
+func GenerateAccessToken(username string, uuid string) (string, error) {
+	// Declare the expiration time of the access token
+	// Here the expiration is 5 minutes
+	expirationTimeAccessToken := time.Now().Add(5 * time.Minute).Unix()
+
+	// Declare the token with the algorithm used for signing, and the claims
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Header["kid"] = AccessTokenKeyID
+	claims := token.Claims.(jwt.MapClaims)
+	claims["Username"] = username
+	claims["exp"] = expirationTimeAccessToken
+	claims["sub"] = uuid
+
+	// Create the JWT string
+	tokenString, err := token.SignedString(user.ATJWTKey)
+	if err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// ValidateToken is used to validate both access_token and refresh_token. It is done based on the "Key ID" provided by the JWT
+func ValidateToken(tokenString string) (bool, string, string, error) {
+
+	var key []byte
+
+	var keyID string
+
+	claims := jwt.MapClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Tokens are only signed with HMAC, so a token signed with another method, or with
+		// none, is never valid
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		keyID, _ = token.Header["kid"].(string)
+		// If the "kid" (Key ID) is equal to signin_1, then it is compared against access_token secret key, else if it
+		// is equal to signin_2 , it is compared against refresh_token secret key. Any other "kid" is not valid.
+		switch keyID {
+		case AccessTokenKeyID:
+			key = user.ATJWTKey
+		case RefreshTokenKeyID:
+			key = user.RTJWTKey
+		default:
+			return nil, fmt.Errorf("unknown key id %q", keyID)
+		}
+		return key, nil
+	})
+
+	// Check if signatures are valid.
+	if err != nil {
+		if err == jwt.ErrSignatureInvalid {
+			log.Printf("Invalid Token Signature")
+			return false, "", keyID, err
+		}
+		return false, "", keyID, err
+	}
+
+	if !token.Valid {
+		log.Printf("Invalid Token")
+		return false, "", keyID, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	return true, sub, keyID, nil
+}
+
+// FromHeader returns the token from the value of an Authorization header, which
+// is expected to be in the form "Bearer <token>".
+func FromHeader(header string) string {
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// AuthorizationHeader returns the value of the Authorization header from a map of
+// headers, like the ones API Gateway sends, regardless of the casing of the header name.
+func AuthorizationHeader(headers map[string]string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, "Authorization") {
+			return v
+		}
+	}
+	return ""
+}
+
+// Active returns the account of the user a token has been issued for. When the user
+// has been deleted or erased, tokens issued before can no longer be used and
+// ErrUnauthorized is returned. When the account is not active, for example because
+// an admin has disabled it, ErrInactive is returned. Errors of the data store other than
+// datastore.ErrNotFound are returned as they are.
+func Active(ctx context.Context, db datastore.Manager, userID string) (datastore.Account, error) {
+	acct, err := db.GetUser(ctx, userID)
+	if errors.Is(err, datastore.ErrNotFound) || (err == nil && acct.Erased()) {
+		return datastore.Account{}, ErrUnauthorized
+	}
+	if err != nil {
+		return datastore.Account{}, err
+	}
+	if !acct.Active() {
+		return datastore.Account{}, ErrInactive
+	}
+	return acct, nil
+}
+
+// FindAccount returns the account of the user logging in with either their username or
+// their email address. Usernames can't contain an @, so an identifier with an @ is looked
+// up as an email address. Both are compared regardless of case.
+func FindAccount(ctx context.Context, db datastore.Manager, identifier string) (datastore.Account, error) {
+	if LoginMethod(identifier) == user.LoginMethodEmail {
+		return db.FindUserByEmail(ctx, identifier)
+	}
+	return db.FindUser(ctx, identifier)
+}
+
+// LoginMethod returns how a user logs in with the identifier, user.LoginMethodEmail for
+// identifiers with an @ and user.LoginMethodUsername otherwise
+func LoginMethod(identifier string) string {
+	if strings.Contains(identifier, "@") {
+		return user.LoginMethodEmail
+	}
+	return user.LoginMethodUsername
+}
+
+// CheckCredentials decides whether a user can log in with the password. Users that must
+// reset their password get ErrPasswordReset, whatever password they use. There is no password
+// to check them against, so the response to it must be the same as to a wrong password, or
+// it would tell anyone which accounts exist. A wrong password returns ErrInvalidCredentials
+// and an account that is not active returns ErrInactive.
+func CheckCredentials(acct datastore.Account, password string) error {
+	switch {
+	case acct.Erased():
+		return ErrInvalidCredentials
+	case acct.PasswordReset:
+		return ErrPasswordReset
+	case !acct.CheckPassword(password):
+		return ErrInvalidCredentials
+	case !acct.Active():
+		return ErrInactive
+	default:
+		return nil
+	}
+}
+
+// Authorize validates the access token in the Authorization header and makes sure it
+// has been issued to the user identified by userID, or to an admin. It returns the
+// account of the user making the request.
+func Authorize(ctx context.Context, db datastore.Manager, header string, userID string) (datastore.Account, error) {
+	valid, sub, keyID, _ := ValidateToken(FromHeader(header))
+	if !valid || keyID != AccessTokenKeyID || sub == "" {
+		return datastore.Account{}, ErrUnauthorized
+	}
+
+	acct, err := Active(ctx, db, sub)
+	if err != nil {
+		return datastore.Account{}, err
+	}
+
+	if acct.ID != userID && !acct.HasRole(datastore.RoleAdmin) {
+		return datastore.Account{}, ErrForbidden
+	}
+
+	return acct, nil
+}
+
+// AuthorizeAdmin validates the access token in the Authorization header and makes sure it
+// has been issued to an admin. It returns the account of the admin making the request.
+func AuthorizeAdmin(ctx context.Context, db datastore.Manager, header string) (datastore.Account, error) {
+	valid, sub, keyID, _ := ValidateToken(FromHeader(header))
+	if !valid || keyID != AccessTokenKeyID || sub == "" {
+		return datastore.Account{}, ErrUnauthorized
+	}
+
+	acct, err := Active(ctx, db, sub)
+	if err != nil {
+		return datastore.Account{}, err
+	}
+
+	if !acct.HasRole(datastore.RoleAdmin) {
+		return datastore.Account{}, ErrForbidden
+	}
+
+	return acct, nil
+}
+
+// IsAdmin returns true if the Authorization header carries a valid access token of an
+// admin. It is used by endpoints anyone can call, that return more data to admins.
+func IsAdmin(ctx context.Context, db datastore.Manager, header string) bool {
+	if len(header) == 0 {
+		return false
+	}
+	_, err := AuthorizeAdmin(ctx, db, header)
+	return err == nil
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/datastore/memory"
+)
+
+// account returns an active user with the password beets1234
+func account(id string, username string) datastore.Account {
+	return datastore.Account{User: acmeserverless.User{ID: id, Username: username, Password: "beets1234", Email: username + "@dunder-mifflin.com"}}
+}
+
+// Only active users with the right password log in, and users that must reset their password
+// never do
+func TestCheckCredentials(t *testing.T) {
+	dwight := account("u1", "dwight")
+
+	reset := dwight
+	reset.PasswordReset = true
+
+	imported := dwight
+	imported.Password = ""
+	imported.PasswordHash = "$2a$04$8hzvCDkuTBKLeyvJIqaKXO56.8ALoiY3OU3eLTJk5NED.bpAbP5XG"
+
+	disabled := dwight
+	disabled.Status = datastore.StatusDisabled
+
+	disabledReset := disabled
+	disabledReset.PasswordReset = true
+
+	erased := dwight.Erase(time.Now())
+
+	tests := []struct {
+		name     string
+		acct     datastore.Account
+		password string
+		want     error
+	}{
+		{name: "right password", acct: dwight, password: "beets1234", want: nil},
+		{name: "wrong password", acct: dwight, password: "beets", want: ErrInvalidCredentials},
+		{name: "imported password hash", acct: imported, password: "schrute-farms", want: nil},
+		{name: "wrong password for imported hash", acct: imported, password: "beets1234", want: ErrInvalidCredentials},
+		{name: "password reset", acct: reset, password: "beets1234", want: ErrPasswordReset},
+		{name: "password reset with any password", acct: reset, password: "", want: ErrPasswordReset},
+		{name: "inactive", acct: disabled, password: "beets1234", want: ErrInactive},
+		{name: "inactive with wrong password", acct: disabled, password: "beets", want: ErrInvalidCredentials},
+		{name: "inactive with password reset", acct: disabledReset, password: "beets1234", want: ErrPasswordReset},
+		{name: "erased", acct: erased, password: "beets1234", want: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckCredentials(tt.acct, tt.password); err != tt.want {
+				t.Errorf("CheckCredentials(%q) = %v, want %v", tt.password, err, tt.want)
+			}
+		})
+	}
+}
+
+// bearer returns the Authorization header with an access token of the user
+func bearer(t *testing.T, userID string) string {
+	token, err := GenerateAccessToken("", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+// Users can access their own data and admins the data of every user, as long as their
+// account is active
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(memory.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := account("u1", "michael")
+	admin.SetRole(datastore.RoleAdmin, true)
+	disabled := account("u3", "creed")
+	disabled.Status = datastore.StatusDisabled
+	for _, acct := range []datastore.Account{admin, account("u2", "dwight"), disabled} {
+		if err := db.AddUser(ctx, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, refresh, err := GenerateTokenPair("dwight", "u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token without a key ID isn't signed with any of the keys of the service
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "u2", "exp": time.Now().Add(time.Minute).Unix()}).SignedString([]byte("my_secret_key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		userID string
+		want   error
+	}{
+		{name: "own data", header: bearer(t, "u2"), userID: "u2", want: nil},
+		{name: "lower case scheme", header: "bearer " + bearer(t, "u2")[7:], userID: "u2", want: nil},
+		{name: "data of another user", header: bearer(t, "u2"), userID: "u1", want: ErrForbidden},
+		{name: "admin", header: bearer(t, "u1"), userID: "u2", want: nil},
+		{name: "inactive user", header: bearer(t, "u3"), userID: "u3", want: ErrInactive},
+		{name: "user that doesn't exist", header: bearer(t, "u4"), userID: "u4", want: ErrUnauthorized},
+		{name: "refresh token", header: "Bearer " + refresh, userID: "u2", want: ErrUnauthorized},
+		{name: "token without key ID", header: "Bearer " + unsigned, userID: "u2", want: ErrUnauthorized},
+		{name: "token without scheme", header: bearer(t, "u2")[7:], userID: "u2", want: ErrUnauthorized},
+		{name: "malformed token", header: "Bearer dwight", userID: "u2", want: ErrUnauthorized},
+		{name: "no header", header: "", userID: "u2", want: ErrUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Authorize(ctx, db, tt.header, tt.userID); err != tt.want {
+				t.Errorf("Authorize(%s) = %v, want %v", tt.userID, err, tt.want)
+			}
+		})
+	}
+
+	// Tokens can't be used once the user has been erased
+	if err := db.DeleteUser(ctx, "u2", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authorize(ctx, db, bearer(t, "u2"), "u2"); err != ErrUnauthorized {
+		t.Errorf("Authorize of an erased user = %v, want %v", err, ErrUnauthorized)
+	}
+}
@@ -0,0 +1,136 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// auth0User is a user in an Auth0 export. Auth0 doesn't add password hashes to its user
+// exports, they're sent on request in a separate export with the ID of the user in _id and
+// the hash in passwordHash. Users can have the fields of both exports, or the fields of the
+// Auth0 bulk user import format.
+type auth0User struct {
+	UserID     string          `json:"user_id"`
+	OID        json.RawMessage `json:"_id"`
+	Email      string          `json:"email"`
+	Username   string          `json:"username"`
+	Nickname   string          `json:"nickname"`
+	GivenName  string          `json:"given_name"`
+	FamilyName string          `json:"family_name"`
+	Name       string          `json:"name"`
+	Blocked    bool            `json:"blocked"`
+
+	// PasswordHash is the hash in the password hash export of Auth0
+	PasswordHash string `json:"passwordHash"`
+
+	// ImportHash is the bcrypt hash in the Auth0 bulk user import format
+	ImportHash string `json:"password_hash"`
+
+	// CustomHash is a hash of any algorithm in the Auth0 bulk user import format
+	CustomHash *struct {
+		Algorithm string `json:"algorithm"`
+		Hash      struct {
+			Value string `json:"value"`
+		} `json:"hash"`
+	} `json:"custom_password_hash"`
+}
+
+// auth0Reader reads the users of an Auth0 export
+type auth0Reader struct {
+	values *jsonValues
+}
+
+// newAuth0Reader returns a reader for an Auth0 export
+func newAuth0Reader(r io.Reader) (*auth0Reader, error) {
+	values, err := newJSONValues(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	return &auth0Reader{values: values}, nil
+}
+
+// next returns the next user as a row. The Auth0 ID of the user, without the name of the
+// connection, becomes the ID of the user. Users without a username get their nickname or
+// the part of their email address before the @. Users with a bcrypt hash keep their password,
+// all other users must reset their password. Blocked users are disabled.
+func (r *auth0Reader) next() ([]byte, error) {
+	raw, err := r.values.next()
+	if err != nil {
+		return nil, err
+	}
+
+	var u auth0User
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return nil, rowError(fmt.Sprintf("the user is not a valid Auth0 user: %s", err.Error()))
+	}
+
+	firstname, lastname := u.GivenName, u.FamilyName
+	if len(firstname) == 0 && len(lastname) == 0 {
+		firstname, lastname = splitName(u.Name)
+	}
+
+	row := map[string]interface{}{
+		"username":  firstOf(u.Username, u.Nickname, localPart(u.Email)),
+		"firstname": firstname,
+		"lastname":  lastname,
+		"email":     u.Email,
+	}
+
+	if id := u.id(); len(id) > 0 {
+		row["id"] = id
+	}
+
+	if hash := u.hash(); len(hash) > 0 {
+		row["passwordHash"] = hash
+	} else {
+		row["passwordReset"] = true
+	}
+
+	if u.Blocked {
+		row["status"] = datastore.StatusDisabled
+	}
+
+	return json.Marshal(row)
+}
+
+// id returns the ID of the user. The user_id of Auth0 starts with the name of the
+// connection, like auth0|5f7c8ec7c33c6c004bbafe82, which is removed. The password hash
+// export has the ID as an ObjectId.
+func (u auth0User) id() string {
+	if len(u.UserID) > 0 {
+		return u.UserID[strings.LastIndex(u.UserID, "|")+1:]
+	}
+
+	var oid struct {
+		OID string `json:"$oid"`
+	}
+	if err := json.Unmarshal(u.OID, &oid); err == nil && len(oid.OID) > 0 {
+		return oid.OID
+	}
+
+	var id string
+	if err := json.Unmarshal(u.OID, &id); err == nil {
+		return id
+	}
+
+	return ""
+}
+
+// hash returns the bcrypt hash of the password of the user, or an empty string if the
+// user has no password hash or the password is hashed with another algorithm
+func (u auth0User) hash() string {
+	hash := firstOf(u.PasswordHash, u.ImportHash)
+	if len(hash) == 0 && u.CustomHash != nil && u.CustomHash.Algorithm == "bcrypt" {
+		hash = u.CustomHash.Hash.Value
+	}
+
+	if bcryptHash(hash) {
+		return hash
+	}
+	return ""
+}
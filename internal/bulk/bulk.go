@@ -0,0 +1,275 @@
+// Package bulk imports and exports the users of the User service in the ACME Serverless
+// Fitness Shop as CSV or JSON Lines. Imports and exports are streamed, so files with tens
+// of thousands of users never have to be held in memory as a whole.
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gofrs/uuid"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/validation"
+)
+
+const (
+	// FormatCSV is a comma separated file with a header row that names the columns
+	FormatCSV = "csv"
+
+	// FormatJSONL is a file with a JSON object on each line
+	FormatJSONL = "jsonl"
+
+	// FormatAuth0 is a user export of Auth0, a JSON array or a file with a JSON object on
+	// each line
+	FormatAuth0 = "auth0"
+
+	// FormatCognito is a user export of an Amazon Cognito user pool, either the JSON output
+	// of list-users or a CSV file with the attributes of the user pool as columns
+	FormatCognito = "cognito"
+)
+
+// DefaultBatchSize is the number of users that are written to the datastore at once
+const DefaultBatchSize = 100
+
+const (
+	// StatusImported is the status of a row of which the user has been stored
+	StatusImported = "imported"
+
+	// StatusValid is the status of a row that passed validation in a dry run
+	StatusValid = "valid"
+
+	// StatusInvalid is the status of a row that didn't pass validation
+	StatusInvalid = "invalid"
+
+	// StatusExists is the status of a row with a username or email address that is already
+	// used, in the datastore or in an earlier row
+	StatusExists = "exists"
+
+	// StatusFailed is the status of a row of which the user couldn't be stored
+	StatusFailed = "failed"
+)
+
+// ErrUnknownFormat is returned for formats that are not supported
+var ErrUnknownFormat = errors.New("unknown format, the format must be csv, jsonl, auth0 or cognito")
+
+// Options control how users are imported
+type Options struct {
+	// Format is the format of the file, FormatCSV or FormatJSONL
+	Format string
+
+	// DryRun validates the rows without storing any users
+	DryRun bool
+
+	// ResumeAfter skips the rows up to and including this row, to resume an import that
+	// stopped. Rows are numbered from 1 and the header of a CSV file is not a row.
+	ResumeAfter int
+
+	// BatchSize is the number of users that are written at once, DefaultBatchSize if zero
+	BatchSize int
+
+	// Checkpoint, if set, is called with the number of the last row after each batch is
+	// written. All rows up to and including that row have been handled, so an import that
+	// stops can be resumed from it with ResumeAfter. Checkpoint isn't called in a dry run.
+	Checkpoint func(row int) error
+}
+
+// count adds the outcome of a row to the summary
+func count(s *user.ImportSummary, res user.ImportResult) {
+	s.Rows++
+	s.LastRow = res.Row
+
+	switch res.Status {
+	case StatusImported:
+		s.Imported++
+		if res.PasswordReset {
+			s.PasswordReset++
+		}
+	case StatusValid:
+		s.Valid++
+	case StatusInvalid:
+		s.Invalid++
+	case StatusExists:
+		s.Exists++
+	case StatusFailed:
+		s.Failed++
+	}
+}
+
+// importer holds the state of a running import
+type importer struct {
+	db     datastore.Manager
+	opts   Options
+	report func(user.ImportResult) error
+
+	summary user.ImportSummary
+
+	// seen are the normalized usernames and email addresses of the earlier rows
+	seen map[string]bool
+
+	// results are the rows of the current batch, in the order of the file, and accts are
+	// the users of the valid rows among them
+	results []user.ImportResult
+	accts   []datastore.Account
+	index   []int
+}
+
+// Import reads users from r and stores them in the datastore in batches. Each row is
+// validated with the same rules as a registration. Report is called with the outcome of
+// each row, in the order of the file, and can stop the import by returning an error.
+// Rows that fail don't stop the import, but an error reading the file or writing a batch
+// does. Users without an ID get a new one.
+func Import(ctx context.Context, db datastore.Manager, r io.Reader, opts Options, report func(user.ImportResult) error) (user.ImportSummary, error) {
+	rows, err := newReader(opts.Format, r)
+	if err != nil {
+		return user.ImportSummary{}, err
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	imp := &importer{
+		db:     db,
+		opts:   opts,
+		report: report,
+		seen:   make(map[string]bool),
+	}
+
+	for row := 1; ; row++ {
+		data, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		rerr, invalid := err.(rowError)
+		if err != nil && !invalid {
+			return imp.summary, fmt.Errorf("unable to read row %d: %s", row, err.Error())
+		}
+
+		if row <= opts.ResumeAfter {
+			continue
+		}
+
+		if invalid {
+			imp.results = append(imp.results, user.ImportResult{Row: row, Status: StatusInvalid, Message: rerr.Error()})
+		} else {
+			imp.add(row, data)
+		}
+
+		if len(imp.results) >= opts.BatchSize {
+			if err := imp.flush(ctx); err != nil {
+				return imp.summary, err
+			}
+		}
+	}
+
+	return imp.summary, imp.flush(ctx)
+}
+
+// add validates a row and adds it to the current batch
+func (imp *importer) add(row int, data []byte) {
+	acct, err := validation.Import(data)
+
+	res := user.ImportResult{
+		Row:      row,
+		ID:       acct.ID,
+		Username: acct.Username,
+	}
+
+	if verr, ok := err.(*validation.Error); ok {
+		res.Status = StatusInvalid
+		res.Message = "the row contains invalid fields"
+		res.Errors = verr.Fields
+		imp.results = append(imp.results, res)
+		return
+	}
+
+	keys := []string{fmt.Sprintf("username:%s", datastore.NormalizeUsername(acct.Username))}
+	if len(acct.Email) > 0 {
+		keys = append(keys, fmt.Sprintf("email:%s", datastore.NormalizeEmail(acct.Email)))
+	}
+	for _, key := range keys {
+		if imp.seen[key] {
+			res.Status = StatusExists
+			res.Message = "the username or email address is used in an earlier row"
+			imp.results = append(imp.results, res)
+			return
+		}
+	}
+	for _, key := range keys {
+		imp.seen[key] = true
+	}
+
+	if len(acct.ID) == 0 {
+		acct.ID = uuid.Must(uuid.NewV4()).String()
+		res.ID = acct.ID
+	}
+
+	if imp.opts.DryRun {
+		res.Status = StatusValid
+		imp.results = append(imp.results, res)
+		return
+	}
+
+	imp.index = append(imp.index, len(imp.results))
+	imp.results = append(imp.results, res)
+	imp.accts = append(imp.accts, acct)
+}
+
+// flush writes the users of the current batch and reports the outcome of its rows
+func (imp *importer) flush(ctx context.Context) error {
+	if len(imp.results) == 0 {
+		return nil
+	}
+
+	if len(imp.accts) > 0 {
+		errs, err := datastore.AddUsers(ctx, imp.db, imp.accts)
+		if err != nil {
+			return fmt.Errorf("unable to store the users of rows %d to %d: %s", imp.results[0].Row, imp.results[len(imp.results)-1].Row, err.Error())
+		}
+
+		for idx, err := range errs {
+			res := &imp.results[imp.index[idx]]
+			switch {
+			case err == nil:
+				res.Status = StatusImported
+				if imp.accts[idx].PasswordReset {
+					// There is no way for the user to set a password, so an operator has to
+					// set one
+					res.PasswordReset = true
+					res.Message = fmt.Sprintf("the user can't log in until a new password is set with user-admin reset-password %s", res.ID)
+				}
+			case err == datastore.ErrUserExists:
+				res.Status = StatusExists
+				res.Message = err.Error()
+				res.ID = ""
+			default:
+				res.Status = StatusFailed
+				res.Message = err.Error()
+				res.ID = ""
+			}
+		}
+	}
+
+	for _, res := range imp.results {
+		count(&imp.summary, res)
+		if imp.report != nil {
+			if err := imp.report(res); err != nil {
+				return err
+			}
+		}
+	}
+
+	last := imp.results[len(imp.results)-1].Row
+	imp.results = imp.results[:0]
+	imp.accts = imp.accts[:0]
+	imp.index = imp.index[:0]
+
+	if imp.opts.DryRun || imp.opts.Checkpoint == nil {
+		return nil
+	}
+
+	return imp.opts.Checkpoint(last)
+}
@@ -0,0 +1,158 @@
+package bulk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/datastore/memory"
+)
+
+// result is the part of the outcome of a row the import tests check
+type result struct {
+	status        string
+	passwordReset bool
+}
+
+// Each row is imported, or reported as invalid or as a duplicate of a stored user or an
+// earlier row, and users without a password are imported to reset their password
+func TestImport(t *testing.T) {
+	jim := datastore.Account{User: acmeserverless.User{ID: "u2", Username: "Jim", Email: "JIM@dunder-mifflin.com"}}
+
+	tests := []struct {
+		name     string
+		format   string
+		file     string
+		existing []datastore.Account
+		want     []result
+		summary  user.ImportSummary
+	}{
+		{
+			name:     "csv",
+			format:   FormatCSV,
+			file:     "users.csv",
+			existing: []datastore.Account{jim},
+			want:     []result{{StatusImported, false}, {StatusExists, false}, {StatusImported, true}, {StatusInvalid, false}, {StatusInvalid, false}},
+			summary:  user.ImportSummary{Rows: 5, Imported: 2, Invalid: 2, Exists: 1, PasswordReset: 1, LastRow: 5},
+		},
+		{
+			name:    "jsonl",
+			format:  FormatJSONL,
+			file:    "users.jsonl",
+			want:    []result{{StatusImported, false}, {StatusExists, false}, {StatusImported, true}},
+			summary: user.ImportSummary{Rows: 3, Imported: 2, Exists: 1, PasswordReset: 1, LastRow: 3},
+		},
+		{
+			name:    "auth0",
+			format:  FormatAuth0,
+			file:    "auth0.json",
+			want:    []result{{StatusImported, false}, {StatusImported, true}, {StatusImported, true}, {StatusImported, false}, {StatusExists, false}, {StatusInvalid, false}},
+			summary: user.ImportSummary{Rows: 6, Imported: 4, Invalid: 1, Exists: 1, PasswordReset: 2, LastRow: 6},
+		},
+		{
+			name:    "cognito json",
+			format:  FormatCognito,
+			file:    "cognito.json",
+			want:    []result{{StatusImported, true}, {StatusImported, true}, {StatusImported, true}, {StatusExists, false}},
+			summary: user.ImportSummary{Rows: 4, Imported: 3, Exists: 1, PasswordReset: 3, LastRow: 4},
+		},
+		{
+			name:    "cognito csv",
+			format:  FormatCognito,
+			file:    "cognito.csv",
+			want:    []result{{StatusImported, true}, {StatusImported, true}, {StatusInvalid, false}, {StatusExists, false}},
+			summary: user.ImportSummary{Rows: 4, Imported: 2, Invalid: 1, Exists: 1, PasswordReset: 2, LastRow: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db, err := memory.New(memory.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, acct := range tt.existing {
+				if err := db.AddUser(ctx, acct); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var results []user.ImportResult
+			summary, err := Import(ctx, db, f, Options{Format: tt.format, BatchSize: 2}, func(res user.ImportResult) error {
+				results = append(results, res)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Import: %s", err.Error())
+			}
+
+			if summary != tt.summary {
+				t.Errorf("summary = %+v, want %+v", summary, tt.summary)
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d results, want %d: %+v", len(results), len(tt.want), results)
+			}
+
+			for idx, res := range results {
+				if res.Row != idx+1 {
+					t.Errorf("result %d is for row %d", idx+1, res.Row)
+				}
+				if got := (result{res.Status, res.PasswordReset}); got != tt.want[idx] {
+					t.Errorf("row %d = %+v, want %+v", res.Row, got, tt.want[idx])
+				}
+				if res.Status != StatusImported {
+					continue
+				}
+
+				// The flag is stored with the user, so it can't log in until a password is set
+				acct, err := db.GetUser(ctx, res.ID)
+				if err != nil {
+					t.Errorf("GetUser of row %d: %s", res.Row, err.Error())
+					continue
+				}
+				if acct.PasswordReset != res.PasswordReset {
+					t.Errorf("row %d stored passwordReset %t, want %t", res.Row, acct.PasswordReset, res.PasswordReset)
+				}
+			}
+		})
+	}
+}
+
+// A dry run validates the rows without storing any user
+func TestImportDryRun(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(memory.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join("testdata", "auth0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	summary, err := Import(ctx, db, f, Options{Format: FormatAuth0, DryRun: true}, nil)
+	if err != nil {
+		t.Fatalf("Import: %s", err.Error())
+	}
+
+	want := user.ImportSummary{Rows: 6, Valid: 4, Invalid: 1, Exists: 1, LastRow: 6}
+	if summary != want {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+	if _, err := db.FindUser(ctx, "dwight"); err == nil {
+		t.Error("a dry run stored a user")
+	}
+}
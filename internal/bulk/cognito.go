@@ -0,0 +1,175 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// cognitoAttribute is an attribute of a user in an Amazon Cognito user pool
+type cognitoAttribute struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// cognitoUser is a user in the output of the list-users and admin-get-user commands of
+// Amazon Cognito
+type cognitoUser struct {
+	Username       string             `json:"Username"`
+	Attributes     []cognitoAttribute `json:"Attributes"`
+	UserAttributes []cognitoAttribute `json:"UserAttributes"`
+	Enabled        *bool              `json:"Enabled"`
+	UserStatus     string             `json:"UserStatus"`
+}
+
+// cognitoRecord is a single JSON object in a Cognito export: either a page of users, as
+// returned by list-users, or a single user
+type cognitoRecord struct {
+	cognitoUser
+	Users []cognitoUser `json:"Users"`
+}
+
+// cognitoReader reads the users of a Cognito export. Cognito never exports passwords, so
+// all users must reset their password.
+type cognitoReader struct {
+	// values reads a JSON export and queue holds the users of the last page that was read
+	values *jsonValues
+	queue  []cognitoUser
+
+	// csv reads a CSV export with the names of the attributes in the header
+	csv    *csv.Reader
+	header []string
+}
+
+// newCognitoReader returns a reader for a Cognito export, which is read as JSON if it
+// starts with an object or array and as CSV otherwise
+func newCognitoReader(r io.Reader) (*cognitoReader, error) {
+	br := bufio.NewReader(r)
+
+	first, err := firstByte(br)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if first == '{' || first == '[' {
+		values, err := newJSONValues(br)
+		if err != nil {
+			return nil, err
+		}
+		return &cognitoReader{values: values}, nil
+	}
+
+	cr := csv.NewReader(br)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file has no header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header row: %s", err.Error())
+	}
+
+	found := false
+	for idx, h := range header {
+		header[idx] = strings.TrimSpace(h)
+		found = found || header[idx] == "cognito:username"
+	}
+	if !found {
+		return nil, fmt.Errorf("the required column %q is missing", "cognito:username")
+	}
+
+	return &cognitoReader{csv: cr, header: header}, nil
+}
+
+// next returns the next user as a row
+func (r *cognitoReader) next() ([]byte, error) {
+	if r.csv != nil {
+		return r.nextCSV()
+	}
+
+	for len(r.queue) == 0 {
+		raw, err := r.values.next()
+		if err != nil {
+			return nil, err
+		}
+
+		var rec cognitoRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return nil, rowError(fmt.Sprintf("the user is not a valid Cognito user: %s", err.Error()))
+		}
+
+		if rec.Users != nil {
+			r.queue = rec.Users
+		} else {
+			r.queue = []cognitoUser{rec.cognitoUser}
+		}
+	}
+
+	u := r.queue[0]
+	r.queue = r.queue[1:]
+
+	attrs := make(map[string]string)
+	for _, a := range append(u.Attributes, u.UserAttributes...) {
+		attrs[a.Name] = a.Value
+	}
+
+	status := ""
+	switch {
+	case u.Enabled != nil && !*u.Enabled:
+		status = datastore.StatusDisabled
+	case u.UserStatus == "UNCONFIRMED":
+		status = datastore.StatusPending
+	}
+
+	return cognitoRow(u.Username, attrs, status)
+}
+
+// nextCSV returns the next row of a CSV export
+func (r *cognitoReader) nextCSV() ([]byte, error) {
+	record, err := r.csv.Read()
+	if perr, ok := err.(*csv.ParseError); ok && perr.Err == csv.ErrFieldCount {
+		return nil, errColumnCount
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]string, len(record))
+	for idx, value := range record {
+		attrs[r.header[idx]] = value
+	}
+
+	return cognitoRow(attrs["cognito:username"], attrs, "")
+}
+
+// cognitoRow returns the row of a Cognito user. The sub of the user becomes the ID of the
+// user and the preferred username, if there is one, the username. Cognito usernames that
+// are email addresses are replaced by the part before the @.
+func cognitoRow(username string, attrs map[string]string, status string) ([]byte, error) {
+	firstname, lastname := attrs["given_name"], attrs["family_name"]
+	if len(firstname) == 0 && len(lastname) == 0 {
+		firstname, lastname = splitName(attrs["name"])
+	}
+
+	row := map[string]interface{}{
+		"username":      localPart(firstOf(attrs["preferred_username"], username)),
+		"firstname":     firstname,
+		"lastname":      lastname,
+		"email":         attrs["email"],
+		"passwordReset": true,
+	}
+
+	if len(attrs["sub"]) > 0 {
+		row["id"] = attrs["sub"]
+	}
+
+	if len(status) > 0 {
+		row["status"] = status
+	}
+
+	return json.Marshal(row)
+}
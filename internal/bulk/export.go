@@ -0,0 +1,102 @@
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// exportColumns are the columns of an exported CSV file. Passwords are never exported.
+var exportColumns = []string{"id", "username", "firstname", "lastname", "email", "status", "roles"}
+
+// writer writes exported users one at a time
+type writer interface {
+	// write writes a single user
+	write(acct datastore.Account) error
+
+	// flush writes any buffered data
+	flush() error
+}
+
+// Export writes all users that have not been erased to w, one page at a time, and returns
+// the number of users written. CSV files have the columns id, username, firstname,
+// lastname, email, status and roles, and JSON Lines files have the admin view of a user
+// on each line. Passwords are never exported.
+func Export(ctx context.Context, db datastore.Manager, w io.Writer, format string) (int, error) {
+	var out writer
+
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportColumns); err != nil {
+			return 0, err
+		}
+		out = csvWriter{w: cw}
+	case FormatJSONL:
+		out = jsonlWriter{enc: json.NewEncoder(w)}
+	default:
+		return 0, ErrUnknownFormat
+	}
+
+	count := 0
+	cursor := ""
+
+	for {
+		page, err := db.ListUsers(ctx, datastore.MaxPageSize, cursor)
+		if err != nil {
+			return count, err
+		}
+
+		for _, acct := range page.Users {
+			if err := out.write(acct); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		// Flush after each page so the users are sent while the next page is read
+		if err := out.flush(); err != nil {
+			return count, err
+		}
+
+		if len(page.Next) == 0 {
+			return count, nil
+		}
+		cursor = page.Next
+	}
+}
+
+// csvWriter writes a row for each user
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// write writes the row of a user, with the roles separated by semicolons
+func (c csvWriter) write(acct datastore.Account) error {
+	return c.w.Write([]string{acct.ID, acct.Username, acct.Firstname, acct.Lastname, acct.Email, acct.State(), strings.Join(acct.Roles, ";")})
+}
+
+// flush writes the buffered rows
+func (c csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter writes the admin view of each user on a line
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+// write writes the line of a user
+func (j jsonlWriter) write(acct datastore.Account) error {
+	return j.enc.Encode(acct.Admin())
+}
+
+// flush does nothing, each line is written as soon as it's encoded
+func (j jsonlWriter) flush() error {
+	return nil
+}
@@ -0,0 +1,255 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxLineLength is the longest line a JSON Lines file can have
+const maxLineLength = 1024 * 1024
+
+// columns are the columns a CSV file can have. Roles are separated by semicolons. Users
+// need a password, the bcrypt hash of their password or passwordReset set to true.
+var columns = []string{"id", "username", "password", "passwordHash", "passwordReset", "firstname", "lastname", "email", "roles", "status"}
+
+// required are the columns a CSV file must have
+var required = []string{"username", "firstname", "lastname", "email"}
+
+// rowError is returned by a reader for a row that can't be turned into a user. The row is
+// invalid, but the rows after it can still be read.
+type rowError string
+
+// Error returns why the row can't be read
+func (e rowError) Error() string {
+	return string(e)
+}
+
+// errColumnCount is returned for a row of a CSV file that doesn't have the same number of
+// columns as the header
+const errColumnCount = rowError("the row doesn't have the same number of columns as the header")
+
+// reader reads the rows of a file one at a time
+type reader interface {
+	// next returns the next row as a JSON object, or io.EOF when there are no more rows
+	next() ([]byte, error)
+}
+
+// newReader returns the reader for the format
+func newReader(format string, r io.Reader) (reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL:
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+		return &jsonlReader{s: s}, nil
+	case FormatAuth0:
+		return newAuth0Reader(r)
+	case FormatCognito:
+		return newCognitoReader(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// jsonlReader reads a JSON object from each line. Empty lines are skipped and are not
+// counted as rows.
+type jsonlReader struct {
+	s *bufio.Scanner
+}
+
+// next returns the next line that isn't empty
+func (r *jsonlReader) next() ([]byte, error) {
+	for r.s.Scan() {
+		line := bytes.TrimSpace(r.s.Bytes())
+		if len(line) > 0 {
+			return line, nil
+		}
+	}
+
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// csvReader reads rows from a CSV file with a header row. The columns are matched by the
+// name in the header, so they can be in any order.
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+// newCSVReader reads and checks the header of a CSV file
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file has no header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header row: %s", err.Error())
+	}
+
+	// Column names are matched regardless of their case
+	known := make(map[string]string, len(columns))
+	for _, c := range columns {
+		known[strings.ToLower(c)] = c
+	}
+
+	names := make([]string, len(header))
+	found := make(map[string]bool, len(header))
+	for idx, h := range header {
+		name, ok := known[strings.ToLower(strings.TrimSpace(h))]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q, the columns can be %s", h, strings.Join(columns, ", "))
+		}
+		if found[name] {
+			return nil, fmt.Errorf("column %q appears more than once", h)
+		}
+		found[name] = true
+		names[idx] = name
+	}
+
+	for _, c := range required {
+		if !found[c] {
+			return nil, fmt.Errorf("the required column %q is missing", c)
+		}
+	}
+
+	return &csvReader{r: cr, header: names}, nil
+}
+
+// next returns the next row as a JSON object with a field for each column. Empty columns
+// are left out, except for the columns a registration needs, so those are reported as
+// required when they're empty.
+func (r *csvReader) next() ([]byte, error) {
+	record, err := r.r.Read()
+	if perr, ok := err.(*csv.ParseError); ok && perr.Err == csv.ErrFieldCount {
+		return nil, errColumnCount
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]interface{}, len(record))
+	for idx, value := range record {
+		name := r.header[idx]
+		switch {
+		case name == "roles":
+			roles := make([]string, 0)
+			for _, role := range strings.Split(value, ";") {
+				if role = strings.TrimSpace(role); len(role) > 0 {
+					roles = append(roles, role)
+				}
+			}
+			row[name] = roles
+		case name != "username" && name != "firstname" && name != "lastname" && name != "email" && len(value) == 0:
+			continue
+		case name == "passwordReset":
+			// Values that are not a boolean are kept as they are, so they fail validation
+			if reset, err := strconv.ParseBool(value); err == nil {
+				row[name] = reset
+			} else {
+				row[name] = value
+			}
+		default:
+			row[name] = value
+		}
+	}
+
+	return json.Marshal(row)
+}
+
+// jsonValues reads the objects of a JSON file that is either a JSON array of objects or a
+// sequence of objects, like a JSON Lines file. The objects are decoded one at a time.
+type jsonValues struct {
+	dec   *json.Decoder
+	array bool
+}
+
+// newJSONValues checks whether the file starts with a JSON array
+func newJSONValues(br *bufio.Reader) (*jsonValues, error) {
+	first, err := firstByte(br)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	v := &jsonValues{dec: json.NewDecoder(br), array: first == '['}
+	if v.array {
+		// Skip the opening bracket of the array
+		if _, err := v.dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// next returns the next object, or io.EOF when there are no more objects
+func (v *jsonValues) next() (json.RawMessage, error) {
+	if v.array && !v.dec.More() {
+		return nil, io.EOF
+	}
+
+	var raw json.RawMessage
+	if err := v.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// firstByte returns the first byte of the file that isn't white space, without reading it
+func firstByte(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+// splitName splits a full name into a first name and a last name at the first space
+func splitName(name string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(name), " ", 2)
+	if len(parts) < 2 || strings.Contains(name, "@") {
+		return "", ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+// localPart returns the part of an email address before the @
+func localPart(email string) string {
+	if idx := strings.LastIndex(email, "@"); idx > 0 {
+		return email[:idx]
+	}
+	return email
+}
+
+// bcryptHash returns true if the hash is a bcrypt hash. Other hashes can't be checked by
+// the User service.
+func bcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// firstOf returns the first value that isn't empty
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package bulk
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// hash is the bcrypt hash of the users in the fixtures that keep their password
+const hash = "$2a$04$8hzvCDkuTBKLeyvJIqaKXO56.8ALoiY3OU3eLTJk5NED.bpAbP5XG"
+
+// readRows reads all rows of a fixture in testdata. Rows that can't be read are nil.
+func readRows(t *testing.T, format string, file string) []map[string]interface{} {
+	f, err := os.Open(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := newReader(format, f)
+	if err != nil {
+		t.Fatalf("newReader(%s): %s", file, err.Error())
+	}
+
+	var rows []map[string]interface{}
+	for {
+		data, err := r.next()
+		if err == io.EOF {
+			return rows
+		}
+		if _, ok := err.(rowError); ok {
+			rows = append(rows, nil)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("next(%s) after %d rows: %s", file, len(rows), err.Error())
+		}
+
+		var row map[string]interface{}
+		if err := json.Unmarshal(data, &row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// The readers turn the users of each format into rows with the fields of a registration
+func TestReaders(t *testing.T) {
+	noRoles := []interface{}{}
+
+	tests := []struct {
+		name   string
+		format string
+		file   string
+		want   []map[string]interface{}
+	}{
+		{
+			name:   "csv",
+			format: FormatCSV,
+			file:   "users.csv",
+			want: []map[string]interface{}{
+				{"id": "u1", "username": "pam", "firstname": "Pam", "lastname": "Beesly", "email": "pam@dunder-mifflin.com", "password": "beesly123", "roles": []interface{}{"admin"}},
+				{"username": "jim", "firstname": "Jim", "lastname": "Halpert", "email": "jim@dunder-mifflin.com", "passwordHash": hash, "roles": noRoles, "status": "locked"},
+				{"username": "creed", "firstname": "Creed", "lastname": "Bratton", "email": "creed@dunder-mifflin.com", "passwordReset": true, "roles": noRoles},
+				{"username": "kevin", "firstname": "Kevin", "lastname": "Malone", "email": "kevin@dunder-mifflin.com", "passwordReset": "maybe", "roles": noRoles},
+				nil,
+			},
+		},
+		{
+			name:   "jsonl skips empty lines",
+			format: FormatJSONL,
+			file:   "users.jsonl",
+			want: []map[string]interface{}{
+				{"id": "u1", "username": "pam", "firstname": "Pam", "lastname": "Beesly", "email": "pam@dunder-mifflin.com", "password": "beesly123"},
+				{"username": "Pam", "firstname": "Pam", "lastname": "Beesly", "email": "beesly@dunder-mifflin.com", "password": "beesly123"},
+				{"username": "creed", "firstname": "Creed", "lastname": "Bratton", "email": "creed@dunder-mifflin.com", "passwordReset": true},
+			},
+		},
+		{
+			name:   "auth0",
+			format: FormatAuth0,
+			file:   "auth0.json",
+			want: []map[string]interface{}{
+				{"id": "5f7c8ec7c33c6c004bbafe82", "username": "dwight", "firstname": "Dwight", "lastname": "Schrute", "email": "dwight@dunder-mifflin.com", "passwordHash": hash},
+				{"id": "5f7c8ec7c33c6c004bbafe83", "username": "pambeesly", "firstname": "Pam", "lastname": "Beesly", "email": "pam@dunder-mifflin.com", "passwordReset": true, "status": "disabled"},
+				{"id": "1234", "username": "jim", "firstname": "Jim", "lastname": "Halpert", "email": "jim@dunder-mifflin.com", "passwordReset": true},
+				{"id": "5f7c8ec7c33c6c004bbafe85", "username": "angela", "firstname": "Angela", "lastname": "Martin", "email": "angela@dunder-mifflin.com", "passwordHash": hash},
+				{"id": "5f7c8ec7c33c6c004bbafe86", "username": "DWIGHT", "firstname": "Dwight", "lastname": "Schrute", "email": "Dwight@Dunder-Mifflin.com", "passwordHash": hash},
+				nil,
+			},
+		},
+		{
+			name:   "cognito json",
+			format: FormatCognito,
+			file:   "cognito.json",
+			want: []map[string]interface{}{
+				{"id": "c1a4e3b0-0001", "username": "dwight", "firstname": "Dwight", "lastname": "Schrute", "email": "dwight@dunder-mifflin.com", "passwordReset": true},
+				{"id": "c1a4e3b0-0002", "username": "pam", "firstname": "Pam", "lastname": "Beesly", "email": "pam@dunder-mifflin.com", "passwordReset": true, "status": "disabled"},
+				{"id": "c1a4e3b0-0003", "username": "jimhalpert", "firstname": "Jim", "lastname": "Halpert", "email": "jim@dunder-mifflin.com", "passwordReset": true, "status": "pending"},
+				{"id": "c1a4e3b0-0004", "username": "Dwight", "firstname": "Dwight", "lastname": "Schrute", "email": "schrute@dunder-mifflin.com", "passwordReset": true},
+			},
+		},
+		{
+			name:   "cognito csv",
+			format: FormatCognito,
+			file:   "cognito.csv",
+			want: []map[string]interface{}{
+				{"id": "c1a4e3b0-0001", "username": "dwight", "firstname": "Dwight", "lastname": "Schrute", "email": "dwight@dunder-mifflin.com", "passwordReset": true},
+				{"id": "c1a4e3b0-0002", "username": "pam", "firstname": "Pam", "lastname": "Beesly", "email": "pam@dunder-mifflin.com", "passwordReset": true},
+				nil,
+				{"id": "c1a4e3b0-0005", "username": "jim", "firstname": "Jim", "lastname": "Halpert", "email": "DWIGHT@dunder-mifflin.com", "passwordReset": true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readRows(t, tt.format, tt.file)
+			if len(got) != len(tt.want) {
+				t.Fatalf("read %d rows, want %d: %v", len(got), len(tt.want), got)
+			}
+			for idx := range got {
+				if !reflect.DeepEqual(got[idx], tt.want[idx]) {
+					t.Errorf("row %d = %v, want %v", idx+1, got[idx], tt.want[idx])
+				}
+			}
+		})
+	}
+}
+
+// Files that can't be read as the format fail before any row is read
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		file   string
+	}{
+		{name: "unknown csv column", format: FormatCSV, file: "cognito.csv"},
+		{name: "cognito csv without usernames", format: FormatCognito, file: "users.csv"},
+		{name: "unknown format", format: "xml", file: "users.csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			if _, err := newReader(tt.format, f); err == nil {
+				t.Errorf("newReader(%s, %s) succeeded, want an error", tt.format, tt.file)
+			}
+		})
+	}
+}
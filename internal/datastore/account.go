@@ -0,0 +1,279 @@
+package datastore
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// RoleAdmin is the role of users that can manage the accounts of other users
+	RoleAdmin = "admin"
+)
+
+// ValidRole returns true if the role can be assigned to an account
+func ValidRole(role string) bool {
+	return role == RoleAdmin
+}
+
+const (
+	// StatusPending is the status of users that can't log in yet
+	StatusPending = "pending"
+
+	// StatusActive is the status of users that can log in
+	StatusActive = "active"
+
+	// StatusLocked is the status of users that can't log in until an admin unlocks the account
+	StatusLocked = "locked"
+
+	// StatusDisabled is the status of users whose account has been disabled by an admin
+	StatusDisabled = "disabled"
+
+	// StatusErased is the status of users whose personal data has been erased. This status
+	// can't be set directly, it's the result of erasing the account.
+	StatusErased = "erased"
+)
+
+// Account is a user of the ACME Serverless Fitness Shop together with the
+// data the User service keeps to manage that user. The JSON encoding of an
+// Account is a superset of the JSON encoding of the user, so existing records
+// can be read as an Account too.
+type Account struct {
+	acmeserverless.User
+
+	// Roles are the roles that have been assigned to the user
+	Roles []string `json:"roles,omitempty"`
+
+	// ErasedAt is the Unix timestamp at which the personal data of the user
+	// has been erased, or 0 if the account has not been erased
+	ErasedAt int64 `json:"erasedAt,omitempty"`
+
+	// Status is the status of the account. Accounts that were stored before
+	// statuses existed have no status and are active.
+	Status string `json:"status,omitempty"`
+
+	// StatusHistory are the changes of the status of the account, oldest first
+	StatusHistory []StatusChange `json:"statusHistory,omitempty"`
+
+	// Preferences are the settings of the user, or nil if the user hasn't changed them
+	Preferences *user.Preferences `json:"preferences,omitempty"`
+
+	// PasswordHash is the bcrypt hash of the password of a user that has been imported
+	// from another identity provider. When it is set, the password of the user is unknown
+	// and logins are checked against the hash.
+	PasswordHash string `json:"passwordHash,omitempty"`
+
+	// PasswordReset is true when the user must set a new password before logging in,
+	// because the password of the user couldn't be imported
+	PasswordReset bool `json:"passwordReset,omitempty"`
+
+	// Version is incremented each time the account is stored. Accounts that were stored
+	// before versions existed have version 0.
+	Version int64 `json:"version,omitempty"`
+
+	// CreatedAt is the Unix timestamp at which the account was first stored. Accounts that
+	// were stored before timestamps existed have no creation time.
+	CreatedAt int64 `json:"createdAt,omitempty"`
+
+	// UpdatedAt is the Unix timestamp at which the account was last stored
+	UpdatedAt int64 `json:"updatedAt,omitempty"`
+
+	// LastLoginAt is the Unix timestamp of the last successful login of the user, or 0 if
+	// the user hasn't logged in since logins were recorded. Data stores keep it next to the
+	// account instead of in it, so a login doesn't change the version of the user.
+	LastLoginAt int64 `json:"lastLoginAt,omitempty"`
+}
+
+// StatusChange is a change of the status of an account, with the reason why the
+// status has been changed
+type StatusChange struct {
+	// From is the status before the change
+	From string `json:"from"`
+
+	// To is the status after the change
+	To string `json:"to"`
+
+	// Reason is a note explaining why the status has been changed
+	Reason string `json:"reason"`
+
+	// ChangedBy is the ID of the admin that changed the status, or the name of the
+	// tool the status was changed with
+	ChangedBy string `json:"changedBy"`
+
+	// ChangedAt is the Unix timestamp at which the status has been changed
+	ChangedAt int64 `json:"changedAt"`
+}
+
+// ValidStatus returns true if the status can be assigned to an account
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusActive, StatusLocked, StatusDisabled:
+		return true
+	default:
+		return false
+	}
+}
+
+// UnmarshalAccount parses the JSON-encoded data and stores the result in an Account
+func UnmarshalAccount(data string) (Account, error) {
+	var r Account
+	err := json.Unmarshal([]byte(data), &r)
+	return r, err
+}
+
+// UnmarshalStoredAccount parses the JSON-encoded data like UnmarshalAccount and sets the time
+// of the last login the data store keeps next to it. Accounts that were stored before the last
+// login was kept apart have it in the data, so the latest of the two is used.
+func UnmarshalStoredAccount(data string, lastLoginAt int64) (Account, error) {
+	r, err := UnmarshalAccount(data)
+	if err == nil && lastLoginAt > r.LastLoginAt {
+		r.LastLoginAt = lastLoginAt
+	}
+	return r, err
+}
+
+// Marshal returns the JSON encoding of Account
+func (r *Account) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// HasRole returns true if the role has been assigned to the user
+func (r Account) HasRole(role string) bool {
+	for _, rl := range r.Roles {
+		if rl == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Erased returns true if the personal data of the user has been erased
+func (r Account) Erased() bool {
+	return r.ErasedAt > 0
+}
+
+// Erase returns a copy of the account from which all personal data has been
+// removed. Only the ID is kept, so other services can still relate their data
+// (like order history) to the account.
+func (r Account) Erase(at time.Time) Account {
+	return Account{
+		User: acmeserverless.User{
+			ID: r.ID,
+		},
+		ErasedAt: at.Unix(),
+	}
+}
+
+// State returns the current status of the account
+func (r Account) State() string {
+	switch {
+	case r.Erased():
+		return StatusErased
+	case len(r.Status) == 0:
+		return StatusActive
+	default:
+		return r.Status
+	}
+}
+
+// Settings returns the preferences of the user, or the default preferences if the user
+// hasn't changed them
+func (r Account) Settings() user.Preferences {
+	if r.Preferences == nil {
+		return user.DefaultPreferences()
+	}
+	return *r.Preferences
+}
+
+// SetRole assigns the role to the user when add is true, and removes it otherwise
+func (r *Account) SetRole(role string, add bool) {
+	roles := make([]string, 0, len(r.Roles)+1)
+	for _, rl := range r.Roles {
+		if rl != role {
+			roles = append(roles, rl)
+		}
+	}
+	if add {
+		roles = append(roles, role)
+	}
+	r.Roles = roles
+}
+
+// Active returns true if the user can log in and use tokens
+func (r Account) Active() bool {
+	return r.State() == StatusActive
+}
+
+// CheckPassword returns true if password is the password of the user. Users that must
+// reset their password can't log in with any password.
+func (r Account) CheckPassword(password string) bool {
+	switch {
+	case r.PasswordReset:
+		return false
+	case len(r.PasswordHash) > 0:
+		return bcrypt.CompareHashAndPassword([]byte(r.PasswordHash), []byte(password)) == nil
+	default:
+		return subtle.ConstantTimeCompare([]byte(r.Password), []byte(password)) == 1
+	}
+}
+
+// SetPassword sets a new password for the user. The imported password hash is removed and
+// the user no longer has to reset the password.
+func (r *Account) SetPassword(password string) {
+	r.Password = password
+	r.PasswordHash = ""
+	r.PasswordReset = false
+}
+
+// ChangeStatus sets the status of the account and records the change in the status history
+func (r *Account) ChangeStatus(status string, reason string, changedBy string, at time.Time) {
+	r.StatusHistory = append(r.StatusHistory, StatusChange{
+		From:      r.State(),
+		To:        status,
+		Reason:    reason,
+		ChangedBy: changedBy,
+		ChangedAt: at.Unix(),
+	})
+	r.Status = status
+}
+
+// Public returns the view of the account that can be sent back to callers
+func (r Account) Public() user.PublicUser {
+	return user.PublicUser{
+		ID:        r.ID,
+		Username:  r.Username,
+		Firstname: r.Firstname,
+		Lastname:  r.Lastname,
+		Email:     r.Email,
+	}
+}
+
+// Admin returns the view of the account that can be sent back to admins
+func (r Account) Admin() user.AdminUser {
+	roles := r.Roles
+	if roles == nil {
+		roles = make([]string, 0)
+	}
+
+	return user.AdminUser{
+		PublicUser:  r.Public(),
+		Roles:       roles,
+		Status:      r.State(),
+		ErasedAt:    r.ErasedAt,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+		LastLoginAt: r.LastLoginAt,
+	}
+}
+
+// View returns the admin view of the account when admin is true, and the public view otherwise
+func (r Account) View(admin bool) interface{} {
+	if admin {
+		return r.Admin()
+	}
+	return r.Public()
+}
@@ -0,0 +1,213 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+
+	user "github.com/retgits/acme-serverless-user"
+)
+
+const (
+	// MaxAddresses is the maximum number of addresses in the address book of a user
+	MaxAddresses = 20
+
+	// addressRetries is the number of times a change of an address book is made when the
+	// address book keeps being changed by other requests at the same time
+	addressRetries = 3
+)
+
+var (
+	// ErrAddressNotFound is returned when an address is not part of the address book of the user
+	ErrAddressNotFound = NotFound("address not found")
+
+	// ErrAddressBookFull is returned when an address is added to an address book that
+	// already has MaxAddresses addresses
+	ErrAddressBookFull = Conflict("an address book can have at most %d addresses", MaxAddresses)
+
+	// ErrAddressBookChanged is returned when the address book has been changed by another
+	// request since it was read, so the change would overwrite the other change
+	ErrAddressBookChanged = Conflict("the address book has been changed since it was read")
+)
+
+// AddressBook is implemented by data stores that can keep the shipping and billing
+// addresses of users, on top of the methods of the Manager interface.
+type AddressBook interface {
+	// Addresses retrieves the addresses in the address book of the user, ordered by ID
+	Addresses(ctx context.Context, userID string) ([]user.Address, error)
+	// SaveAddresses adds the added addresses, replaces the changed addresses and removes the
+	// addresses with the IDs in removed from the address book of the user, as a single
+	// change. The added addresses must not be in the address book yet and the changed
+	// addresses must be, otherwise nothing is stored and ErrAddressBookChanged is returned.
+	// Users that don't exist or have been erased have no address book.
+	SaveAddresses(ctx context.Context, userID string, added []user.Address, changed []user.Address, removed []string) error
+}
+
+func init() {
+	RegisterExportContributor("addresses", exportAddresses)
+}
+
+// GetAddress retrieves a single address from the address book of the user
+func GetAddress(ctx context.Context, b AddressBook, userID string, addressID string) (user.Address, error) {
+	book, err := b.Addresses(ctx, userID)
+	if err != nil {
+		return user.Address{}, err
+	}
+
+	for _, a := range book {
+		if a.ID == addressID {
+			return a, nil
+		}
+	}
+
+	return user.Address{}, ErrAddressNotFound
+}
+
+// AddAddress adds a new address to the address book of the user and returns it with its
+// ID. The first address of a user is the default shipping and billing address.
+func AddAddress(ctx context.Context, b AddressBook, userID string, addr user.Address) (user.Address, error) {
+	err := retryAddressBook(func() error {
+		book, err := b.Addresses(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if len(book) >= MaxAddresses {
+			return ErrAddressBookFull
+		}
+
+		// IDs are never lower than the ID of any address in the book, so the
+		// order of the addresses is the order in which they were added
+		next := 1
+		for _, a := range book {
+			if n, err := strconv.Atoi(a.ID); err == nil && n >= next {
+				next = n + 1
+			}
+		}
+		addr.ID = strconv.Itoa(next)
+
+		if len(book) == 0 {
+			addr.DefaultShipping = true
+			addr.DefaultBilling = true
+		}
+
+		return b.SaveAddresses(ctx, userID, []user.Address{addr}, clearDefaults(book, addr), nil)
+	})
+	if err != nil {
+		return user.Address{}, err
+	}
+
+	return addr, nil
+}
+
+// UpdateAddress replaces an address in the address book of the user
+func UpdateAddress(ctx context.Context, b AddressBook, userID string, addr user.Address) (user.Address, error) {
+	err := retryAddressBook(func() error {
+		book, err := b.Addresses(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if !contains(book, addr.ID) {
+			return ErrAddressNotFound
+		}
+
+		return b.SaveAddresses(ctx, userID, nil, append(clearDefaults(book, addr), addr), nil)
+	})
+	if err != nil {
+		return user.Address{}, err
+	}
+
+	return addr, nil
+}
+
+// RemoveAddress removes an address from the address book of the user
+func RemoveAddress(ctx context.Context, b AddressBook, userID string, addressID string) error {
+	book, err := b.Addresses(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !contains(book, addressID) {
+		return ErrAddressNotFound
+	}
+
+	return b.SaveAddresses(ctx, userID, nil, nil, []string{addressID})
+}
+
+// retryAddressBook reads and changes an address book with fn, and does so again with the
+// address book as it is then when another request changed it in the meantime
+func retryAddressBook(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < addressRetries; attempt++ {
+		if err = fn(); !errors.Is(err, ErrAddressBookChanged) {
+			return err
+		}
+	}
+	return err
+}
+
+// SortAddresses orders addresses by their ID
+func SortAddresses(book []user.Address) {
+	sort.Slice(book, func(i, j int) bool {
+		a, _ := strconv.Atoi(book[i].ID)
+		b, _ := strconv.Atoi(book[j].ID)
+		return a < b
+	})
+}
+
+// clearDefaults returns the other addresses in the book that are no longer a default
+// address, because addr has become the default shipping or billing address
+func clearDefaults(book []user.Address, addr user.Address) []user.Address {
+	changed := make([]user.Address, 0)
+
+	for _, a := range book {
+		if a.ID == addr.ID {
+			continue
+		}
+
+		c := a
+		if addr.DefaultShipping {
+			c.DefaultShipping = false
+		}
+		if addr.DefaultBilling {
+			c.DefaultBilling = false
+		}
+
+		if c != a {
+			changed = append(changed, c)
+		}
+	}
+
+	return changed
+}
+
+// contains returns true if the book has an address with the ID
+func contains(book []user.Address, addressID string) bool {
+	for _, a := range book {
+		if a.ID == addressID {
+			return true
+		}
+	}
+	return false
+}
+
+// exportAddresses contributes the address book of the user to a personal data export
+func exportAddresses(ctx context.Context, m Manager, acct Account) (interface{}, error) {
+	b, ok := m.(AddressBook)
+	if !ok {
+		return nil, nil
+	}
+
+	book, err := b.Addresses(ctx, acct.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(book) == 0 {
+		return nil, nil
+	}
+
+	return book, nil
+}
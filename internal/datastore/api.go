@@ -4,14 +4,44 @@
 // needs to be implemented.
 package datastore
 
-import acmeserverless "github.com/retgits/acme-serverless"
+import "context"
+
+// ErrUserExists is returned when a new user is added with a username or an email
+// address that is already used by another user.
+var ErrUserExists = Conflict("a user with this username or email address already exists")
+
+// ErrVersionConflict is returned when a user is updated that has been changed since it
+// was read, so the update would overwrite the other change.
+var ErrVersionConflict = Conflict("the user has been changed since it was read")
 
 // Manager is the interface that describes the methods the
 // data store needs to implement to be able to work with
-// the ACME Serverless Fitness Shop.
+// the ACME Serverless Fitness Shop. Each method takes the context
+// of the request it is called for, and stops when that context
+// is canceled or its deadline has passed.
 type Manager interface {
-	GetUser(userID string) (acmeserverless.User, error)
-	FindUser(username string) (acmeserverless.User, error)
-	AllUsers() ([]acmeserverless.User, error)
-	AddUser(usr acmeserverless.User) error
+	GetUser(ctx context.Context, userID string) (Account, error)
+	// FindUser retrieves the user with the username, regardless of case
+	FindUser(ctx context.Context, username string) (Account, error)
+	// FindUserByEmail retrieves the user with the email address, regardless of case
+	FindUserByEmail(ctx context.Context, email string) (Account, error)
+	AllUsers(ctx context.Context) ([]Account, error)
+	// ListUsers retrieves a page of at most limit users, starting after the position
+	// the cursor points to. An empty cursor starts at the first user.
+	ListUsers(ctx context.Context, limit int, cursor string) (Page, error)
+	// AddUser stores a new user at version 1, created and updated at the current time.
+	// Usernames and email addresses are unique, so if another user already has the same
+	// username or email address ErrUserExists is returned.
+	AddUser(ctx context.Context, usr Account) error
+	// UpdateUser replaces the stored data of an existing user that has not been erased.
+	// The username and email address of a user can't be changed with UpdateUser. The
+	// version of usr must be the stored version, otherwise ErrVersionConflict is returned.
+	// The user is stored with the next version, updated at the current time.
+	UpdateUser(ctx context.Context, usr Account) error
+	// DeleteUser removes the user from the data store. If anonymize is true the
+	// record is kept, but all personal data is erased from it.
+	DeleteUser(ctx context.Context, userID string, anonymize bool) error
+	// Close releases the connections of the data store. The manager can't be used
+	// once it's closed.
+	Close() error
 }
@@ -0,0 +1,15 @@
+// Package backends registers every datastore of the User service, so programs that import it can
+// select any of them by name with datastore.Open or datastore.FromEnv. Importing a datastore
+// doesn't connect to it; that only happens once it is selected. Importing it does link every
+// datastore driver into the program, so programs that can only use one datastore, like the Lambda
+// functions, import that datastore's package instead.
+package backends
+
+import (
+	// Register the datastores
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/dynamodb"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/memory"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/mongodb"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/postgres"
+	_ "github.com/retgits/acme-serverless-user/internal/datastore/sqlite"
+)
@@ -0,0 +1,31 @@
+package datastore
+
+import "context"
+
+// BulkWriter is implemented by data stores that can store many users in a single
+// request. Data stores that don't implement it are loaded one user at a time with
+// AddUsers.
+type BulkWriter interface {
+	// AddUsers stores new users. The result has an error for each user, in the same
+	// order as the users: nil when the user is stored, ErrUserExists when the username
+	// or email address is already used by another user, in the data store or earlier in
+	// the same batch, and any other error when storing the user failed. The second
+	// return value is set when the batch as a whole failed, in which case some of the
+	// users may have been stored.
+	AddUsers(ctx context.Context, usrs []Account) ([]error, error)
+}
+
+// AddUsers stores new users with the BulkWriter of the data store, or one at a time with
+// AddUser when the data store doesn't implement BulkWriter.
+func AddUsers(ctx context.Context, db Manager, usrs []Account) ([]error, error) {
+	if bw, ok := db.(BulkWriter); ok {
+		return bw.AddUsers(ctx, usrs)
+	}
+
+	errs := make([]error, len(usrs))
+	for idx, usr := range usrs {
+		errs[idx] = db.AddUser(ctx, usr)
+	}
+
+	return errs, nil
+}
@@ -0,0 +1,198 @@
+package datastore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultPageSize is the number of users in a page when no limit is given
+	DefaultPageSize = 25
+
+	// MaxPageSize is the maximum number of users in a page
+	MaxPageSize = 100
+)
+
+// minCursorKeySize is the minimum number of bytes in the key that signs cursors
+const minCursorKeySize = 16
+
+// ErrInvalidCursor is returned when a cursor has not been created by the User
+// service, or has been modified.
+var ErrInvalidCursor = Invalid("invalid cursor")
+
+// errNoCursorKey is returned when a cursor is created or read while there is no key to sign
+// it. Only requests that page through users fail, and they can be retried once it is set.
+var errNoCursorKey = Unavailable(errors.New("the environment variable CURSOR_KEY with the key to sign cursors is not set"))
+
+var (
+	cursorKeyMu sync.RWMutex
+	cursorKey   []byte
+)
+
+// SetCursorKey sets the key that signs cursors. Every instance of the service must use the
+// same key, so a cursor created by one instance can be used with another.
+func SetCursorKey(key []byte) error {
+	if len(key) < minCursorKeySize {
+		return fmt.Errorf("the key to sign cursors must have at least %d bytes", minCursorKeySize)
+	}
+
+	cursorKeyMu.Lock()
+	defer cursorKeyMu.Unlock()
+
+	cursorKey = append([]byte(nil), key...)
+	return nil
+}
+
+// CursorKeyFromEnv sets the key that signs cursors to the value of the environment variable
+// CURSOR_KEY, unless a key has already been set. It returns an error when there is no key.
+// Cursors read the key from CURSOR_KEY the first time they need it, so only programs that
+// page through users have to call it, to fail at start when the key is missing.
+func CursorKeyFromEnv() error {
+	_, err := signingKey()
+	return err
+}
+
+// signingKey returns the key that signs cursors, and reads it from CURSOR_KEY when it hasn't
+// been set yet
+func signingKey() ([]byte, error) {
+	cursorKeyMu.RLock()
+	key := cursorKey
+	cursorKeyMu.RUnlock()
+
+	if len(key) > 0 {
+		return key, nil
+	}
+
+	env := os.Getenv("CURSOR_KEY")
+	if len(env) == 0 {
+		return nil, errNoCursorKey
+	}
+
+	if err := SetCursorKey([]byte(env)); err != nil {
+		return nil, Unavailable(err)
+	}
+
+	return []byte(env), nil
+}
+
+// Page is a single page of users. Next is the cursor that points to the next page,
+// and is empty when there are no more users.
+type Page struct {
+	Users []Account
+	Next  string
+}
+
+// PageSize returns the number of users in a page for the requested limit, which is
+// set to DefaultPageSize when it is not set and capped to MaxPageSize.
+func PageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
+
+// EncodeCursor turns the position of a data store in a list of users into an opaque
+// cursor. The cursor is signed, so it can't be modified by clients.
+func EncodeCursor(position interface{}) (string, error) {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	sig, err := sign(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return payload + "." + sig, nil
+}
+
+// DecodeCursor verifies the signature of a cursor created by EncodeCursor and stores
+// the position in the value pointed to by position.
+func DecodeCursor(cursor string, position interface{}) error {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return ErrInvalidCursor
+	}
+
+	sig, err := sign(parts[0])
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(sig)) {
+		return ErrInvalidCursor
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(data, position); err != nil {
+		return ErrInvalidCursor
+	}
+
+	return nil
+}
+
+// searchCursor is the content of a cursor that points into the results of a query
+type searchCursor struct {
+	Query    Query           `json:"query"`
+	Position json.RawMessage `json:"position"`
+}
+
+// EncodeSearchCursor turns a position in the results of a query into an opaque cursor, like
+// EncodeCursor. The query and its order are signed together with the position, so the cursor
+// can only be used to page through the results of the same query.
+func EncodeSearchCursor(q Query, position interface{}) (string, error) {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeCursor(searchCursor{Query: q.normalized(), Position: data})
+}
+
+// DecodeSearchCursor verifies a cursor created by EncodeSearchCursor and stores the position
+// in the value pointed to by position. A cursor that was created for another query or order,
+// or by EncodeCursor, returns ErrInvalidCursor.
+func DecodeSearchCursor(cursor string, q Query, position interface{}) error {
+	var sc searchCursor
+	if err := DecodeCursor(cursor, &sc); err != nil {
+		return err
+	}
+
+	if sc.Query != q.normalized() || len(sc.Position) == 0 {
+		return ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(sc.Position, position); err != nil {
+		return ErrInvalidCursor
+	}
+
+	return nil
+}
+
+// sign returns the signature of the payload of a cursor, or an error when there is no key to
+// sign cursors
+func sign(payload string) (string, error) {
+	key, err := signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
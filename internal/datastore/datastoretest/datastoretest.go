@@ -0,0 +1,739 @@
+// Package datastoretest contains the behavior every datastore of the User service must have, as
+// a test suite that each datastore runs against itself. Datastores that pass it can replace each
+// other without the handlers noticing.
+package datastoretest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// Opener returns a new, empty manager of the datastore under test. The suite closes the
+// manager at the end of each test.
+type Opener func(t *testing.T) datastore.Manager
+
+// Run runs the conformance tests against the managers that open returns. Each test gets a
+// manager of its own.
+func Run(t *testing.T, open Opener) {
+	if err := datastore.SetCursorKey([]byte("datastoretest-cursor-key")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, m datastore.Manager)
+	}{
+		{"AddAndFind", testAddAndFind},
+		{"NotFound", testNotFound},
+		{"DuplicateUsername", testDuplicateUsername},
+		{"DuplicateEmail", testDuplicateEmail},
+		{"UpdateUser", testUpdateUser},
+		{"VersionConflict", testVersionConflict},
+		{"DeleteUser", testDeleteUser},
+		{"EraseThenReuse", testEraseThenReuse},
+		{"CursorPaging", testCursorPaging},
+		{"InvalidCursor", testInvalidCursor},
+		{"ConcurrentAddAddress", testConcurrentAddAddress},
+		{"AddressBookChanged", testAddressBookChanged},
+		{"LoginKeepsVersion", testLoginKeepsVersion},
+		{"UpdateThenSearch", testUpdateThenSearch},
+		{"SearchCursor", testSearchCursor},
+		{"Search", testSearch},
+		{"Preferences", testPreferences},
+		{"BulkImport", testBulkImport},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			m := open(t)
+			t.Cleanup(func() {
+				if err := m.Close(); err != nil {
+					t.Errorf("Close: %s", err.Error())
+				}
+			})
+			tt.fn(t, m)
+		})
+	}
+}
+
+// account returns a new user with the ID, username and email address
+func account(id string, username string, email string) datastore.Account {
+	return datastore.Account{
+		User: acmeserverless.User{
+			ID:        id,
+			Username:  username,
+			Password:  "beesly1234",
+			Firstname: "Pam",
+			Lastname:  "Beesly",
+			Email:     email,
+		},
+	}
+}
+
+// add stores the user and fails the test when that doesn't work
+func add(t *testing.T, m datastore.Manager, usr datastore.Account) {
+	t.Helper()
+
+	if err := m.AddUser(context.Background(), usr); err != nil {
+		t.Fatalf("AddUser(%s): %s", usr.ID, err.Error())
+	}
+}
+
+// get retrieves the user and fails the test when that doesn't work
+func get(t *testing.T, m datastore.Manager, userID string) datastore.Account {
+	t.Helper()
+
+	usr, err := m.GetUser(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("GetUser(%s): %s", userID, err.Error())
+	}
+	return usr
+}
+
+// wantErr fails the test when err is not of the kind want
+func wantErr(t *testing.T, call string, err error, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: got error %v, want %v", call, err, want)
+	}
+}
+
+func testAddAndFind(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	usr := get(t, m, "u1")
+	if usr.Username != "pam" || usr.Email != "pam@dundermifflin.com" {
+		t.Fatalf("GetUser returned %s <%s>, want pam <pam@dundermifflin.com>", usr.Username, usr.Email)
+	}
+	if usr.Version != 1 {
+		t.Errorf("new user has version %d, want 1", usr.Version)
+	}
+	if usr.CreatedAt == 0 || usr.UpdatedAt == 0 {
+		t.Errorf("new user has no creation or update time")
+	}
+
+	// Usernames and email addresses are found regardless of case
+	usr, err := m.FindUser(ctx, "PAM")
+	if err != nil || usr.ID != "u1" {
+		t.Fatalf("FindUser(PAM) = %s, %v, want u1", usr.ID, err)
+	}
+
+	usr, err = m.FindUserByEmail(ctx, "Pam@DunderMifflin.com")
+	if err != nil || usr.ID != "u1" {
+		t.Fatalf("FindUserByEmail = %s, %v, want u1", usr.ID, err)
+	}
+}
+
+func testNotFound(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+
+	_, err := m.GetUser(ctx, "nobody")
+	wantErr(t, "GetUser", err, datastore.ErrNotFound)
+
+	_, err = m.FindUser(ctx, "nobody")
+	wantErr(t, "FindUser", err, datastore.ErrNotFound)
+
+	_, err = m.FindUserByEmail(ctx, "nobody@dundermifflin.com")
+	wantErr(t, "FindUserByEmail", err, datastore.ErrNotFound)
+
+	err = m.DeleteUser(ctx, "nobody", false)
+	wantErr(t, "DeleteUser", err, datastore.ErrNotFound)
+}
+
+func testDuplicateUsername(t *testing.T, m datastore.Manager) {
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	err := m.AddUser(context.Background(), account("u2", "PAM", "pam@athlead.com"))
+	wantErr(t, "AddUser with a used username", err, datastore.ErrUserExists)
+
+	// The user that failed isn't stored at all
+	_, err = m.GetUser(context.Background(), "u2")
+	wantErr(t, "GetUser of the duplicate", err, datastore.ErrNotFound)
+}
+
+func testDuplicateEmail(t *testing.T, m datastore.Manager) {
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	err := m.AddUser(context.Background(), account("u2", "pamela", "PAM@dundermifflin.com"))
+	wantErr(t, "AddUser with a used email address", err, datastore.ErrUserExists)
+
+	_, err = m.FindUser(context.Background(), "pamela")
+	wantErr(t, "FindUser of the duplicate", err, datastore.ErrNotFound)
+}
+
+func testUpdateUser(t *testing.T, m datastore.Manager) {
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	usr := get(t, m, "u1")
+	usr.Firstname = "Pamela"
+	usr.ChangeStatus(datastore.StatusLocked, "too many logins", "datastoretest", time.Now())
+
+	if err := m.UpdateUser(context.Background(), usr); err != nil {
+		t.Fatalf("UpdateUser: %s", err.Error())
+	}
+
+	updated := get(t, m, "u1")
+	if updated.Firstname != "Pamela" || updated.State() != datastore.StatusLocked {
+		t.Errorf("updated user is %s with status %s, want Pamela with status locked", updated.Firstname, updated.State())
+	}
+	if updated.Version != usr.Version+1 {
+		t.Errorf("updated user has version %d, want %d", updated.Version, usr.Version+1)
+	}
+}
+
+func testVersionConflict(t *testing.T, m datastore.Manager) {
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	first := get(t, m, "u1")
+	second := get(t, m, "u1")
+
+	first.Firstname = "Pamela"
+	if err := m.UpdateUser(context.Background(), first); err != nil {
+		t.Fatalf("UpdateUser: %s", err.Error())
+	}
+
+	// The second change was made to the user as it was before the first change
+	second.Lastname = "Halpert"
+	err := m.UpdateUser(context.Background(), second)
+	wantErr(t, "UpdateUser with a stale version", err, datastore.ErrVersionConflict)
+
+	usr := get(t, m, "u1")
+	if usr.Firstname != "Pamela" || usr.Lastname != "Beesly" {
+		t.Errorf("user is %s %s after a conflicting update, want Pamela Beesly", usr.Firstname, usr.Lastname)
+	}
+}
+
+func testDeleteUser(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	if err := m.DeleteUser(ctx, "u1", false); err != nil {
+		t.Fatalf("DeleteUser: %s", err.Error())
+	}
+
+	_, err := m.GetUser(ctx, "u1")
+	wantErr(t, "GetUser of a deleted user", err, datastore.ErrNotFound)
+
+	_, err = m.FindUser(ctx, "pam")
+	wantErr(t, "FindUser of a deleted user", err, datastore.ErrNotFound)
+
+	// The username and email address are free again
+	add(t, m, account("u2", "pam", "pam@dundermifflin.com"))
+}
+
+func testEraseThenReuse(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	if err := m.DeleteUser(ctx, "u1", true); err != nil {
+		t.Fatalf("DeleteUser(anonymize): %s", err.Error())
+	}
+
+	// The record is kept, without personal data
+	erased := get(t, m, "u1")
+	if !erased.Erased() || erased.Username != "" || erased.Email != "" {
+		t.Fatalf("erased user is %q <%s>, erased %v, want no username and email address", erased.Username, erased.Email, erased.Erased())
+	}
+
+	_, err := m.FindUser(ctx, "pam")
+	wantErr(t, "FindUser of an erased user", err, datastore.ErrNotFound)
+
+	_, err = m.FindUserByEmail(ctx, "pam@dundermifflin.com")
+	wantErr(t, "FindUserByEmail of an erased user", err, datastore.ErrNotFound)
+
+	// Erased users can't be changed
+	erased.Firstname = "Pam"
+	if err := m.UpdateUser(ctx, erased); err == nil {
+		t.Errorf("UpdateUser of an erased user succeeded")
+	}
+
+	// The username and email address can be used by a new user
+	add(t, m, account("u2", "PAM", "pam@dundermifflin.com"))
+
+	usr, err := m.FindUser(ctx, "pam")
+	if err != nil || usr.ID != "u2" {
+		t.Fatalf("FindUser after reuse = %s, %v, want u2", usr.ID, err)
+	}
+}
+
+func testCursorPaging(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+
+	want := make([]string, 0)
+	for i := 1; i <= 7; i++ {
+		id := fmt.Sprintf("u%02d", i)
+		add(t, m, account(id, fmt.Sprintf("user%d", i), fmt.Sprintf("user%d@dundermifflin.com", i)))
+		want = append(want, id)
+	}
+
+	// Erased users aren't listed
+	add(t, m, account("u08", "erased", "erased@dundermifflin.com"))
+	if err := m.DeleteUser(ctx, "u08", true); err != nil {
+		t.Fatalf("DeleteUser(anonymize): %s", err.Error())
+	}
+
+	got := make([]string, 0)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatalf("ListUsers didn't stop after %d pages", pages)
+		}
+
+		page, err := m.ListUsers(ctx, 3, cursor)
+		if err != nil {
+			t.Fatalf("ListUsers: %s", err.Error())
+		}
+		if len(page.Users) > 3 {
+			t.Fatalf("ListUsers returned %d users, want at most 3", len(page.Users))
+		}
+
+		for _, usr := range page.Users {
+			got = append(got, usr.ID)
+		}
+
+		if len(page.Next) == 0 {
+			break
+		}
+		cursor = page.Next
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ListUsers returned %v, want %v", got, want)
+	}
+
+	all, err := m.AllUsers(ctx)
+	if err != nil {
+		t.Fatalf("AllUsers: %s", err.Error())
+	}
+	if len(all) != len(want) {
+		t.Errorf("AllUsers returned %d users, want %d", len(all), len(want))
+	}
+}
+
+func testInvalidCursor(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+	add(t, m, account("u2", "jim", "jim@dundermifflin.com"))
+
+	page, err := m.ListUsers(ctx, 1, "")
+	if err != nil {
+		t.Fatalf("ListUsers: %s", err.Error())
+	}
+	if len(page.Next) == 0 {
+		t.Fatalf("ListUsers returned no cursor for the second page")
+	}
+
+	// A cursor that has been changed by a client is rejected
+	_, err = m.ListUsers(ctx, 1, "x"+page.Next)
+	wantErr(t, "ListUsers with a changed cursor", err, datastore.ErrInvalid)
+}
+
+// addressBook returns the address book of the datastore, and skips the test when the datastore
+// doesn't keep address books
+func addressBook(t *testing.T, m datastore.Manager) datastore.AddressBook {
+	t.Helper()
+
+	b, ok := m.(datastore.AddressBook)
+	if !ok {
+		t.Skip("the datastore doesn't keep address books")
+	}
+	return b
+}
+
+func testConcurrentAddAddress(t *testing.T, m datastore.Manager) {
+	b := addressBook(t, m)
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	// Addresses added at the same time get IDs of their own and are all kept. A request
+	// only gives up after a few retries, so the number of requests at the same time is kept
+	// below that.
+	const adds = 3
+
+	var wg sync.WaitGroup
+	errs := make([]error, adds)
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			addr := user.Address{Name: fmt.Sprintf("Office %d", i), Line1: "1725 Slough Avenue", City: "Scranton", Country: "US"}
+			_, errs[i] = datastore.AddAddress(context.Background(), b, "u1", addr)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("AddAddress %d: %s", i, err.Error())
+		}
+	}
+
+	book, err := b.Addresses(context.Background(), "u1")
+	if err != nil {
+		t.Fatalf("Addresses: %s", err.Error())
+	}
+	if len(book) != adds {
+		t.Fatalf("address book has %d addresses, want %d", len(book), adds)
+	}
+
+	defaults := 0
+	for idx, a := range book {
+		if a.ID != fmt.Sprint(idx+1) {
+			t.Errorf("address %d has ID %s, want %d", idx, a.ID, idx+1)
+		}
+		if a.DefaultShipping {
+			defaults++
+		}
+	}
+	if defaults != 1 {
+		t.Errorf("address book has %d default shipping addresses, want 1", defaults)
+	}
+}
+
+func testAddressBookChanged(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+	b := addressBook(t, m)
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	office := user.Address{ID: "1", Name: "Office", Line1: "1725 Slough Avenue", City: "Scranton", Country: "US"}
+	if err := b.SaveAddresses(ctx, "u1", []user.Address{office}, nil, nil); err != nil {
+		t.Fatalf("SaveAddresses: %s", err.Error())
+	}
+
+	// Another request added an address with the same ID since the address book was read
+	home := user.Address{ID: "1", Name: "Home", Line1: "Linden Street", City: "Scranton", Country: "US"}
+	err := b.SaveAddresses(ctx, "u1", []user.Address{home}, nil, nil)
+	wantErr(t, "SaveAddresses adding an address that exists", err, datastore.ErrAddressBookChanged)
+
+	// Another request removed the address since the address book was read
+	home.ID = "2"
+	err = b.SaveAddresses(ctx, "u1", nil, []user.Address{home}, nil)
+	wantErr(t, "SaveAddresses changing an address that doesn't exist", err, datastore.ErrAddressBookChanged)
+
+	book, err := b.Addresses(ctx, "u1")
+	if err != nil {
+		t.Fatalf("Addresses: %s", err.Error())
+	}
+	if len(book) != 1 || book[0].Name != "Office" {
+		t.Errorf("address book is %v after conflicting changes, want only the office", book)
+	}
+
+	err = b.SaveAddresses(ctx, "nobody", []user.Address{office}, nil, nil)
+	wantErr(t, "SaveAddresses of a user that doesn't exist", err, datastore.ErrNotFound)
+}
+
+func testLoginKeepsVersion(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+	if _, ok := m.(datastore.LoginHistory); !ok {
+		t.Skip("the datastore doesn't keep a login history")
+	}
+
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+	before := get(t, m, "u1")
+
+	// A login between reading the user and updating it must not fail the update
+	at := time.Now().Unix()
+	attempt := user.LoginAttempt{At: at, Success: true, Method: "username"}
+	if err := datastore.RecordLogin(ctx, m, before, attempt); err != nil {
+		t.Fatalf("RecordLogin: %s", err.Error())
+	}
+
+	after := get(t, m, "u1")
+	if after.Version != before.Version {
+		t.Errorf("user has version %d after a login, want %d", after.Version, before.Version)
+	}
+	if after.LastLoginAt != at {
+		t.Errorf("user has last login %d, want %d", after.LastLoginAt, at)
+	}
+
+	before.Firstname = "Pamela"
+	if err := m.UpdateUser(ctx, before); err != nil {
+		t.Fatalf("UpdateUser after a login: %s", err.Error())
+	}
+
+	// The update was made to the user as it was before the login, which doesn't undo it
+	updated := get(t, m, "u1")
+	if updated.Firstname != "Pamela" || updated.LastLoginAt != at {
+		t.Errorf("updated user is %s with last login %d, want Pamela with last login %d", updated.Firstname, updated.LastLoginAt, at)
+	}
+
+	// A failed login isn't the last login
+	failed := user.LoginAttempt{At: at + 10, Success: false, Method: "username"}
+	if err := datastore.RecordLogin(ctx, m, updated, failed); err != nil {
+		t.Fatalf("RecordLogin: %s", err.Error())
+	}
+	if usr := get(t, m, "u1"); usr.LastLoginAt != at {
+		t.Errorf("user has last login %d after a failed login, want %d", usr.LastLoginAt, at)
+	}
+}
+
+// searcher returns the search of the datastore, and skips the test when the datastore can't
+// search users
+func searcher(t *testing.T, m datastore.Manager) datastore.Searcher {
+	t.Helper()
+
+	s, ok := m.(datastore.Searcher)
+	if !ok {
+		t.Skip("the datastore can't search users")
+	}
+	return s
+}
+
+// search returns the IDs of the users in the first page of results of the query
+func search(t *testing.T, s datastore.Searcher, q datastore.Query) []string {
+	t.Helper()
+
+	page, err := s.SearchUsers(context.Background(), q, datastore.MaxPageSize, "")
+	if err != nil {
+		t.Fatalf("SearchUsers(%+v): %s", q, err.Error())
+	}
+
+	ids := make([]string, len(page.Users))
+	for idx, usr := range page.Users {
+		ids[idx] = usr.ID
+	}
+	return ids
+}
+
+func testUpdateThenSearch(t *testing.T, m datastore.Manager) {
+	s := searcher(t, m)
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+	add(t, m, account("u2", "jim", "jim@dundermifflin.com"))
+
+	usr := get(t, m, "u1")
+	usr.Lastname = "Halpert"
+	usr.Email = "Pam.Halpert@DunderMifflin.com"
+	if err := m.UpdateUser(context.Background(), usr); err != nil {
+		t.Fatalf("UpdateUser: %s", err.Error())
+	}
+
+	// The user is found by the new name and email address, and no longer by the old ones
+	if got := search(t, s, datastore.Query{Name: "halpert"}); fmt.Sprint(got) != "[u1]" {
+		t.Errorf("search on the new name returned %v, want [u1]", got)
+	}
+	if got := search(t, s, datastore.Query{Name: "beesly pam"}); fmt.Sprint(got) != "[u2]" {
+		t.Errorf("search on the old name returned %v, want [u2]", got)
+	}
+	if got := search(t, s, datastore.Query{Email: "pam.halpert@dundermifflin.com"}); fmt.Sprint(got) != "[u1]" {
+		t.Errorf("search on the new email address returned %v, want [u1]", got)
+	}
+	if got := search(t, s, datastore.Query{Email: "pam@dundermifflin.com"}); len(got) != 0 {
+		t.Errorf("search on the old email address returned %v, want no users", got)
+	}
+
+	if found, err := m.FindUserByEmail(context.Background(), "pam.halpert@dundermifflin.com"); err != nil || found.ID != "u1" {
+		t.Errorf("FindUserByEmail of the new email address = %s, %v, want u1", found.ID, err)
+	}
+
+	// The old email address can be used by a new user, the new one can't be used by another user
+	add(t, m, account("u3", "pamela", "pam@dundermifflin.com"))
+	jim := get(t, m, "u2")
+	jim.Email = "pam.halpert@dundermifflin.com"
+	err := m.UpdateUser(context.Background(), jim)
+	wantErr(t, "UpdateUser to the email address of another user", err, datastore.ErrUserExists)
+}
+
+func testSearchCursor(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+	s := searcher(t, m)
+	for i := 1; i <= 3; i++ {
+		add(t, m, account(fmt.Sprintf("u%d", i), fmt.Sprintf("user%d", i), fmt.Sprintf("user%d@dundermifflin.com", i)))
+	}
+
+	q := datastore.Query{Name: "beesly", Sort: datastore.SortName}
+	page, err := s.SearchUsers(ctx, q, 1, "")
+	if err != nil {
+		t.Fatalf("SearchUsers: %s", err.Error())
+	}
+	if len(page.Next) == 0 {
+		t.Fatalf("SearchUsers returned no cursor for the second page")
+	}
+
+	if _, err := s.SearchUsers(ctx, q, 1, page.Next); err != nil {
+		t.Errorf("SearchUsers with the cursor of the same query: %s", err.Error())
+	}
+
+	// A cursor only points into the results of the query it was created for
+	for _, other := range []datastore.Query{
+		{Name: "beesly", Sort: "-" + datastore.SortName},
+		{Name: "beesly"},
+		{Name: "b", Sort: datastore.SortName},
+		{Name: "beesly", Status: datastore.StatusActive, Sort: datastore.SortName},
+	} {
+		_, err := s.SearchUsers(ctx, other, 1, page.Next)
+		wantErr(t, fmt.Sprintf("SearchUsers(%+v) with the cursor of another query", other), err, datastore.ErrInvalid)
+	}
+
+	_, err = m.ListUsers(ctx, 1, page.Next)
+	wantErr(t, "ListUsers with the cursor of a search", err, datastore.ErrInvalid)
+
+	list, err := m.ListUsers(ctx, 1, "")
+	if err != nil {
+		t.Fatalf("ListUsers: %s", err.Error())
+	}
+	_, err = s.SearchUsers(ctx, q, 1, list.Next)
+	wantErr(t, "SearchUsers with the cursor of a list", err, datastore.ErrInvalid)
+}
+
+func testSearch(t *testing.T, m datastore.Manager) {
+	s := searcher(t, m)
+
+	for _, usr := range []struct{ id, first, last string }{{"u1", "Pam", "Beesly"}, {"u2", "Jim", "Halpert"}, {"u3", "Dwight", "Schrute"}, {"u4", "Angela", "Schrute"}} {
+		acct := account(usr.id, strings.ToLower(usr.first), strings.ToLower(usr.first)+"@dundermifflin.com")
+		acct.Firstname, acct.Lastname = usr.first, usr.last
+		add(t, m, acct)
+	}
+
+	locked := get(t, m, "u3")
+	locked.ChangeStatus(datastore.StatusLocked, "too many logins", "datastoretest", time.Now())
+	if err := m.UpdateUser(context.Background(), locked); err != nil {
+		t.Fatalf("UpdateUser: %s", err.Error())
+	}
+
+	add(t, m, account("u5", "erased", "erased@dundermifflin.com"))
+	if err := m.DeleteUser(context.Background(), "u5", true); err != nil {
+		t.Fatalf("DeleteUser(anonymize): %s", err.Error())
+	}
+
+	tests := []struct {
+		q    datastore.Query
+		want string
+	}{
+		{datastore.Query{Email: "JIM@DunderMifflin.com"}, "[u2]"},
+		{datastore.Query{Email: "nobody@dundermifflin.com"}, "[]"},
+		{datastore.Query{Name: "Schrute"}, "[u3 u4]"},
+		{datastore.Query{Name: "schrute a"}, "[u4]"},
+		{datastore.Query{Status: datastore.StatusLocked}, "[u3]"},
+		{datastore.Query{Status: datastore.StatusActive}, "[u1 u2 u4]"},
+		{datastore.Query{Status: datastore.StatusErased}, "[u5]"},
+		{datastore.Query{Name: "schrute", Status: datastore.StatusActive}, "[u4]"},
+		{datastore.Query{Sort: datastore.SortName, Status: datastore.StatusActive}, "[u1 u2 u4]"},
+		{datastore.Query{Sort: "-" + datastore.SortName, Name: "s"}, "[u3 u4]"},
+		{datastore.Query{Sort: "-" + datastore.SortID, Status: datastore.StatusActive}, "[u4 u2 u1]"},
+	}
+
+	for _, tt := range tests {
+		if got := fmt.Sprint(search(t, s, tt.q)); got != tt.want {
+			t.Errorf("SearchUsers(%+v) returned %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	_, err := s.SearchUsers(context.Background(), datastore.Query{Status: "unknown"}, 1, "")
+	wantErr(t, "SearchUsers with an unknown status", err, datastore.ErrInvalid)
+
+	// Paging through the results returns each user once, in order
+	q := datastore.Query{Sort: datastore.SortName, Status: datastore.StatusActive}
+	got := make([]string, 0)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("SearchUsers didn't stop after %d pages", pages)
+		}
+
+		page, err := s.SearchUsers(context.Background(), q, 2, cursor)
+		if err != nil {
+			t.Fatalf("SearchUsers: %s", err.Error())
+		}
+		for _, usr := range page.Users {
+			got = append(got, usr.ID)
+		}
+
+		if len(page.Next) == 0 {
+			break
+		}
+		cursor = page.Next
+	}
+	if fmt.Sprint(got) != "[u1 u2 u4]" {
+		t.Errorf("paging through SearchUsers returned %v, want [u1 u2 u4]", got)
+	}
+}
+
+func testPreferences(t *testing.T, m datastore.Manager) {
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	usr := get(t, m, "u1")
+	if prefs := usr.Settings(); !reflect.DeepEqual(prefs, user.DefaultPreferences()) {
+		t.Errorf("new user has preferences %+v, want the defaults %+v", prefs, user.DefaultPreferences())
+	}
+
+	want := user.Preferences{Language: "nl-NL", Currency: "EUR", Newsletter: true, OrderNotifications: []string{user.NotifyEmail}}
+	usr.Preferences = &want
+	if err := m.UpdateUser(context.Background(), usr); err != nil {
+		t.Fatalf("UpdateUser: %s", err.Error())
+	}
+
+	updated := get(t, m, "u1")
+	if prefs := updated.Settings(); !reflect.DeepEqual(prefs, want) {
+		t.Errorf("updated user has preferences %+v, want %+v", prefs, want)
+	}
+	if updated.Version != usr.Version+1 {
+		t.Errorf("updated user has version %d, want %d", updated.Version, usr.Version+1)
+	}
+
+	// Other changes keep the preferences
+	updated.Firstname = "Pamela"
+	if err := m.UpdateUser(context.Background(), updated); err != nil {
+		t.Fatalf("UpdateUser: %s", err.Error())
+	}
+	if prefs := get(t, m, "u1").Settings(); !reflect.DeepEqual(prefs, want) {
+		t.Errorf("user has preferences %+v after another update, want %+v", prefs, want)
+	}
+}
+
+func testBulkImport(t *testing.T, m datastore.Manager) {
+	ctx := context.Background()
+	add(t, m, account("u1", "pam", "pam@dundermifflin.com"))
+
+	batch := []datastore.Account{
+		account("u2", "jim", "jim@dundermifflin.com"),
+		account("u3", "PAM", "pamela@dundermifflin.com"),
+		account("u4", "dwight", "Jim@DunderMifflin.com"),
+		account("u5", "angela", ""),
+		account("u6", "Jim", "halpert@dundermifflin.com"),
+	}
+
+	errs, err := datastore.AddUsers(ctx, m, batch)
+	if err != nil {
+		t.Fatalf("AddUsers: %s", err.Error())
+	}
+	if len(errs) != len(batch) {
+		t.Fatalf("AddUsers returned %d errors, want one for each of the %d users", len(errs), len(batch))
+	}
+
+	// Usernames and email addresses are compared regardless of case, with the stored users
+	// and with the users earlier in the batch
+	wantStored := []bool{true, false, false, true, false}
+	for idx, stored := range wantStored {
+		if stored && errs[idx] != nil {
+			t.Errorf("AddUsers(%s): %s", batch[idx].ID, errs[idx].Error())
+		}
+		if !stored {
+			wantErr(t, fmt.Sprintf("AddUsers(%s)", batch[idx].ID), errs[idx], datastore.ErrUserExists)
+		}
+	}
+
+	for _, id := range []string{"u2", "u5"} {
+		if usr := get(t, m, id); usr.Version != 1 {
+			t.Errorf("imported user %s has version %d, want 1", id, usr.Version)
+		}
+	}
+	if usr, err := m.FindUser(ctx, "JIM"); err != nil || usr.ID != "u2" {
+		t.Errorf("FindUser of an imported user = %s, %v, want u2", usr.ID, err)
+	}
+	for _, id := range []string{"u3", "u4", "u6"} {
+		_, err := m.GetUser(ctx, id)
+		wantErr(t, fmt.Sprintf("GetUser(%s) of a user that wasn't imported", id), err, datastore.ErrNotFound)
+	}
+}
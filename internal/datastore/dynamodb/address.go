@@ -0,0 +1,159 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// Addresses retrieves the address book of a user from DynamoDB. Each address is a
+// separate item in the partition of the user, with the access pattern
+// PK = USER#<id> SK = ADDRESS#<n>.
+func (m manager) Addresses(ctx context.Context, userID string) ([]user.Address, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Create a map of DynamoDB Attribute Values containing the table keys
+	km := make(map[string]*dynamodb.AttributeValue)
+	km[":pk"] = &dynamodb.AttributeValue{
+		S: aws.String(userPartition(userID)),
+	}
+	km[":sk"] = &dynamodb.AttributeValue{
+		S: aws.String("ADDRESS#"),
+	}
+
+	// Create the QueryInput
+	qi := &dynamodb.QueryInput{
+		TableName:                 aws.String(m.table),
+		KeyConditionExpression:    aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		ExpressionAttributeValues: km,
+	}
+
+	book := make([]user.Address, 0)
+
+	err := m.dbs.QueryPagesWithContext(ctx, qi, func(qo *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range qo.Items {
+			addr, err := user.UnmarshalAddress(*item["Payload"].S)
+			if err != nil {
+				log.Println(fmt.Sprintf("error unmarshalling address data: %s", err.Error()))
+				continue
+			}
+			book = append(book, addr)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	// The sort key is a string, so ADDRESS#10 comes before ADDRESS#2
+	datastore.SortAddresses(book)
+
+	return book, nil
+}
+
+// SaveAddresses adds, replaces and removes the addresses of a user in a single transaction. The
+// transaction fails when the user doesn't exist or has been erased, when the item of an added
+// address already exists or when the item of a changed address doesn't.
+func (m manager) SaveAddresses(ctx context.Context, userID string, added []user.Address, changed []user.Address, removed []string) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	items := []*dynamodb.TransactWriteItem{
+		{
+			ConditionCheck: &dynamodb.ConditionCheck{
+				TableName:           aws.String(m.table),
+				Key:                 userKey(userID),
+				ConditionExpression: aws.String("attribute_exists(KeyID)"),
+			},
+		},
+	}
+
+	for _, addr := range added {
+		put, err := m.addressPut(userID, addr, "attribute_not_exists(SK)")
+		if err != nil {
+			return err
+		}
+		items = append(items, put)
+	}
+
+	for _, addr := range changed {
+		put, err := m.addressPut(userID, addr, "attribute_exists(SK)")
+		if err != nil {
+			return err
+		}
+		items = append(items, put)
+	}
+
+	for _, addressID := range removed {
+		items = append(items, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(m.table),
+				Key:       addressKey(userID, addressID),
+			},
+		})
+	}
+
+	_, err := m.dbs.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	// The reasons are in the order of the items of the transaction, so the first one is
+	// the check of the user
+	if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		if len(tce.CancellationReasons) > 0 && aws.StringValue(tce.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			return datastore.NotFound("no user found with id %s", userID)
+		}
+		for idx, reason := range tce.CancellationReasons {
+			if idx > 0 && aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+				return datastore.ErrAddressBookChanged
+			}
+		}
+	}
+
+	return storeError(err)
+}
+
+// addressPut returns the part of a transaction that stores the item of an address, when the
+// condition holds
+func (m manager) addressPut(userID string, addr user.Address, condition string) (*dynamodb.TransactWriteItem, error) {
+	payload, err := addr.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	im := addressKey(userID, addr.ID)
+	im["Payload"] = &dynamodb.AttributeValue{
+		S: aws.String(string(payload)),
+	}
+
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName:           aws.String(m.table),
+			Item:                im,
+			ConditionExpression: aws.String(condition),
+		},
+	}, nil
+}
+
+// userPartition returns the partition key of the items that belong to a user
+func userPartition(userID string) string {
+	return fmt.Sprintf("USER#%s", userID)
+}
+
+// addressKey returns the key of the item of an address
+func addressKey(userID string, addressID string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK": {
+			S: aws.String(userPartition(userID)),
+		},
+		"SK": {
+			S: aws.String(fmt.Sprintf("ADDRESS#%s", addressID)),
+		},
+	}
+}
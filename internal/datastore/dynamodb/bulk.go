@@ -0,0 +1,192 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+const (
+	// batchGetSize is the maximum number of keys in a BatchGetItem request
+	batchGetSize = 100
+
+	// batchWriteSize is the maximum number of items in a BatchWriteItem request
+	batchWriteSize = 25
+
+	// batchRetries is the number of times unprocessed keys and items are retried
+	batchRetries = 5
+
+	// bulkWorkers is the number of users AddUsers writes at the same time
+	bulkWorkers = 10
+)
+
+// AddUsers stores new users in DynamoDB. Each user is written with the same transaction as
+// AddUser, which only stores the user when neither the user nor its username and email
+// address exist yet, so an import can never overwrite a user that registers at the same
+// time. The transactions of a batch run in parallel, at most bulkWorkers at a time.
+func (m manager) AddUsers(ctx context.Context, usrs []datastore.Account) ([]error, error) {
+	errs := make([]error, len(usrs))
+
+	// owner keeps track of the keys used in the batch, so a username or email address that
+	// is used twice in the batch is only written for the first user
+	owner := make(map[string]bool)
+
+	for idx, usr := range usrs {
+		usrKeys := append([]map[string]*dynamodb.AttributeValue{userKey(usr.ID)}, guardKeys(usr)...)
+
+		for _, key := range usrKeys {
+			if owner[keyString(key)] {
+				errs[idx] = datastore.ErrUserExists
+			}
+		}
+		if errs[idx] != nil {
+			continue
+		}
+
+		for _, key := range usrKeys {
+			owner[keyString(key)] = true
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bulkWorkers)
+
+	for idx := range usrs {
+		if errs[idx] != nil {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(idx int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[idx] = m.AddUser(ctx, usrs[idx])
+		}(idx)
+	}
+	wg.Wait()
+
+	// When the context is done, the users that weren't written yet failed because of it
+	if err := ctx.Err(); err != nil {
+		return nil, storeError(err)
+	}
+
+	return errs, nil
+}
+
+// batchGet returns the attributes in the projection of the items that exist in the table,
+// reading at most batchGetSize keys per request
+func (m manager) batchGet(ctx context.Context, keys []map[string]*dynamodb.AttributeValue, projection string) ([]map[string]*dynamodb.AttributeValue, error) {
+	found := make([]map[string]*dynamodb.AttributeValue, 0)
+
+	for start := 0; start < len(keys); start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		req := map[string]*dynamodb.KeysAndAttributes{
+			m.table: {
+				Keys:                 keys[start:end],
+				ProjectionExpression: aws.String(projection),
+			},
+		}
+
+		for attempt := 0; len(req) > 0; attempt++ {
+			if attempt > batchRetries {
+				return nil, datastore.Unavailable(fmt.Errorf("unable to read %d keys after %d retries", len(req[m.table].Keys), batchRetries))
+			}
+			if err := backoff(ctx, attempt); err != nil {
+				return nil, storeError(err)
+			}
+
+			res, err := m.dbs.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: req,
+			})
+			if err != nil {
+				return nil, storeError(err)
+			}
+
+			found = append(found, res.Responses[m.table]...)
+			req = res.UnprocessedKeys
+		}
+	}
+
+	return found, nil
+}
+
+// batchWrite writes the items, at most batchWriteSize items per request
+func (m manager) batchWrite(ctx context.Context, writes []*dynamodb.WriteRequest) error {
+	for start := 0; start < len(writes); start += batchWriteSize {
+		end := start + batchWriteSize
+		if end > len(writes) {
+			end = len(writes)
+		}
+
+		req := map[string][]*dynamodb.WriteRequest{
+			m.table: writes[start:end],
+		}
+
+		for attempt := 0; len(req) > 0; attempt++ {
+			if attempt > batchRetries {
+				return datastore.Unavailable(fmt.Errorf("unable to write %d items after %d retries", len(req[m.table]), batchRetries))
+			}
+			if err := backoff(ctx, attempt); err != nil {
+				return storeError(err)
+			}
+
+			res, err := m.dbs.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: req,
+			})
+			if err != nil {
+				return storeError(err)
+			}
+
+			req = res.UnprocessedItems
+		}
+	}
+
+	return nil
+}
+
+// backoff waits before a retry. Unprocessed keys and items are usually caused by
+// exceeding the provisioned throughput, so the time between retries doubles each time.
+// It returns the error of the context when the context is done before the wait is over.
+func backoff(ctx context.Context, attempt int) error {
+	if attempt == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(time.Duration(50<<uint(attempt)) * time.Millisecond)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// userKey returns the table keys of the item of a user, which is in the partition of the
+// user with the access pattern PK = USER#<id> SK = PROFILE
+func userKey(userID string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK": {
+			S: aws.String(userPartition(userID)),
+		},
+		"SK": {
+			S: aws.String(profileKey),
+		},
+	}
+}
+
+// keyString returns a string that identifies the item with the key
+func keyString(key map[string]*dynamodb.AttributeValue) string {
+	return fmt.Sprintf("%s\x00%s", aws.StringValue(key["PK"].S), aws.StringValue(key["SK"].S))
+}
@@ -3,187 +3,882 @@
 package dynamodb
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	acmeserverless "github.com/retgits/acme-serverless"
 	"github.com/retgits/acme-serverless-user/internal/datastore"
 )
 
-// Create a single instance of the dynamoDB service
-// which can be reused if the container stays warm
-var dbs *dynamodb.DynamoDB
+// manager keeps the client of the DynamoDB service, which is reused for all requests if the
+// container stays warm, and the name of the table the users are stored in.
+type manager struct {
+	dbs   *dynamodb.DynamoDB
+	table string
+}
 
-type manager struct{}
+const (
+	// profileKey is the sort key of the item of a user in the partition of the user
+	profileKey = "PROFILE"
 
-// init creates the connection to dynamoDB. If the environment variable
-// DYNAMO_URL is set, the connection is made to that URL instead of
-// relying on the AWS SDK to provide the URL
-func init() {
-	awsSession := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(os.Getenv("REGION")),
-	}))
+	// userType is the Type of the items of users, which is the partition key of the
+	// indexes that list all users
+	userType = "USER"
+)
+
+// Config is the configuration of the connection to DynamoDB
+type Config struct {
+	// Region is the AWS region of the table
+	Region string
+
+	// Endpoint is the URL of DynamoDB. When it's empty, the AWS SDK provides the URL of the
+	// region.
+	Endpoint string
 
-	if len(os.Getenv("DYNAMO_URL")) > 0 {
-		awsSession.Config.Endpoint = aws.String(os.Getenv("DYNAMO_URL"))
+	// Table is the name of the table
+	Table string
+}
+
+// ConfigFromEnv returns the configuration in the environment variables REGION, DYNAMO_URL
+// and TABLE
+func ConfigFromEnv() Config {
+	return Config{
+		Region:   os.Getenv("REGION"),
+		Endpoint: os.Getenv("DYNAMO_URL"),
+		Table:    os.Getenv("TABLE"),
 	}
+}
 
-	dbs = dynamodb.New(awsSession)
+func init() {
+	datastore.Register("dynamodb", func() (datastore.Manager, error) {
+		return New(ConfigFromEnv())
+	})
 }
 
 // New creates a new datastore manager using Amazon DynamoDB as backend
-func New() datastore.Manager {
-	return manager{}
-}
+func New(cfg Config) (datastore.Manager, error) {
+	if len(cfg.Table) == 0 {
+		return nil, errors.New("the name of the DynamoDB table is not set")
+	}
 
-// GetUser retrieves a single user from DynamoDB based on the userID
-func (m manager) GetUser(userID string) (acmeserverless.User, error) {
-	// Create a map of DynamoDB Attribute Values containing the table keys
-	// for the access pattern PK = USER SK = ID
-	km := make(map[string]*dynamodb.AttributeValue)
-	km[":type"] = &dynamodb.AttributeValue{
-		S: aws.String("USER"),
+	awsConfig := &aws.Config{
+		Region: aws.String(cfg.Region),
 	}
-	km[":id"] = &dynamodb.AttributeValue{
-		S: aws.String(userID),
+	if len(cfg.Endpoint) > 0 {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
 	}
 
-	// Create the QueryInput
-	qi := &dynamodb.QueryInput{
-		TableName:                 aws.String(os.Getenv("TABLE")),
-		KeyConditionExpression:    aws.String("PK = :type AND SK = :id"),
-		ExpressionAttributeValues: km,
+	awsSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %s", err.Error())
 	}
 
-	// Execute the DynamoDB query
-	qo, err := dbs.Query(qi)
+	return manager{
+		dbs:   dynamodb.New(awsSession),
+		table: cfg.Table,
+	}, nil
+}
+
+// Close releases the resources of the manager. The DynamoDB client keeps no connections that
+// need to be closed, so there is nothing to release.
+func (m manager) Close() error {
+	return nil
+}
+
+// GetUser retrieves a single user from DynamoDB based on the userID, with the access
+// pattern PK = USER#<id> SK = PROFILE
+func (m manager) GetUser(ctx context.Context, userID string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	usr, found, err := m.readUser(ctx, userKey(userID))
 	if err != nil {
-		return acmeserverless.User{}, err
+		return datastore.Account{}, err
 	}
 
 	// Return an error if no user was found
-	if len(qo.Items) == 0 {
-		return acmeserverless.User{}, fmt.Errorf("no user found with id %s", userID)
+	if !found {
+		return datastore.Account{}, datastore.NotFound("no user found with id %s", userID)
+	}
+
+	return usr, nil
+}
+
+// FindUser retrieves a single user from DynamoDB based on the username, using the
+// UsernameIndex on the KeyID of the user. The KeyID of a user is the normalized username,
+// users that were stored before usernames were normalized can still be found with the
+// username in the case it was registered in.
+func (m manager) FindUser(ctx context.Context, username string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	values := []string{datastore.NormalizeUsername(username)}
+	if username != values[0] {
+		values = append(values, username)
+	}
+
+	for _, value := range values {
+		// Create a map of DynamoDB Attribute Values containing the index keys
+		// for the access pattern KeyID = username
+		km := make(map[string]*dynamodb.AttributeValue)
+		km[":username"] = &dynamodb.AttributeValue{
+			S: aws.String(value),
+		}
+
+		// Create the QueryInput
+		qi := &dynamodb.QueryInput{
+			TableName:                 aws.String(m.table),
+			IndexName:                 aws.String(usernameIndex),
+			KeyConditionExpression:    aws.String("KeyID = :username"),
+			ExpressionAttributeValues: km,
+		}
+
+		// Execute the DynamoDB query
+		qo, err := m.dbs.QueryWithContext(ctx, qi)
+		if err != nil {
+			return datastore.Account{}, storeError(err)
+		}
+
+		if len(qo.Items) == 0 {
+			continue
+		}
+
+		// The index only has the keys of the user, and is updated shortly after the
+		// table, so the user is read from the table
+		usr, found, err := m.readUser(ctx, tableKey(qo.Items[0]))
+		if err != nil {
+			return datastore.Account{}, err
+		}
+		if found && !usr.Erased() {
+			return usr, nil
+		}
+	}
+
+	return datastore.Account{}, datastore.NotFound("no user found with name %s", username)
+}
+
+// readUser reads the item of a user from the table with a strongly consistent read. It
+// returns false when the item doesn't exist.
+func (m manager) readUser(ctx context.Context, key map[string]*dynamodb.AttributeValue) (datastore.Account, bool, error) {
+	gi := &dynamodb.GetItemInput{
+		TableName:      aws.String(m.table),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	}
+
+	// Execute the DynamoDB read
+	gio, err := m.dbs.GetItemWithContext(ctx, gi)
+	if err != nil {
+		return datastore.Account{}, false, storeError(err)
+	}
+
+	if gio.Item == nil {
+		return datastore.Account{}, false, nil
 	}
 
 	// Create a user struct from the data
-	str := *qo.Items[0]["Payload"].S
-	return acmeserverless.UnmarshalUser(str)
+	usr, err := datastore.UnmarshalStoredAccount(*gio.Item["Payload"].S, lastLogin(gio.Item))
+	if err != nil {
+		return datastore.Account{}, false, err
+	}
+
+	return usr, true, nil
 }
 
-// FindUser retrieves a single user from DynamoDB based on the username
-func (m manager) FindUser(username string) (acmeserverless.User, error) {
-	// Create a map of DynamoDB Attribute Values containing the table keys
-	// for the access pattern PK = USER KeyID = ID
-	km := make(map[string]*dynamodb.AttributeValue)
-	km[":type"] = &dynamodb.AttributeValue{
-		S: aws.String("USER"),
+// lastLogin returns the time of the last login in the item of a user, which is kept next to
+// the payload so a login doesn't change the version, or 0 if the user hasn't logged in
+func lastLogin(item map[string]*dynamodb.AttributeValue) int64 {
+	if av, ok := item["LastLoginAt"]; ok && av.N != nil {
+		at, _ := strconv.ParseInt(*av.N, 10, 64)
+		return at
 	}
-	km[":username"] = &dynamodb.AttributeValue{
-		S: aws.String(username),
+	return 0
+}
+
+// FindUserByEmail retrieves a single user from DynamoDB based on the email address, using
+// the EmailIndex on the lower cased email address to find the key of the user and reading
+// the user from the table with a strongly consistent read. Erased users are not part of
+// the index.
+func (m manager) FindUserByEmail(ctx context.Context, email string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Create a map of DynamoDB Attribute Values containing the index keys
+	// for the access pattern Email = email
+	km := make(map[string]*dynamodb.AttributeValue)
+	km[":email"] = &dynamodb.AttributeValue{
+		S: aws.String(datastore.NormalizeEmail(email)),
 	}
 
 	// Create the QueryInput
 	qi := &dynamodb.QueryInput{
-		TableName:                 aws.String(os.Getenv("TABLE")),
-		KeyConditionExpression:    aws.String("PK = :type"),
-		FilterExpression:          aws.String("KeyID = :username"),
+		TableName:                 aws.String(m.table),
+		IndexName:                 aws.String(emailIndex),
+		KeyConditionExpression:    aws.String("Email = :email"),
 		ExpressionAttributeValues: km,
 	}
 
 	// Execute the DynamoDB query
-	qo, err := dbs.Query(qi)
+	qo, err := m.dbs.QueryWithContext(ctx, qi)
 	if err != nil {
-		return acmeserverless.User{}, err
+		return datastore.Account{}, storeError(err)
 	}
 
-	// Return an error if no user was found
-	if len(qo.Items) == 0 {
-		return acmeserverless.User{}, fmt.Errorf("no user found with name %s", username)
+	// The index is updated shortly after the table, so it is only used to find the key of
+	// the user, which is read from the table. A user that has changed or erased its email
+	// address since is not returned.
+	for _, item := range qo.Items {
+		usr, found, err := m.readUser(ctx, tableKey(item))
+		if err != nil {
+			return datastore.Account{}, err
+		}
+		if found && !usr.Erased() && datastore.NormalizeEmail(usr.Email) == datastore.NormalizeEmail(email) {
+			return usr, nil
+		}
 	}
 
-	// Create a user struct from the data
-	str := *qo.Items[0]["Payload"].S
-	return acmeserverless.UnmarshalUser(str)
+	return datastore.Account{}, datastore.NotFound("no user found with email address %s", email)
 }
 
 // AllUsers retrieves all users from DynamoDB
-func (m manager) AllUsers() ([]acmeserverless.User, error) {
-	// Create a map of DynamoDB Attribute Values containing the table keys
-	// for the access pattern PK = USER
+func (m manager) AllUsers(ctx context.Context) ([]datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	users := make([]datastore.Account, 0)
+	qi := m.usersQuery()
+
+	// Keep querying until DynamoDB has returned all pages
+	for {
+		page, lastKey, err := m.queryUsers(ctx, qi, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, page...)
+
+		if lastKey == nil {
+			return users, nil
+		}
+		qi.ExclusiveStartKey = lastKey
+	}
+}
+
+// ListUsers retrieves a page of users from DynamoDB, starting after the position the
+// cursor points to. The cursor for the next page contains the LastEvaluatedKey of the query.
+func (m manager) ListUsers(ctx context.Context, limit int, cursor string) (datastore.Page, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	return m.pageUsers(ctx, datastore.Query{}, m.usersQuery(), limit, cursor)
+}
+
+// usersQuery returns the QueryInput for the access pattern Type = USER on the TypeIndex,
+// which has the users sorted by their ID. Erased users have no KeyID and are skipped.
+func (m manager) usersQuery() *dynamodb.QueryInput {
+	// Create a map of DynamoDB Attribute Values containing the index keys
+	// for the access pattern Type = USER
 	km := make(map[string]*dynamodb.AttributeValue)
 	km[":type"] = &dynamodb.AttributeValue{
-		S: aws.String("USER"),
+		S: aws.String(userType),
 	}
 
-	// Create the QueryInput
-	qi := &dynamodb.QueryInput{
-		TableName:                 aws.String(os.Getenv("TABLE")),
-		KeyConditionExpression:    aws.String("PK = :type"),
+	return &dynamodb.QueryInput{
+		TableName:                 aws.String(m.table),
+		IndexName:                 aws.String(typeIndex),
+		KeyConditionExpression:    aws.String("#type = :type"),
+		FilterExpression:          aws.String("attribute_exists(KeyID)"),
+		ExpressionAttributeNames:  map[string]*string{"#type": aws.String("Type")},
 		ExpressionAttributeValues: km,
 	}
+}
+
+// pageUsers runs the query until it has found a page of users, starting after the
+// position the cursor points to. The cursor must have been created for the same search, q,
+// which is the empty query when listing users.
+func (m manager) pageUsers(ctx context.Context, q datastore.Query, qi *dynamodb.QueryInput, limit int, cursor string) (datastore.Page, error) {
+	limit = datastore.PageSize(limit)
 
-	qo, err := dbs.Query(qi)
+	if len(cursor) > 0 {
+		position := make(map[string]string)
+		if err := datastore.DecodeSearchCursor(cursor, q, &position); err != nil {
+			return datastore.Page{}, err
+		}
+		qi.ExclusiveStartKey = toKey(position)
+	}
+
+	page := datastore.Page{
+		Users: make([]datastore.Account, 0, limit),
+	}
+
+	// Because filters are applied after the limit, a query can return less users than
+	// requested even though there are more users
+	for len(page.Users) < limit {
+		users, lastKey, err := m.queryUsers(ctx, qi, limit-len(page.Users))
+		if err != nil {
+			return datastore.Page{}, err
+		}
+
+		page.Users = append(page.Users, users...)
+
+		if lastKey == nil {
+			return page, nil
+		}
+		qi.ExclusiveStartKey = lastKey
+	}
+
+	next, err := datastore.EncodeSearchCursor(q, fromKey(qi.ExclusiveStartKey))
 	if err != nil {
-		return nil, err
+		return datastore.Page{}, err
 	}
+	page.Next = next
 
-	users := make([]acmeserverless.User, len(qo.Items))
+	return page, nil
+}
+
+// queryUsers runs a single query for users in DynamoDB. If limit is larger than 0, at
+// most limit items are evaluated. It returns the users and the LastEvaluatedKey, which
+// is nil when there are no more users.
+func (m manager) queryUsers(ctx context.Context, qi *dynamodb.QueryInput, limit int) ([]datastore.Account, map[string]*dynamodb.AttributeValue, error) {
+	qi.Limit = nil
+	if limit > 0 {
+		qi.Limit = aws.Int64(int64(limit))
+	}
 
-	for idx, ct := range qo.Items {
+	qo, err := m.dbs.QueryWithContext(ctx, qi)
+	if err != nil {
+		return nil, nil, storeError(err)
+	}
+
+	items, err := m.withPayloads(ctx, qo.Items)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := make([]datastore.Account, 0, len(items))
+
+	for _, ct := range items {
 		str := *ct["Payload"].S
-		usr, err := acmeserverless.UnmarshalUser(str)
+		usr, err := datastore.UnmarshalStoredAccount(str, lastLogin(ct))
 		if err != nil {
 			log.Println(fmt.Sprintf("error unmarshalling user data: %s", err.Error()))
 			continue
 		}
-		users[idx] = usr
+		users = append(users, usr)
 	}
 
-	return users, nil
+	return users, qo.LastEvaluatedKey, nil
 }
 
-// AddUser stores a new user in Amazon DynamoDB
-func (m manager) AddUser(usr acmeserverless.User) error {
-	// Create a JSON encoded string of the user
-	payload, err := usr.Marshal()
+// withPayloads returns the items of the users with their payload, in the same order. The
+// indexes that list all users only have the keys and the attributes that are filtered on,
+// so the payloads of those users are read from the table. Users that have been removed
+// since the index was read are left out.
+func (m manager) withPayloads(ctx context.Context, items []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	keys := make([]map[string]*dynamodb.AttributeValue, 0, len(items))
+	for _, item := range items {
+		if _, ok := item["Payload"]; !ok {
+			keys = append(keys, tableKey(item))
+		}
+	}
+
+	if len(keys) == 0 {
+		return items, nil
+	}
+
+	found, err := m.batchGet(ctx, keys, "PK, SK, Payload, LastLoginAt")
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]map[string]*dynamodb.AttributeValue, len(found))
+	for _, item := range found {
+		byKey[keyString(item)] = item
+	}
+
+	res := make([]map[string]*dynamodb.AttributeValue, 0, len(items))
+	for _, item := range items {
+		if _, ok := item["Payload"]; ok {
+			res = append(res, item)
+			continue
+		}
+		if full, ok := byKey[keyString(item)]; ok {
+			res = append(res, full)
+		}
+	}
+
+	return res, nil
+}
+
+// tableKey returns the table keys of an item, which can be an item read from an index
+func tableKey(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"PK": item["PK"],
+		"SK": item["SK"],
+	}
+}
+
+// fromKey turns a DynamoDB key into a position that can be stored in a cursor
+func fromKey(key map[string]*dynamodb.AttributeValue) map[string]string {
+	position := make(map[string]string, len(key))
+	for k, v := range key {
+		position[k] = aws.StringValue(v.S)
+	}
+	return position
+}
+
+// toKey turns the position stored in a cursor into a DynamoDB key
+func toKey(position map[string]string) map[string]*dynamodb.AttributeValue {
+	key := make(map[string]*dynamodb.AttributeValue, len(position))
+	for k, v := range position {
+		key[k] = &dynamodb.AttributeValue{
+			S: aws.String(v),
+		}
+	}
+	return key
+}
+
+// AddUser stores a new user in Amazon DynamoDB. Together with the user, guard items for
+// the username and email address are written in a single transaction. Because the guard
+// items can only be written when they don't exist yet, each username and email address
+// can only belong to one user.
+func (m manager) AddUser(ctx context.Context, usr datastore.Account) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	im, err := userItem(usr)
 	if err != nil {
 		return err
 	}
 
-	// Create a map of DynamoDB Attribute Values containing the table keys
-	km := make(map[string]*dynamodb.AttributeValue)
-	km["PK"] = &dynamodb.AttributeValue{
-		S: aws.String("USER"),
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(m.table),
+				Item:                im,
+				ConditionExpression: aws.String("attribute_not_exists(SK)"),
+			},
+		},
 	}
-	km["SK"] = &dynamodb.AttributeValue{
-		S: aws.String(usr.ID),
+
+	for _, key := range guardKeys(usr) {
+		// The guard item refers to the user it belongs to
+		key["UserID"] = &dynamodb.AttributeValue{
+			S: aws.String(usr.ID),
+		}
+
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(m.table),
+				Item:                key,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			},
+		})
+	}
+
+	_, err = m.dbs.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	return conflict(err)
+}
+
+// UpdateUser replaces the payload and the status of a user in Amazon DynamoDB, together with
+// the email address and name it is found by. Erased users have no username and can't be
+// updated. The update is conditional on the version attribute of the item, so concurrent
+// updates of the same user can't overwrite each other. When the email address changes, the
+// guard item of the new email address is written in the same transaction and the guard items
+// of the old one are removed, so an email address that belongs to another user returns
+// datastore.ErrUserExists.
+func (m manager) UpdateUser(ctx context.Context, usr datastore.Account) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// The stored user has the email address whose guard item is replaced. It must have the
+	// version the update is conditional on, or the update would fail anyway.
+	current, err := m.GetUser(ctx, usr.ID)
+	if errors.Is(err, datastore.ErrNotFound) || (err == nil && current.Erased()) {
+		return datastore.NotFound("no user found with id %s", usr.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if current.Version != usr.Version {
+		return datastore.ErrVersionConflict
+	}
+
+	next := usr
+	next.Version = usr.Version + 1
+	next.UpdatedAt = time.Now().Unix()
+
+	// Create a JSON encoded string of the user
+	payload, err := next.Marshal()
+	if err != nil {
+		return err
 	}
 
 	// Create a map of DynamoDB Attribute Values containing the table data elements
 	em := make(map[string]*dynamodb.AttributeValue)
-	em[":keyid"] = &dynamodb.AttributeValue{
-		S: aws.String(usr.Username),
-	}
 	em[":payload"] = &dynamodb.AttributeValue{
 		S: aws.String(string(payload)),
 	}
+	em[":status"] = &dynamodb.AttributeValue{
+		S: aws.String(usr.State()),
+	}
+	em[":version"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(next.Version, 10)),
+	}
+
+	// Index keys can't be empty, so an email address or name that is removed removes the
+	// attribute
+	set := []string{"Payload = :payload", "#status = :status", "#version = :version"}
+	var remove []string
+	if email := datastore.SearchEmail(usr.User); len(email) > 0 {
+		set = append(set, "Email = :email")
+		em[":email"] = &dynamodb.AttributeValue{
+			S: aws.String(email),
+		}
+	} else {
+		remove = append(remove, "Email")
+	}
+	if name := datastore.SearchName(usr.User); len(name) > 0 {
+		set = append(set, "#name = :name")
+		em[":name"] = &dynamodb.AttributeValue{
+			S: aws.String(name),
+		}
+	} else {
+		remove = append(remove, "#name")
+	}
+
+	expr := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		expr += " REMOVE " + strings.Join(remove, ", ")
+	}
 
-	uii := &dynamodb.UpdateItemInput{
-		TableName:                 aws.String(os.Getenv("TABLE")),
-		Key:                       km,
+	// Items that were stored before versions existed have no version attribute
+	condition := "attribute_exists(KeyID) AND attribute_not_exists(#version)"
+	if usr.Version > 0 {
+		condition = "attribute_exists(KeyID) AND #version = :expected"
+		em[":expected"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(usr.Version, 10)),
+		}
+	}
+
+	update := &dynamodb.Update{
+		TableName:                 aws.String(m.table),
+		Key:                       userKey(usr.ID),
+		ExpressionAttributeNames:  map[string]*string{"#name": aws.String("Name"), "#status": aws.String("Status"), "#version": aws.String("Version")},
 		ExpressionAttributeValues: em,
-		UpdateExpression:          aws.String("SET Payload = :payload, KeyID = :keyid"),
+		ConditionExpression:       aws.String(condition),
+		UpdateExpression:          aws.String(expr),
+	}
+
+	if datastore.NormalizeEmail(current.Email) == datastore.NormalizeEmail(usr.Email) {
+		_, err = m.dbs.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 update.TableName,
+			Key:                       update.Key,
+			ExpressionAttributeNames:  update.ExpressionAttributeNames,
+			ExpressionAttributeValues: update.ExpressionAttributeValues,
+			ConditionExpression:       update.ConditionExpression,
+			UpdateExpression:          update.UpdateExpression,
+		})
+
+		if _, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+			return m.updateFailed(ctx, usr.ID)
+		}
+
+		return storeError(err)
+	}
+
+	items := []*dynamodb.TransactWriteItem{{Update: update}}
+
+	if len(usr.Email) > 0 {
+		key := keysOf([]string{fmt.Sprintf("EMAIL#%s", datastore.NormalizeEmail(usr.Email))})[0]
+		key["UserID"] = &dynamodb.AttributeValue{
+			S: aws.String(usr.ID),
+		}
+
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(m.table),
+				Item:                key,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			},
+		})
+	}
+
+	// Guard items of users that were stored before email addresses were normalized are
+	// released as well
+	var released []string
+	if len(current.Email) > 0 {
+		released = append(released, fmt.Sprintf("EMAIL#%s", datastore.NormalizeEmail(current.Email)))
+		if current.Email != datastore.NormalizeEmail(current.Email) {
+			released = append(released, fmt.Sprintf("EMAIL#%s", current.Email))
+		}
+	}
+	for _, key := range keysOf(released) {
+		items = append(items, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(m.table),
+				Key:       key,
+			},
+		})
 	}
 
-	_, err = dbs.UpdateItem(uii)
+	_, err = m.dbs.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	// The reasons are in the order of the items of the transaction, so the first one is the
+	// condition on the user and the second one the guard item of the new email address
+	if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		for idx, reason := range tce.CancellationReasons {
+			if aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			}
+			if idx == 0 {
+				return m.updateFailed(ctx, usr.ID)
+			}
+			return datastore.ErrUserExists
+		}
+		if cerr := cancellationError(tce); cerr != nil {
+			return cerr
+		}
+	}
+
+	return storeError(err)
+}
+
+// updateFailed returns the error of an update whose condition failed, which happens both when
+// the user doesn't exist and when it has another version
+func (m manager) updateFailed(ctx context.Context, userID string) error {
+	current, err := m.GetUser(ctx, userID)
+	if errors.Is(err, datastore.ErrNotFound) || (err == nil && current.Erased()) {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
 	if err != nil {
 		return err
 	}
+	return datastore.ErrVersionConflict
+}
 
-	return nil
+// DeleteUser removes a user from Amazon DynamoDB. If anonymize is true, the item is kept
+// but the personal data is erased from the payload and the username, email address, name and
+// last login attributes are removed so the user can no longer be found. In both cases the
+// username and email address are released so they can be used by new users, and the
+// address book and login history of the user are removed.
+func (m manager) DeleteUser(ctx context.Context, userID string, anonymize bool) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	usr, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Create a map of DynamoDB Attribute Values containing the table keys
+	km := userKey(userID)
+
+	item := &dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			TableName:           aws.String(m.table),
+			Key:                 km,
+			ConditionExpression: aws.String("attribute_exists(SK)"),
+		},
+	}
+
+	if anonymize {
+		// Create a JSON encoded string of the erased user
+		erased := usr.Erase(time.Now())
+		erased.Version = usr.Version + 1
+		payload, err := erased.Marshal()
+		if err != nil {
+			return err
+		}
+
+		// Create a map of DynamoDB Attribute Values containing the table data elements
+		em := make(map[string]*dynamodb.AttributeValue)
+		em[":payload"] = &dynamodb.AttributeValue{
+			S: aws.String(string(payload)),
+		}
+		em[":version"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(erased.Version, 10)),
+		}
+
+		item = &dynamodb.TransactWriteItem{
+			Update: &dynamodb.Update{
+				TableName:                 aws.String(m.table),
+				Key:                       km,
+				ExpressionAttributeNames:  map[string]*string{"#name": aws.String("Name"), "#status": aws.String("Status"), "#version": aws.String("Version")},
+				ExpressionAttributeValues: em,
+				ConditionExpression:       aws.String("attribute_exists(SK)"),
+				UpdateExpression:          aws.String("SET Payload = :payload, #version = :version REMOVE KeyID, Email, #name, #status, LastLoginAt"),
+			},
+		}
+	}
+
+	items := []*dynamodb.TransactWriteItem{item}
+
+	// Guard items of users that were stored before usernames and email addresses were
+	// normalized are released as well
+	for _, key := range append(guardKeys(usr), registeredGuardKeys(usr)...) {
+		items = append(items, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(m.table),
+				Key:       key,
+			},
+		})
+	}
+
+	// The address book is personal data, so it's removed in both cases
+	book, err := m.Addresses(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range book {
+		items = append(items, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(m.table),
+				Key:       addressKey(userID, addr.ID),
+			},
+		})
+	}
+
+	_, err = m.dbs.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	// The reasons are in the order of the items of the transaction, so the first one is the
+	// condition on the user
+	if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		if len(tce.CancellationReasons) > 0 && aws.StringValue(tce.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			return datastore.NotFound("no user found with id %s", userID)
+		}
+		if cerr := cancellationError(tce); cerr != nil {
+			return cerr
+		}
+	}
+	if err != nil {
+		return storeError(err)
+	}
+
+	// The login history can be larger than a transaction, so it's removed once the user
+	// has been removed or erased
+	return m.deleteLogins(ctx, userID)
+}
+
+// userItem returns the item of a new user, with the table keys, the payload and the
+// attributes the indexes are based on. Each user has its own partition, and the Type and
+// UserID put the user in the indexes that list all users. New users start at version 1.
+func userItem(usr datastore.Account) (map[string]*dynamodb.AttributeValue, error) {
+	usr.Version = 1
+	usr.CreatedAt = time.Now().Unix()
+	usr.UpdatedAt = usr.CreatedAt
+
+	// Create a JSON encoded string of the user
+	payload, err := usr.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a map of DynamoDB Attribute Values containing the table keys and data elements
+	im := userKey(usr.ID)
+	im["Type"] = &dynamodb.AttributeValue{
+		S: aws.String(userType),
+	}
+	im["UserID"] = &dynamodb.AttributeValue{
+		S: aws.String(usr.ID),
+	}
+	im["KeyID"] = &dynamodb.AttributeValue{
+		S: aws.String(datastore.NormalizeUsername(usr.Username)),
+	}
+	im["Payload"] = &dynamodb.AttributeValue{
+		S: aws.String(string(payload)),
+	}
+
+	// Add the attributes the search indexes are based on
+	if email := datastore.SearchEmail(usr.User); len(email) > 0 {
+		im["Email"] = &dynamodb.AttributeValue{
+			S: aws.String(email),
+		}
+	}
+	if name := datastore.SearchName(usr.User); len(name) > 0 {
+		im["Name"] = &dynamodb.AttributeValue{
+			S: aws.String(name),
+		}
+	}
+	im["Status"] = &dynamodb.AttributeValue{
+		S: aws.String(usr.State()),
+	}
+	im["Version"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(usr.Version, 10)),
+	}
+
+	return im, nil
+}
+
+// guardKeys returns the keys of the guard items that make sure the username and the
+// email address of the user are unique, regardless of case. Users without an email
+// address only have a guard item for their username.
+func guardKeys(usr datastore.Account) []map[string]*dynamodb.AttributeValue {
+	values := []string{fmt.Sprintf("USERNAME#%s", datastore.NormalizeUsername(usr.Username))}
+	if len(usr.Email) > 0 {
+		values = append(values, fmt.Sprintf("EMAIL#%s", datastore.NormalizeEmail(usr.Email)))
+	}
+
+	return keysOf(values)
+}
+
+// registeredGuardKeys returns the keys of the guard items of users that were stored
+// before usernames and email addresses were normalized, which kept the case they were
+// registered in. Keys that are the same as the normalized keys are left out.
+func registeredGuardKeys(usr datastore.Account) []map[string]*dynamodb.AttributeValue {
+	var values []string
+	if usr.Username != datastore.NormalizeUsername(usr.Username) {
+		values = append(values, fmt.Sprintf("USERNAME#%s", usr.Username))
+	}
+	if len(usr.Email) > 0 && usr.Email != datastore.NormalizeEmail(usr.Email) {
+		values = append(values, fmt.Sprintf("EMAIL#%s", usr.Email))
+	}
+
+	return keysOf(values)
+}
+
+// keysOf returns the keys of guard items, which have the same partition key and sort key
+func keysOf(values []string) []map[string]*dynamodb.AttributeValue {
+	keys := make([]map[string]*dynamodb.AttributeValue, len(values))
+	for idx, val := range values {
+		keys[idx] = map[string]*dynamodb.AttributeValue{
+			"PK": {
+				S: aws.String(val),
+			},
+			"SK": {
+				S: aws.String(val),
+			},
+		}
+	}
+
+	return keys
+}
+
+// conflict translates a cancelled transaction, because one of the guard items already
+// exists, into datastore.ErrUserExists. Other errors are translated by storeError.
+func conflict(err error) error {
+	if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		for _, reason := range tce.CancellationReasons {
+			if aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+				return datastore.ErrUserExists
+			}
+		}
+	}
+	return storeError(err)
 }
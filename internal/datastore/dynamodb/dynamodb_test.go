@@ -0,0 +1,167 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/datastore/datastoretest"
+)
+
+// legacyPayload is a user as earlier versions stored it, with the username and email address
+// in the case they were registered in
+const legacyPayload = `{"id":"u1","username":"Dwight","password":"beets","firstname":"Dwight","lastname":"Schrute","email":"Dwight@Dunder-Mifflin.com"}`
+
+// testManager returns a manager with a new, empty table in the DynamoDB in DYNAMO_TEST_URL,
+// like DynamoDB Local, and skips the test when it isn't set. The table is removed when the test
+// ends. The AWS SDK reads the credentials from the environment, and DynamoDB Local accepts any.
+func testManager(t *testing.T) manager {
+	url := os.Getenv("DYNAMO_TEST_URL")
+	if len(url) == 0 {
+		t.Skip("DYNAMO_TEST_URL is not set")
+	}
+
+	m, err := New(Config{Region: "us-west-2", Endpoint: url, Table: fmt.Sprintf("user-%d", time.Now().UnixNano())})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.(manager).createTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.(manager).dbs.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(m.(manager).table)})
+	})
+
+	return m.(manager)
+}
+
+// The conformance tests run against a table of their own in the DynamoDB in DYNAMO_TEST_URL
+func TestConformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) datastore.Manager {
+		return testManager(t)
+	})
+}
+
+// createTable creates the table of the manager with the indexes described in the README
+func (m manager) createTable(ctx context.Context) error {
+	attr := func(name string) *dynamodb.AttributeDefinition {
+		return &dynamodb.AttributeDefinition{AttributeName: aws.String(name), AttributeType: aws.String("S")}
+	}
+	key := func(hash string, rng string) []*dynamodb.KeySchemaElement {
+		ks := []*dynamodb.KeySchemaElement{{AttributeName: aws.String(hash), KeyType: aws.String("HASH")}}
+		if len(rng) > 0 {
+			ks = append(ks, &dynamodb.KeySchemaElement{AttributeName: aws.String(rng), KeyType: aws.String("RANGE")})
+		}
+		return ks
+	}
+	index := func(name string, hash string, rng string, projection *dynamodb.Projection) *dynamodb.GlobalSecondaryIndex {
+		return &dynamodb.GlobalSecondaryIndex{IndexName: aws.String(name), KeySchema: key(hash, rng), Projection: projection}
+	}
+	keysOnly := &dynamodb.Projection{ProjectionType: aws.String("KEYS_ONLY")}
+	all := &dynamodb.Projection{ProjectionType: aws.String("ALL")}
+	listed := &dynamodb.Projection{ProjectionType: aws.String("INCLUDE"), NonKeyAttributes: aws.StringSlice([]string{"KeyID", "Status"})}
+
+	_, err := m.dbs.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(m.table),
+		BillingMode:          aws.String("PAY_PER_REQUEST"),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{attr("PK"), attr("SK"), attr("KeyID"), attr("Email"), attr("Type"), attr("UserID"), attr("Name")},
+		KeySchema:            key("PK", "SK"),
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			index(usernameIndex, "KeyID", "", keysOnly),
+			index(emailIndex, "Email", "", all),
+			index(typeIndex, "Type", "UserID", listed),
+			index(typeNameIndex, "Type", "Name", listed),
+		},
+	})
+	return err
+}
+
+// Users that earlier versions stored in a single partition log in with their username or email
+// address in any case once they have been migrated
+func TestLegacyLogin(t *testing.T) {
+	m := testManager(t)
+	ctx := context.Background()
+
+	_, err := m.dbs.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"PK":      {S: aws.String(legacyPartition)},
+			"SK":      {S: aws.String("u1")},
+			"KeyID":   {S: aws.String("Dwight")},
+			"Payload": {S: aws.String(legacyPayload)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Migrate(ctx); err != nil || n != 1 {
+		t.Fatalf("Migrate() = %d, %v, want 1 user", n, err)
+	}
+
+	for _, identifier := range []string{"dwight", "DWIGHT", "dwight@dunder-mifflin.com", "Dwight@Dunder-Mifflin.com"} {
+		acct, err := auth.FindAccount(ctx, m, identifier)
+		if err != nil {
+			t.Fatalf("FindAccount(%s): %s", identifier, err.Error())
+		}
+		if err := auth.CheckCredentials(acct, "beets"); err != nil {
+			t.Errorf("CheckCredentials(%s): %s", identifier, err.Error())
+		}
+	}
+
+	// The moved user can be updated, and its username is taken
+	acct, err := m.GetUser(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Version != 1 {
+		t.Errorf("version = %d, want 1", acct.Version)
+	}
+	if err := m.UpdateUser(ctx, acct); err != nil {
+		t.Errorf("UpdateUser: %s", err.Error())
+	}
+	other := datastore.Account{}
+	other.ID, other.Username, other.Email = "u2", "dwight", "other@dunder-mifflin.com"
+	if err := m.AddUser(ctx, other); !errors.Is(err, datastore.ErrUserExists) {
+		t.Errorf("AddUser with the username of the moved user = %v, want %v", err, datastore.ErrUserExists)
+	}
+}
+
+// Users that only differ in the case of their username are left in the legacy partition and
+// reported, while the other users are moved
+func TestMigrateReportsDuplicates(t *testing.T) {
+	m := testManager(t)
+	ctx := context.Background()
+
+	for _, usr := range []struct{ id, username, email string }{{"u1", "Dwight", "dwight@dunder-mifflin.com"}, {"u2", "dwight", "schrute@dunder-mifflin.com"}, {"u3", "Jim", "jim@dunder-mifflin.com"}} {
+		payload := fmt.Sprintf(`{"id":%q,"username":%q,"password":"secret","email":%q}`, usr.id, usr.username, usr.email)
+		_, err := m.dbs.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(m.table),
+			Item: map[string]*dynamodb.AttributeValue{
+				"PK":      {S: aws.String(legacyPartition)},
+				"SK":      {S: aws.String(usr.id)},
+				"KeyID":   {S: aws.String(usr.username)},
+				"Payload": {S: aws.String(payload)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := m.Migrate(ctx)
+	if n != 2 || !errors.Is(err, datastore.ErrConflict) {
+		t.Fatalf("Migrate() = %d, %v, want 2 users and a conflict", n, err)
+	}
+	if _, err := m.GetUser(ctx, "u3"); err != nil {
+		t.Errorf("GetUser(u3): %s", err.Error())
+	}
+}
@@ -0,0 +1,61 @@
+package dynamodb
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// storeError translates the errors of DynamoDB that mean the table can't be reached, is
+// throttling requests or didn't answer in time into datastore.ErrUnavailable. Other errors
+// are returned as they are.
+func storeError(err error) error {
+	if err == context.DeadlineExceeded {
+		return datastore.Unavailable(err)
+	}
+
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	switch {
+	case aerr.Code() == request.CanceledErrorCode:
+		// The request is cancelled both when the request it was made for is cancelled and
+		// when the timeout of the data store has passed
+		if aerr.OrigErr() == context.DeadlineExceeded {
+			return datastore.Unavailable(err)
+		}
+		return err
+	case request.IsErrorThrottle(err), request.IsErrorRetryable(err):
+		return datastore.Unavailable(err)
+	}
+
+	if rf, ok := err.(awserr.RequestFailure); ok && rf.StatusCode() >= http.StatusInternalServerError {
+		return datastore.Unavailable(err)
+	}
+
+	return err
+}
+
+// cancellationError translates the reasons a transaction was cancelled that don't depend on
+// the conditions of its items. A transaction that conflicts with another transaction on the
+// same items is translated into datastore.ErrConflict, and a throttled transaction into
+// datastore.ErrUnavailable. It returns nil when the transaction was cancelled for another
+// reason.
+func cancellationError(tce *dynamodb.TransactionCanceledException) error {
+	for _, reason := range tce.CancellationReasons {
+		switch aws.StringValue(reason.Code) {
+		case "TransactionConflict":
+			return datastore.Conflict("the user is changed by another request, try again")
+		case "ThrottlingError", "ProvisionedThroughputExceeded", "RequestLimitExceeded":
+			return datastore.Unavailable(tce)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// AddLogin adds a login attempt to the login history of a user in DynamoDB. Each attempt
+// is a separate item in the partition of the user, with the access pattern
+// PK = USER#<id> SK = LOGIN#<time>. The ExpiresAt attribute is the time to live of the item,
+// so DynamoDB removes attempts once they are older than datastore.LoginRetention. A successful
+// attempt is also stored in the LastLoginAt attribute of the user, which keeps its version.
+func (m manager) AddLogin(ctx context.Context, userID string, attempt user.LoginAttempt) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	payload, err := attempt.Marshal()
+	if err != nil {
+		return err
+	}
+
+	// The sort key has the time in nanoseconds, so attempts made in the same second get
+	// their own item, and is padded so the items sort in the order of time
+	now := time.Now()
+
+	// Create a map of DynamoDB Attribute Values containing the table keys and data elements
+	im := make(map[string]*dynamodb.AttributeValue)
+	im["PK"] = &dynamodb.AttributeValue{
+		S: aws.String(userPartition(userID)),
+	}
+	im["SK"] = &dynamodb.AttributeValue{
+		S: aws.String(fmt.Sprintf("LOGIN#%020d", now.UnixNano())),
+	}
+	im["Payload"] = &dynamodb.AttributeValue{
+		S: aws.String(string(payload)),
+	}
+	im["ExpiresAt"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(now.Add(datastore.LoginRetention).Unix(), 10)),
+	}
+
+	_, err = m.dbs.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.table),
+		Item:      im,
+	})
+	if err != nil || !attempt.Success {
+		return storeError(err)
+	}
+
+	em := make(map[string]*dynamodb.AttributeValue)
+	em[":at"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(attempt.At, 10)),
+	}
+
+	// Erased users have no KeyID and don't get a last login, and a login that is stored late
+	// doesn't replace a later one
+	_, err = m.dbs.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(m.table),
+		Key:                       userKey(userID),
+		ExpressionAttributeValues: em,
+		ConditionExpression:       aws.String("attribute_exists(KeyID) AND (attribute_not_exists(LastLoginAt) OR LastLoginAt < :at)"),
+		UpdateExpression:          aws.String("SET LastLoginAt = :at"),
+	})
+	if _, ok := err.(*dynamodb.ConditionalCheckFailedException); ok {
+		return nil
+	}
+
+	return storeError(err)
+}
+
+// Logins retrieves the login history of a user from DynamoDB, newest first. DynamoDB can take
+// a while to remove items of which the time to live has passed, so those are skipped.
+func (m manager) Logins(ctx context.Context, userID string, limit int) ([]user.LoginAttempt, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Create a map of DynamoDB Attribute Values containing the table keys
+	km := make(map[string]*dynamodb.AttributeValue)
+	km[":pk"] = &dynamodb.AttributeValue{
+		S: aws.String(userPartition(userID)),
+	}
+	km[":sk"] = &dynamodb.AttributeValue{
+		S: aws.String("LOGIN#"),
+	}
+	km[":now"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+	}
+
+	// Create the QueryInput
+	qi := &dynamodb.QueryInput{
+		TableName:                 aws.String(m.table),
+		KeyConditionExpression:    aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		FilterExpression:          aws.String("ExpiresAt > :now"),
+		ExpressionAttributeValues: km,
+		ScanIndexForward:          aws.Bool(false),
+	}
+
+	logins := make([]user.LoginAttempt, 0)
+
+	err := m.dbs.QueryPagesWithContext(ctx, qi, func(qo *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range qo.Items {
+			attempt, err := user.UnmarshalLoginAttempt(*item["Payload"].S)
+			if err != nil {
+				log.Println(fmt.Sprintf("error unmarshalling login data: %s", err.Error()))
+				continue
+			}
+			logins = append(logins, attempt)
+
+			if len(logins) == limit {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	return logins, nil
+}
+
+// deleteLogins removes the login history of a user
+func (m manager) deleteLogins(ctx context.Context, userID string) error {
+	// Create a map of DynamoDB Attribute Values containing the table keys
+	km := make(map[string]*dynamodb.AttributeValue)
+	km[":pk"] = &dynamodb.AttributeValue{
+		S: aws.String(userPartition(userID)),
+	}
+	km[":sk"] = &dynamodb.AttributeValue{
+		S: aws.String("LOGIN#"),
+	}
+
+	// Create the QueryInput, which only needs the keys of the items
+	qi := &dynamodb.QueryInput{
+		TableName:                 aws.String(m.table),
+		KeyConditionExpression:    aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		ExpressionAttributeValues: km,
+		ProjectionExpression:      aws.String("PK, SK"),
+	}
+
+	writes := make([]*dynamodb.WriteRequest, 0)
+
+	err := m.dbs.QueryPagesWithContext(ctx, qi, func(qo *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range qo.Items {
+			writes = append(writes, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{
+					Key: item,
+				},
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return storeError(err)
+	}
+
+	return m.batchWrite(ctx, writes)
+}
@@ -0,0 +1,251 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// legacyPartition is the partition all users were stored in before each user got a partition
+// of its own, with the access pattern PK = USER SK = <id>
+const legacyPartition = "USER"
+
+// Migrate moves the users that are stored in the legacy partition to their own partition. Each
+// user is moved in a transaction that writes the new item and the guard items of its username
+// and email address, and removes the old one, so a user is never lost or stored twice and the
+// migration can be stopped and run again. The transaction is conditional on the version of the
+// old item, so a user that changes while it's moved makes the migration stop with
+// datastore.ErrConflict; running it again moves the user as it is then.
+//
+// Users whose username or email address, regardless of case, already belongs to another user
+// are left in the legacy partition. The other users are still moved, and the error Migrate
+// returns afterwards lists the users that were left.
+func (m manager) Migrate(ctx context.Context) (int, error) {
+	// Create a map of DynamoDB Attribute Values containing the table keys
+	// for the access pattern PK = USER
+	km := make(map[string]*dynamodb.AttributeValue)
+	km[":type"] = &dynamodb.AttributeValue{
+		S: aws.String(legacyPartition),
+	}
+
+	// Create the QueryInput
+	qi := &dynamodb.QueryInput{
+		TableName:                 aws.String(m.table),
+		KeyConditionExpression:    aws.String("PK = :type"),
+		ExpressionAttributeValues: km,
+	}
+
+	moved := 0
+	var duplicates []string
+
+	// The migration can take longer than a single call to the datastore, so each page and
+	// each user gets its own timeout
+	for {
+		qctx, cancel := datastore.WithTimeout(ctx)
+		qo, err := m.dbs.QueryWithContext(qctx, qi)
+		cancel()
+		if err != nil {
+			return moved, storeError(err)
+		}
+
+		for _, item := range qo.Items {
+			err := m.moveUser(ctx, item)
+			if dup, ok := err.(duplicate); ok {
+				duplicates = append(duplicates, string(dup))
+				continue
+			}
+			if err != nil {
+				return moved, err
+			}
+			moved++
+		}
+
+		// Items that have been moved don't have to exist for the next page to start after them
+		if qo.LastEvaluatedKey == nil {
+			break
+		}
+		qi.ExclusiveStartKey = qo.LastEvaluatedKey
+	}
+
+	if len(duplicates) > 0 {
+		return moved, datastore.Conflict("%d users were left in the partition %s, change the username or email address in their payload or remove them, and run the migration again: %s", len(duplicates), legacyPartition, strings.Join(duplicates, "; "))
+	}
+
+	return moved, nil
+}
+
+// duplicate is the reason a user can't be moved out of the legacy partition because it has
+// the same username or email address as another user
+type duplicate string
+
+func (d duplicate) Error() string {
+	return string(d)
+}
+
+// moveUser writes the item of a user in the legacy partition to the partition of the user, and
+// removes the old item in the same transaction. The new item gets the attributes the indexes
+// are based on, which the old item has in the case the user registered in or not at all, and
+// the guard items of the username and email address are written in the same transaction.
+func (m manager) moveUser(ctx context.Context, old map[string]*dynamodb.AttributeValue) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	userID := aws.StringValue(old["SK"].S)
+
+	usr, err := datastore.UnmarshalStoredAccount(aws.StringValue(old["Payload"].S), lastLogin(old))
+	if err != nil {
+		return err
+	}
+
+	// The new item has all attributes of the old item, like the time of the last login, with
+	// the new keys and the attributes of the indexes
+	im := make(map[string]*dynamodb.AttributeValue, len(old)+6)
+	for k, v := range old {
+		im[k] = v
+	}
+	for k, v := range userKey(userID) {
+		im[k] = v
+	}
+	im["Type"] = &dynamodb.AttributeValue{
+		S: aws.String(userType),
+	}
+	im["UserID"] = &dynamodb.AttributeValue{
+		S: aws.String(userID),
+	}
+
+	// Users that were stored before versions existed start at version 1, like new users
+	if usr.Version == 0 {
+		usr.Version = 1
+		payload, err := usr.Marshal()
+		if err != nil {
+			return err
+		}
+		im["Payload"] = &dynamodb.AttributeValue{
+			S: aws.String(string(payload)),
+		}
+	}
+	im["Version"] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(usr.Version, 10)),
+	}
+
+	// Erased users have no username, email address, name or status, so they aren't part of
+	// the indexes that find and search users
+	delete(im, "KeyID")
+	delete(im, "Email")
+	delete(im, "Name")
+	delete(im, "Status")
+	if !usr.Erased() {
+		im["KeyID"] = &dynamodb.AttributeValue{
+			S: aws.String(datastore.NormalizeUsername(usr.Username)),
+		}
+		if email := datastore.SearchEmail(usr.User); len(email) > 0 {
+			im["Email"] = &dynamodb.AttributeValue{
+				S: aws.String(email),
+			}
+		}
+		if name := datastore.SearchName(usr.User); len(name) > 0 {
+			im["Name"] = &dynamodb.AttributeValue{
+				S: aws.String(name),
+			}
+		}
+		im["Status"] = &dynamodb.AttributeValue{
+			S: aws.String(usr.State()),
+		}
+	}
+
+	// Items that were stored before versions existed have no version attribute
+	condition := "attribute_not_exists(#version)"
+	em := make(map[string]*dynamodb.AttributeValue)
+	if version, ok := old["Version"]; ok {
+		condition = "#version = :version"
+		em[":version"] = version
+	}
+
+	del := &dynamodb.Delete{
+		TableName:                aws.String(m.table),
+		Key:                      tableKey(old),
+		ConditionExpression:      aws.String(condition),
+		ExpressionAttributeNames: map[string]*string{"#version": aws.String("Version")},
+	}
+	if len(em) > 0 {
+		del.ExpressionAttributeValues = em
+	}
+
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(m.table),
+				Item:                im,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			},
+		},
+		{
+			Delete: del,
+		},
+	}
+
+	// Users that were stored after guard items existed already have them, so a guard item
+	// may exist as long as it belongs to the user
+	var guards []string
+	if !usr.Erased() {
+		for _, key := range guardKeys(usr) {
+			guards = append(guards, aws.StringValue(key["PK"].S))
+			key["UserID"] = &dynamodb.AttributeValue{
+				S: aws.String(userID),
+			}
+
+			items = append(items, &dynamodb.TransactWriteItem{
+				Put: &dynamodb.Put{
+					TableName:           aws.String(m.table),
+					Item:                key,
+					ConditionExpression: aws.String("attribute_not_exists(PK) OR UserID = :id"),
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":id": {
+							S: aws.String(userID),
+						},
+					},
+				},
+			})
+		}
+	}
+
+	_, err = m.dbs.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	// The reasons are in the order of the items of the transaction: the new item, the old
+	// item and the guard items
+	if tce, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		for idx, reason := range tce.CancellationReasons {
+			if aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			}
+			switch idx {
+			case 0:
+				return duplicate(fmt.Sprintf("user %s is stored in both the legacy partition and its own partition", userID))
+			case 1:
+				return datastore.Conflict("user %s has been changed while it was moved, run the migration again", userID)
+			default:
+				return duplicate(fmt.Sprintf("user %s has the %s of another user", userID, guardName(guards[idx-2])))
+			}
+		}
+		if cerr := cancellationError(tce); cerr != nil {
+			return cerr
+		}
+	}
+
+	return storeError(err)
+}
+
+// guardName returns the username or email address a guard item is for, with what it is
+func guardName(key string) string {
+	if strings.HasPrefix(key, "EMAIL#") {
+		return fmt.Sprintf("email address %s", strings.TrimPrefix(key, "EMAIL#"))
+	}
+	return fmt.Sprintf("username %s", strings.TrimPrefix(key, "USERNAME#"))
+}
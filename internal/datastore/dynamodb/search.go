@@ -0,0 +1,121 @@
+package dynamodb
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+const (
+	// emailIndex is the global secondary index with the lower cased email address
+	// (Email) as partition key
+	emailIndex = "EmailIndex"
+
+	// usernameIndex is the global secondary index with the normalized username (KeyID)
+	// as partition key. It only projects the keys.
+	usernameIndex = "UsernameIndex"
+
+	// typeIndex is the global secondary index with Type as partition key and the ID of
+	// the user (UserID) as sort key. It only projects the keys, KeyID and Status, so
+	// updates of users that don't change their status don't write to the index.
+	typeIndex = "TypeIndex"
+
+	// typeNameIndex is the global secondary index with Type as partition key and the lower
+	// cased "lastname firstname" (Name) as sort key. Like the TypeIndex, it only projects
+	// the keys, KeyID and Status.
+	typeNameIndex = "TypeNameIndex"
+)
+
+// SearchUsers retrieves a page of users from DynamoDB that match the query. Searches on
+// email address use the EmailIndex, searches on name and results sorted by name use the
+// TypeNameIndex and other searches use the TypeIndex. Erased users are not part of the
+// EmailIndex and TypeNameIndex.
+func (m manager) SearchUsers(ctx context.Context, q datastore.Query, limit int, cursor string) (datastore.Page, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	if err := q.Validate(); err != nil {
+		return datastore.Page{}, err
+	}
+
+	field, desc, _ := q.Order()
+
+	em := make(map[string]*dynamodb.AttributeValue)
+	qi := &dynamodb.QueryInput{
+		TableName:        aws.String(m.table),
+		ScanIndexForward: aws.Bool(!desc),
+	}
+
+	var filters []string
+
+	switch {
+	case len(q.Email) > 0:
+		// Email addresses are unique, so there is at most one user
+		qi.IndexName = aws.String(emailIndex)
+		qi.KeyConditionExpression = aws.String("Email = :email")
+		em[":email"] = &dynamodb.AttributeValue{
+			S: aws.String(strings.ToLower(q.Email)),
+		}
+		if len(q.Name) > 0 {
+			filters = append(filters, "begins_with(#name, :name)")
+		}
+	case len(q.Name) > 0 || field == datastore.SortName:
+		qi.IndexName = aws.String(typeNameIndex)
+		qi.KeyConditionExpression = aws.String("#type = :type")
+		if len(q.Name) > 0 {
+			qi.KeyConditionExpression = aws.String("#type = :type AND begins_with(#name, :name)")
+		}
+	default:
+		qi.IndexName = aws.String(typeIndex)
+		qi.KeyConditionExpression = aws.String("#type = :type")
+	}
+
+	an := make(map[string]*string)
+
+	if len(q.Email) == 0 {
+		an["#type"] = aws.String("Type")
+		em[":type"] = &dynamodb.AttributeValue{
+			S: aws.String(userType),
+		}
+	}
+
+	if len(q.Name) > 0 {
+		an["#name"] = aws.String("Name")
+		em[":name"] = &dynamodb.AttributeValue{
+			S: aws.String(strings.ToLower(q.Name)),
+		}
+	}
+
+	// Erased users have no KeyID, and users stored before statuses existed have no
+	// status and are active
+	switch q.Status {
+	case "":
+	case datastore.StatusErased:
+		filters = append(filters, "attribute_not_exists(KeyID)")
+	case datastore.StatusActive:
+		filters = append(filters, "attribute_exists(KeyID) AND (attribute_not_exists(#status) OR #status = :status)")
+	default:
+		filters = append(filters, "#status = :status")
+	}
+
+	if len(q.Status) > 0 && q.Status != datastore.StatusErased {
+		an["#status"] = aws.String("Status")
+		em[":status"] = &dynamodb.AttributeValue{
+			S: aws.String(q.Status),
+		}
+	}
+
+	if len(an) > 0 {
+		qi.ExpressionAttributeNames = an
+	}
+
+	if len(filters) > 0 {
+		qi.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+	qi.ExpressionAttributeValues = em
+
+	return m.pageUsers(ctx, q, qi, limit, cursor)
+}
@@ -0,0 +1,72 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of errors that data stores return. Every error that a data store returns for a
+// missing, conflicting or invalid user, or because the data store can't be reached, matches
+// one of them with errors.Is, so callers can act on the kind of error regardless of the data
+// store that returned it.
+var (
+	// ErrNotFound is the kind of error returned when a user or address doesn't exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is the kind of error returned when a change conflicts with the data that
+	// is already stored
+	ErrConflict = errors.New("conflict")
+
+	// ErrUnavailable is the kind of error returned when the data store can't be reached, is
+	// throttling requests or didn't answer in time
+	ErrUnavailable = errors.New("data store unavailable")
+
+	// ErrInvalid is the kind of error returned when a request to the data store is not valid
+	ErrInvalid = errors.New("invalid request")
+)
+
+// Error is an error of one of the kinds ErrNotFound, ErrConflict, ErrUnavailable or
+// ErrInvalid. The error it was caused by, if any, can be retrieved with errors.Unwrap.
+type Error struct {
+	kind  error
+	msg   string
+	cause error
+}
+
+// Error returns the message of the error
+func (e *Error) Error() string {
+	if e.cause == nil {
+		return e.msg
+	}
+	return fmt.Sprintf("%s: %s", e.msg, e.cause.Error())
+}
+
+// Is returns true when target is the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap returns the error that caused the error
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// NotFound returns an error of the kind ErrNotFound
+func NotFound(format string, a ...interface{}) error {
+	return &Error{kind: ErrNotFound, msg: fmt.Sprintf(format, a...)}
+}
+
+// Conflict returns an error of the kind ErrConflict
+func Conflict(format string, a ...interface{}) error {
+	return &Error{kind: ErrConflict, msg: fmt.Sprintf(format, a...)}
+}
+
+// Invalid returns an error of the kind ErrInvalid
+func Invalid(format string, a ...interface{}) error {
+	return &Error{kind: ErrInvalid, msg: fmt.Sprintf(format, a...)}
+}
+
+// Unavailable returns an error of the kind ErrUnavailable that was caused by err
+func Unavailable(err error) error {
+	return &Error{kind: ErrUnavailable, msg: ErrUnavailable.Error(), cause: err}
+}
@@ -0,0 +1,123 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ExportContributor returns the data of a single kind the User service keeps about a user,
+// so it can be added to a personal data export. The account is read once for the whole
+// export and passed to each contributor, so all parts describe the user as it was at that
+// moment. A contributor returns nil when it has no data about the user.
+type ExportContributor func(ctx context.Context, m Manager, acct Account) (interface{}, error)
+
+var (
+	contributorsMu sync.RWMutex
+	contributors   []namedContributor
+)
+
+type namedContributor struct {
+	name string
+	fn   ExportContributor
+}
+
+// init registers the parts of each export that are read from the account, with the profile
+// of the user first
+func init() {
+	RegisterExportContributor("profile", exportProfile)
+	RegisterExportContributor("statusHistory", exportStatusHistory)
+	RegisterExportContributor("preferences", exportPreferences)
+}
+
+// RegisterExportContributor makes a kind of data available to personal data exports. The
+// data the contributor returns is added to the export under name. Contributors are called
+// in the order in which they are registered. If RegisterExportContributor is called twice
+// with the same name it panics.
+func RegisterExportContributor(name string, fn ExportContributor) {
+	contributorsMu.Lock()
+	defer contributorsMu.Unlock()
+
+	for _, c := range contributors {
+		if c.name == name {
+			panic(fmt.Sprintf("datastore: export contributor %s registered twice", name))
+		}
+	}
+
+	contributors = append(contributors, namedContributor{name: name, fn: fn})
+}
+
+// Export collects all data the User service keeps about a user from the registered
+// export contributors. The result maps the name of each contributor to its data.
+func Export(ctx context.Context, m Manager, userID string) (map[string]interface{}, error) {
+	acct, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	contributorsMu.RLock()
+	defer contributorsMu.RUnlock()
+
+	data := make(map[string]interface{})
+
+	for _, c := range contributors {
+		d, err := c.fn(ctx, m, acct)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting %s: %w", c.name, err)
+		}
+		if d != nil {
+			data[c.name] = d
+		}
+	}
+
+	return data, nil
+}
+
+// exportedProfile is the part of the account that is added to a personal data export.
+// The password is never exported.
+type exportedProfile struct {
+	ID          string   `json:"id"`
+	Username    string   `json:"username"`
+	Firstname   string   `json:"firstname"`
+	Lastname    string   `json:"lastname"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles,omitempty"`
+	Status      string   `json:"status"`
+	CreatedAt   int64    `json:"createdAt,omitempty"`
+	UpdatedAt   int64    `json:"updatedAt,omitempty"`
+	LastLoginAt int64    `json:"lastLoginAt,omitempty"`
+}
+
+// exportProfile contributes the profile of the user to a personal data export
+func exportProfile(ctx context.Context, m Manager, acct Account) (interface{}, error) {
+	return exportedProfile{
+		ID:          acct.ID,
+		Username:    acct.Username,
+		Firstname:   acct.Firstname,
+		Lastname:    acct.Lastname,
+		Email:       acct.Email,
+		Roles:       acct.Roles,
+		Status:      acct.State(),
+		CreatedAt:   acct.CreatedAt,
+		UpdatedAt:   acct.UpdatedAt,
+		LastLoginAt: acct.LastLoginAt,
+	}, nil
+}
+
+// exportStatusHistory contributes the changes of the account status to a personal data export
+func exportStatusHistory(ctx context.Context, m Manager, acct Account) (interface{}, error) {
+	if len(acct.StatusHistory) == 0 {
+		return nil, nil
+	}
+
+	return acct.StatusHistory, nil
+}
+
+// exportPreferences contributes the preferences of the user to a personal data export
+func exportPreferences(ctx context.Context, m Manager, acct Account) (interface{}, error) {
+	if acct.Erased() {
+		return nil, nil
+	}
+
+	return acct.Settings(), nil
+}
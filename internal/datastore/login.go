@@ -0,0 +1,62 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	user "github.com/retgits/acme-serverless-user"
+)
+
+// LoginRetention is how long login attempts are kept in the login history of a user
+const LoginRetention = 90 * 24 * time.Hour
+
+// LoginHistory is implemented by data stores that can keep the login attempts of users,
+// on top of the methods of the Manager interface. The history can only be added to, and
+// attempts are removed by the data store once they are older than LoginRetention.
+type LoginHistory interface {
+	// AddLogin adds an attempt to the login history of the user. When the attempt succeeded
+	// and the user hasn't been erased, its time is also stored as the last login of the user,
+	// next to the user so the version of the user doesn't change.
+	AddLogin(ctx context.Context, userID string, attempt user.LoginAttempt) error
+	// Logins retrieves at most limit attempts from the login history of the user, newest
+	// first. A limit of 0 retrieves the whole history.
+	Logins(ctx context.Context, userID string, limit int) ([]user.LoginAttempt, error)
+}
+
+func init() {
+	RegisterExportContributor("logins", exportLogins)
+}
+
+// RecordLogin adds an attempt to the login history of the user, when the data store keeps
+// one, which also stores the time of a successful attempt as the last login of the user.
+// Attempts of users that have been erased are not recorded.
+func RecordLogin(ctx context.Context, m Manager, acct Account, attempt user.LoginAttempt) error {
+	if acct.Erased() {
+		return nil
+	}
+
+	if h, ok := m.(LoginHistory); ok {
+		return h.AddLogin(ctx, acct.ID, attempt)
+	}
+
+	return nil
+}
+
+// exportLogins contributes the login history of the user to a personal data export
+func exportLogins(ctx context.Context, m Manager, acct Account) (interface{}, error) {
+	h, ok := m.(LoginHistory)
+	if !ok {
+		return nil, nil
+	}
+
+	logins, err := h.Logins(ctx, acct.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(logins) == 0 {
+		return nil, nil
+	}
+
+	return logins, nil
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// Addresses retrieves the address book of a user. The addresses are kept in the record of
+// the user.
+func (m *manager) Addresses(ctx context.Context, userID string) ([]user.Address, error) {
+	if err := check(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	book := make([]user.Address, 0)
+	if rec, ok := m.users[userID]; ok {
+		book = append(book, rec.Addresses...)
+	}
+
+	return book, nil
+}
+
+// SaveAddresses adds, stores and removes the addresses of a user by replacing the address book
+// in the record of the user. Erased users have no username and no address book.
+func (m *manager) SaveAddresses(ctx context.Context, userID string, added []user.Address, changed []user.Address, removed []string) error {
+	if err := check(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[userID]
+	if !ok || len(rec.KeyID) == 0 {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+
+	byID := make(map[string]user.Address, len(rec.Addresses)+len(added))
+	for _, a := range rec.Addresses {
+		byID[a.ID] = a
+	}
+	for _, a := range added {
+		if _, ok := byID[a.ID]; ok {
+			return datastore.ErrAddressBookChanged
+		}
+	}
+	for _, a := range changed {
+		if _, ok := byID[a.ID]; !ok {
+			return datastore.ErrAddressBookChanged
+		}
+	}
+	for _, a := range added {
+		byID[a.ID] = a
+	}
+	for _, a := range changed {
+		byID[a.ID] = a
+	}
+	for _, id := range removed {
+		delete(byID, id)
+	}
+
+	book := make([]user.Address, 0, len(byID))
+	for _, a := range byID {
+		book = append(book, a)
+	}
+	datastore.SortAddresses(book)
+
+	updated := *rec
+	updated.Addresses = book
+	m.users[userID] = &updated
+
+	return m.commit(func() { m.users[userID] = rec })
+}
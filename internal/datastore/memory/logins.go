@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// login is a login attempt as it is kept in the login history of a user
+type login struct {
+	At        time.Time `json:"at"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Payload   string    `json:"payload"`
+}
+
+// AddLogin adds a login attempt to the login history of a user. Attempts that are older than
+// datastore.LoginRetention are removed from the history at the same time. A successful attempt
+// is also stored as the last login in the record of the user, which keeps its version.
+func (m *manager) AddLogin(ctx context.Context, userID string, attempt user.LoginAttempt) error {
+	if err := check(ctx); err != nil {
+		return err
+	}
+
+	payload, err := attempt.Marshal()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, hadHistory := m.logins[userID]
+
+	history := make([]login, 0, len(m.logins[userID])+1)
+	for _, l := range m.logins[userID] {
+		if l.ExpiresAt.After(now) {
+			history = append(history, l)
+		}
+	}
+
+	m.logins[userID] = append(history, login{
+		At:        now,
+		ExpiresAt: now.Add(datastore.LoginRetention),
+		Payload:   string(payload),
+	})
+
+	rec, ok := m.users[userID]
+	if ok && len(rec.KeyID) > 0 && attempt.Success && attempt.At > rec.LastLoginAt {
+		updated := *rec
+		updated.LastLoginAt = attempt.At
+		m.users[userID] = &updated
+	}
+
+	return m.commit(func() {
+		if hadHistory {
+			m.logins[userID] = previous
+		} else {
+			delete(m.logins, userID)
+		}
+		if ok {
+			m.users[userID] = rec
+		}
+	})
+}
+
+// Logins retrieves the login history of a user, newest first
+func (m *manager) Logins(ctx context.Context, userID string, limit int) ([]user.LoginAttempt, error) {
+	if err := check(ctx); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Attempts are added in order, so the newest attempt is the last one
+	stored := m.logins[userID]
+	history := make([]user.LoginAttempt, 0)
+
+	for idx := len(stored) - 1; idx >= 0; idx-- {
+		if limit > 0 && len(history) == limit {
+			break
+		}
+		if !stored[idx].ExpiresAt.After(now) {
+			continue
+		}
+
+		attempt, err := user.UnmarshalLoginAttempt(stored[idx].Payload)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, attempt)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,475 @@
+// Package memory keeps the users of the User service in memory, so the service and the user-admin
+// tool can run without a database for local development and tests. The users can be written to a
+// JSON file after each change and read from it when the data store is opened, so they survive a
+// restart.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// record is a user as it is kept in memory. Like the documents in MongoDB, the fields that users
+// are looked up and searched by are kept next to the payload, and so is the last login, which
+// doesn't change the version. Erased users have no KeyID, Email, Status, last login or address
+// book.
+type record struct {
+	Payload     string         `json:"payload"`
+	KeyID       string         `json:"keyID,omitempty"`
+	Email       string         `json:"email,omitempty"`
+	Name        string         `json:"name,omitempty"`
+	Status      string         `json:"status,omitempty"`
+	Version     int64          `json:"version"`
+	LastLoginAt int64          `json:"lastLoginAt,omitempty"`
+	Addresses   []user.Address `json:"addresses,omitempty"`
+}
+
+// account returns the user of the record, with the last login
+func (rec *record) account() (datastore.Account, error) {
+	return datastore.UnmarshalStoredAccount(rec.Payload, rec.LastLoginAt)
+}
+
+// snapshot is the content of the file the users are written to
+type snapshot struct {
+	Users  map[string]*record `json:"users"`
+	Logins map[string][]login `json:"logins,omitempty"`
+}
+
+// manager keeps the users by their ID and the login history of each user. Every method holds
+// the lock while it reads or changes them, so a manager can be used by concurrent requests.
+type manager struct {
+	mu     sync.RWMutex
+	users  map[string]*record
+	logins map[string][]login
+	path   string
+}
+
+// Config is the configuration of the in-memory data store
+type Config struct {
+	// Path is the path of the JSON file the users are written to. When it's empty, the users
+	// are only kept in memory.
+	Path string
+}
+
+// ConfigFromEnv returns the configuration in the environment variable MEMORY_FILE
+func ConfigFromEnv() Config {
+	return Config{
+		Path: os.Getenv("MEMORY_FILE"),
+	}
+}
+
+func init() {
+	datastore.Register("memory", func() (datastore.Manager, error) {
+		return New(ConfigFromEnv())
+	})
+}
+
+// New creates a new in-memory datastore manager that shares no users with other managers. When
+// the path in the configuration is not empty, the users are read from the file at that path, if
+// it exists, and written to it after each change.
+func New(cfg Config) (datastore.Manager, error) {
+	m := &manager{
+		users:  make(map[string]*record),
+		logins: make(map[string][]login),
+		path:   cfg.Path,
+	}
+
+	if len(cfg.Path) == 0 {
+		return m, nil
+	}
+
+	data, err := ioutil.ReadFile(cfg.Path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("unable to read snapshot %s: %s", cfg.Path, err.Error())
+	}
+	if snap.Users != nil {
+		m.users = snap.Users
+	}
+	if snap.Logins != nil {
+		m.logins = snap.Logins
+	}
+
+	return m, nil
+}
+
+// Close releases the manager. The users are written to the snapshot file after each change, so
+// there is nothing left to write.
+func (m *manager) Close() error {
+	return nil
+}
+
+// save writes the users to the snapshot file, if there is one. The file is replaced in one step,
+// so a process that stops while saving leaves the previous snapshot. The caller must hold the lock.
+func (m *manager) save() error {
+	if len(m.path) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(snapshot{Users: m.users, Logins: m.logins})
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return datastore.Unavailable(err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return datastore.Unavailable(err)
+	}
+
+	return nil
+}
+
+// commit writes the snapshot after a change and calls undo to reverse the change when the
+// snapshot can't be written, so a change that fails is never visible to later calls. The
+// caller must hold the lock.
+func (m *manager) commit(undo func()) error {
+	if err := m.save(); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+// check returns an error when the request a call is made for has been cancelled or its
+// deadline has passed, like the other data stores do
+func check(ctx context.Context) error {
+	switch err := ctx.Err(); err {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return datastore.Unavailable(err)
+	default:
+		return err
+	}
+}
+
+// GetUser retrieves a single user based on the userID
+func (m *manager) GetUser(ctx context.Context, userID string) (datastore.Account, error) {
+	if err := check(ctx); err != nil {
+		return datastore.Account{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec, ok := m.users[userID]
+	if !ok {
+		return datastore.Account{}, datastore.NotFound("no user found with id %s", userID)
+	}
+
+	return rec.account()
+}
+
+// FindUser retrieves a single user based on the username, regardless of case
+func (m *manager) FindUser(ctx context.Context, username string) (datastore.Account, error) {
+	if err := check(ctx); err != nil {
+		return datastore.Account{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key := datastore.NormalizeUsername(username)
+	for _, rec := range m.users {
+		if len(rec.KeyID) > 0 && rec.KeyID == key {
+			return rec.account()
+		}
+	}
+
+	return datastore.Account{}, datastore.NotFound("no user found with name %s", username)
+}
+
+// FindUserByEmail retrieves a single user based on the email address, regardless of case.
+// Erased users have no email address.
+func (m *manager) FindUserByEmail(ctx context.Context, email string) (datastore.Account, error) {
+	if err := check(ctx); err != nil {
+		return datastore.Account{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key := datastore.NormalizeEmail(email)
+	for _, rec := range m.users {
+		if len(rec.Email) > 0 && rec.Email == key {
+			return rec.account()
+		}
+	}
+
+	return datastore.Account{}, datastore.NotFound("no user found with email address %s", email)
+}
+
+// AllUsers retrieves all users that haven't been erased, ordered by their ID
+func (m *manager) AllUsers(ctx context.Context) ([]datastore.Account, error) {
+	if err := check(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]datastore.Account, 0, len(m.users))
+	for _, id := range m.sortedIDs() {
+		rec := m.users[id]
+		if len(rec.KeyID) == 0 {
+			continue
+		}
+
+		usr, err := rec.account()
+		if err != nil {
+			log.Println(fmt.Sprintf("error unmarshalling user data: %s", err.Error()))
+			continue
+		}
+		users = append(users, usr)
+	}
+
+	return users, nil
+}
+
+// ListUsers retrieves a page of users, ordered by their ID. The cursor for the next page
+// contains the ID of the last user in the page.
+func (m *manager) ListUsers(ctx context.Context, limit int, cursor string) (datastore.Page, error) {
+	if err := check(ctx); err != nil {
+		return datastore.Page{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Erased users have no KeyID and are skipped
+	return m.page(datastore.Query{}, func(rec *record) bool { return len(rec.KeyID) > 0 }, limit, cursor)
+}
+
+// sortedIDs returns the IDs of all users in order. The caller must hold the lock.
+func (m *manager) sortedIDs() []string {
+	ids := make([]string, 0, len(m.users))
+	for id := range m.users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// AddUser stores a new user. Each username and email address can only belong to one user.
+func (m *manager) AddUser(ctx context.Context, usr datastore.Account) error {
+	if err := check(ctx); err != nil {
+		return err
+	}
+
+	rec, err := newRecord(usr)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.exists(usr.ID, rec) {
+		return datastore.ErrUserExists
+	}
+	m.users[usr.ID] = rec
+
+	return m.commit(func() { delete(m.users, usr.ID) })
+}
+
+// AddUsers stores new users. A user that can't be stored doesn't stop the other users of the
+// batch from being stored. The snapshot is written once, after the whole batch.
+func (m *manager) AddUsers(ctx context.Context, usrs []datastore.Account) ([]error, error) {
+	if err := check(ctx); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(usrs))
+	added := make([]string, 0, len(usrs))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for idx, usr := range usrs {
+		rec, err := newRecord(usr)
+		if err != nil {
+			errs[idx] = err
+			continue
+		}
+
+		if m.exists(usr.ID, rec) {
+			errs[idx] = datastore.ErrUserExists
+			continue
+		}
+		m.users[usr.ID] = rec
+		added = append(added, usr.ID)
+	}
+
+	// When the snapshot can't be written, none of the users of the batch are stored
+	err := m.commit(func() {
+		for _, id := range added {
+			delete(m.users, id)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// exists returns true if there already is a user with the ID, or with the username or email
+// address of the record. The caller must hold the lock.
+func (m *manager) exists(userID string, rec *record) bool {
+	if _, ok := m.users[userID]; ok {
+		return true
+	}
+
+	for _, other := range m.users {
+		if len(other.KeyID) > 0 && other.KeyID == rec.KeyID {
+			return true
+		}
+		if len(other.Email) > 0 && other.Email == rec.Email {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newRecord returns the record of a new user, with the payload and the fields users are looked
+// up and searched by. New users start at version 1.
+func newRecord(usr datastore.Account) (*record, error) {
+	usr.Version = 1
+	usr.CreatedAt = time.Now().Unix()
+	usr.UpdatedAt = usr.CreatedAt
+
+	payload, err := usr.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return &record{
+		Payload: string(payload),
+		KeyID:   datastore.NormalizeUsername(usr.Username),
+		Email:   datastore.SearchEmail(usr.User),
+		Name:    datastore.SearchName(usr.User),
+		Status:  usr.State(),
+		Version: usr.Version,
+	}, nil
+}
+
+// UpdateUser replaces the payload and the status of a user, together with the email address
+// and name it is found by. Erased users have no username and can't be updated. Only a user with
+// the version the user was read at is updated, so concurrent updates of the same user can't
+// overwrite each other. An email address that belongs to another user returns
+// datastore.ErrUserExists.
+func (m *manager) UpdateUser(ctx context.Context, usr datastore.Account) error {
+	if err := check(ctx); err != nil {
+		return err
+	}
+
+	next := usr
+	next.Version = usr.Version + 1
+	next.UpdatedAt = time.Now().Unix()
+
+	payload, err := next.Marshal()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[usr.ID]
+	if !ok || len(rec.KeyID) == 0 {
+		return datastore.NotFound("no user found with id %s", usr.ID)
+	}
+	if rec.Version != usr.Version {
+		return datastore.ErrVersionConflict
+	}
+
+	email := datastore.SearchEmail(usr.User)
+	for id, other := range m.users {
+		if id != usr.ID && len(email) > 0 && other.Email == email {
+			return datastore.ErrUserExists
+		}
+	}
+
+	updated := *rec
+	updated.Payload = string(payload)
+	updated.Email = email
+	updated.Name = datastore.SearchName(usr.User)
+	updated.Status = usr.State()
+	updated.Version = next.Version
+	m.users[usr.ID] = &updated
+
+	return m.commit(func() { m.users[usr.ID] = rec })
+}
+
+// DeleteUser removes a user. If anonymize is true, the record is kept but the personal data
+// is erased from the payload and the username, email address and address book are removed
+// so the user can no longer be found and the username and email address can be used by new
+// users. In both cases the login history of the user is removed.
+func (m *manager) DeleteUser(ctx context.Context, userID string, anonymize bool) error {
+	if err := check(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.users[userID]
+	if !ok {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+
+	history, hasHistory := m.logins[userID]
+	undo := func() {
+		m.users[userID] = rec
+		if hasHistory {
+			m.logins[userID] = history
+		}
+	}
+
+	if !anonymize {
+		delete(m.users, userID)
+		delete(m.logins, userID)
+		return m.commit(undo)
+	}
+
+	usr, err := rec.account()
+	if err != nil {
+		return err
+	}
+
+	erased := usr.Erase(time.Now())
+	erased.Version = usr.Version + 1
+	payload, err := erased.Marshal()
+	if err != nil {
+		return err
+	}
+
+	m.users[userID] = &record{
+		Payload: string(payload),
+		Version: erased.Version,
+	}
+	delete(m.logins, userID)
+
+	return m.commit(undo)
+}
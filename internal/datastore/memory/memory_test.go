@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/datastore/datastoretest"
+)
+
+func TestConformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) datastore.Manager {
+		m, err := New(Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	})
+}
+
+// A change that can't be written to the snapshot fails and leaves the users as they were
+func TestFailedSaveLeavesUsersUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users.json")
+	db, err := New(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	pam := datastore.Account{User: acmeserverless.User{ID: "u1", Username: "pam", Email: "pam@dundermifflin.com"}}
+	if err := db.AddUser(ctx, pam); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot can't be replaced once its directory is gone
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	jim := datastore.Account{User: acmeserverless.User{ID: "u2", Username: "jim", Email: "jim@dundermifflin.com"}}
+	if err := db.AddUser(ctx, jim); err == nil {
+		t.Fatal("AddUser succeeded without a snapshot")
+	}
+	if _, err := db.GetUser(ctx, "u2"); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("GetUser of a user that failed to be added: got %v, want ErrNotFound", err)
+	}
+	if _, err := db.FindUser(ctx, "jim"); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("FindUser of a user that failed to be added: got %v, want ErrNotFound", err)
+	}
+
+	usr, err := db.GetUser(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr.Firstname = "Pamela"
+	if err := db.UpdateUser(ctx, usr); err == nil {
+		t.Fatal("UpdateUser succeeded without a snapshot")
+	}
+	if after, _ := db.GetUser(ctx, "u1"); after.Firstname != "" || after.Version != usr.Version {
+		t.Errorf("user is %q at version %d after a failed update, want %q at version %d", after.Firstname, after.Version, "", usr.Version)
+	}
+
+	if err := db.DeleteUser(ctx, "u1", true); err == nil {
+		t.Fatal("DeleteUser succeeded without a snapshot")
+	}
+	if _, err := db.FindUser(ctx, "pam"); err != nil {
+		t.Errorf("FindUser of a user that failed to be erased: %v", err)
+	}
+
+	if err := db.DeleteUser(ctx, "u1", false); err == nil {
+		t.Fatal("DeleteUser succeeded without a snapshot")
+	}
+	if _, err := db.GetUser(ctx, "u1"); err != nil {
+		t.Errorf("GetUser of a user that failed to be deleted: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// position is the position in the search results a cursor points to. The name is
+// only used when the results are sorted by name, with the ID to break ties.
+type position struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// SearchUsers retrieves a page of users that match the query, with the same matching and
+// ordering as the other data stores
+func (m *manager) SearchUsers(ctx context.Context, q datastore.Query, limit int, cursor string) (datastore.Page, error) {
+	if err := q.Validate(); err != nil {
+		return datastore.Page{}, err
+	}
+
+	if err := check(ctx); err != nil {
+		return datastore.Page{}, err
+	}
+
+	email := datastore.SearchEmail(acmeserverless.User{Email: q.Email})
+	name := strings.ToLower(q.Name)
+
+	match := func(rec *record) bool {
+		if len(email) > 0 && rec.Email != email {
+			return false
+		}
+
+		// The name is matched as a prefix
+		if len(name) > 0 && !strings.HasPrefix(rec.Name, name) {
+			return false
+		}
+
+		// Erased users have no KeyID, and users stored before statuses existed have no
+		// status and are active
+		switch q.Status {
+		case "":
+			return true
+		case datastore.StatusErased:
+			return len(rec.KeyID) == 0
+		case datastore.StatusActive:
+			return len(rec.KeyID) > 0 && (rec.Status == datastore.StatusActive || len(rec.Status) == 0)
+		default:
+			return rec.Status == q.Status
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.page(q, match, limit, cursor)
+}
+
+// page returns a page of the users that match, in the order of the query, starting after the
+// position the cursor points to. The cursor must have been created for the same query. The
+// caller must hold the lock.
+func (m *manager) page(q datastore.Query, match func(*record) bool, limit int, cursor string) (datastore.Page, error) {
+	limit = datastore.PageSize(limit)
+	field, desc, _ := q.Order()
+
+	var after *position
+	if len(cursor) > 0 {
+		after = &position{}
+		if err := datastore.DecodeSearchCursor(cursor, q, after); err != nil {
+			return datastore.Page{}, err
+		}
+	}
+
+	// less returns true if a comes before b in the requested order
+	less := func(a, b position) bool {
+		if field == datastore.SortName && a.Name != b.Name {
+			if desc {
+				return a.Name > b.Name
+			}
+			return a.Name < b.Name
+		}
+		if desc {
+			return a.ID > b.ID
+		}
+		return a.ID < b.ID
+	}
+
+	found := make([]position, 0)
+	for id, rec := range m.users {
+		if !match(rec) {
+			continue
+		}
+
+		pos := position{ID: id}
+		if field == datastore.SortName {
+			pos.Name = rec.Name
+		}
+
+		if after != nil && !less(*after, pos) {
+			continue
+		}
+		found = append(found, pos)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return less(found[i], found[j])
+	})
+
+	page := datastore.Page{
+		Users: make([]datastore.Account, 0, limit),
+	}
+
+	for idx, pos := range found {
+		if len(page.Users) == limit {
+			next, err := datastore.EncodeSearchCursor(q, found[idx-1])
+			if err != nil {
+				return datastore.Page{}, err
+			}
+			page.Next = next
+			break
+		}
+
+		usr, err := m.users[pos.ID].account()
+		if err != nil {
+			log.Println(fmt.Sprintf("error unmarshalling user data: %s", err.Error()))
+			continue
+		}
+		page.Users = append(page.Users, usr)
+	}
+
+	return page, nil
+}
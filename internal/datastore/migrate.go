@@ -0,0 +1,11 @@
+package datastore
+
+import "context"
+
+// Migrator is implemented by data stores that can't change the layout of their data by
+// themselves when a new version of the service changes it. Migrate rewrites the data that is
+// stored in an older layout and returns the number of users it rewrote. It can be stopped and
+// run again, and rewrites nothing once all data is in the current layout.
+type Migrator interface {
+	Migrate(ctx context.Context) (int, error)
+}
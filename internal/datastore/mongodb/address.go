@@ -0,0 +1,155 @@
+package mongodb
+
+import (
+	"context"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// address is an address as it is embedded in the document of a user
+type address struct {
+	ID              string `bson:"ID"`
+	Name            string `bson:"Name"`
+	Line1           string `bson:"Line1"`
+	Line2           string `bson:"Line2,omitempty"`
+	City            string `bson:"City"`
+	Region          string `bson:"Region,omitempty"`
+	PostalCode      string `bson:"PostalCode,omitempty"`
+	Country         string `bson:"Country"`
+	DefaultShipping bool   `bson:"DefaultShipping"`
+	DefaultBilling  bool   `bson:"DefaultBilling"`
+}
+
+// addressBook is the part of the document of a user that contains the address book. Version
+// is incremented each time the address book is stored, and is 0 for address books that were
+// stored before it existed.
+type addressBook struct {
+	Addresses []address `bson:"Addresses"`
+	Version   int64     `bson:"AddressesVersion"`
+}
+
+// Addresses retrieves the address book of a user from MongoDB. The addresses are
+// embedded in the document of the user.
+func (m manager) Addresses(ctx context.Context, userID string) ([]user.Address, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	doc, err := m.addressBook(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	book := make([]user.Address, len(doc.Addresses))
+	for idx, a := range doc.Addresses {
+		book[idx] = user.Address(a)
+	}
+
+	return book, nil
+}
+
+// addressBook reads the address book and its version from the document of a user
+func (m manager) addressBook(ctx context.Context, userID string) (addressBook, error) {
+	opts := options.FindOne().SetProjection(bson.D{{Key: "Addresses", Value: 1}, {Key: "AddressesVersion", Value: 1}})
+
+	var doc addressBook
+	err := m.users.FindOne(ctx, bson.D{{Key: "SK", Value: userID}}, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return addressBook{}, nil
+	}
+	if err != nil {
+		return addressBook{}, storeError(err)
+	}
+
+	return doc, nil
+}
+
+// SaveAddresses adds, replaces and removes the addresses of a user by replacing the address
+// book embedded in the document of the user. The address book is only replaced when its
+// version is still the version it was read at, so a change made by another request at the
+// same time is never overwritten. Erased users have no username and no address book.
+func (m manager) SaveAddresses(ctx context.Context, userID string, added []user.Address, changed []user.Address, removed []string) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	doc, err := m.addressBook(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]address, len(doc.Addresses)+len(added))
+	for _, a := range doc.Addresses {
+		byID[a.ID] = a
+	}
+	for _, a := range added {
+		if _, ok := byID[a.ID]; ok {
+			return datastore.ErrAddressBookChanged
+		}
+	}
+	for _, a := range changed {
+		if _, ok := byID[a.ID]; !ok {
+			return datastore.ErrAddressBookChanged
+		}
+	}
+	for _, a := range added {
+		byID[a.ID] = address(a)
+	}
+	for _, a := range changed {
+		byID[a.ID] = address(a)
+	}
+	for _, id := range removed {
+		delete(byID, id)
+	}
+
+	book := make([]user.Address, 0, len(byID))
+	for _, a := range byID {
+		book = append(book, user.Address(a))
+	}
+	datastore.SortAddresses(book)
+
+	docs := make([]address, len(book))
+	for idx, a := range book {
+		docs[idx] = address(a)
+	}
+
+	// Address books that were stored before versions existed have no version
+	version := bson.D{{Key: "$exists", Value: false}}
+	if doc.Version > 0 {
+		version = bson.D{{Key: "$eq", Value: doc.Version}}
+	}
+
+	filter := bson.D{
+		{Key: "SK", Value: userID},
+		{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: true}}},
+		{Key: "AddressesVersion", Value: version},
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "Addresses", Value: docs}}},
+		{Key: "$inc", Value: bson.D{{Key: "AddressesVersion", Value: 1}}},
+	}
+
+	res, err := m.users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return storeError(err)
+	}
+
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	// Without the condition on the version, the user would have been found when the address
+	// book has been changed since it was read
+	n, err := m.users.CountDocuments(ctx, filter[:2])
+	if err != nil {
+		return storeError(err)
+	}
+	if n > 0 {
+		return datastore.ErrAddressBookChanged
+	}
+
+	return datastore.NotFound("no user found with id %s", userID)
+}
@@ -0,0 +1,201 @@
+package mongodb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Config is the configuration of the connection to MongoDB
+type Config struct {
+	// URI is the connection string of MongoDB, like mongodb://host1,host2/?replicaSet=rs0 or
+	// mongodb+srv://cluster.example.com. Options in the URI, like tls=true or w=majority, are
+	// used unless the fields below set them.
+	URI string
+
+	// Database is the name of the database
+	Database string
+
+	// Collection is the name of the collection the users are stored in
+	Collection string
+
+	// LoginCollection is the name of the collection the login history is stored in
+	LoginCollection string
+
+	// TLSCAFile is the PEM file with the certificate authorities that sign the certificate of
+	// the server. When it's empty, the certificate authorities of the system are used.
+	TLSCAFile string
+
+	// TLSCertificateKeyFile is the PEM file with the certificate and private key the client
+	// authenticates with
+	TLSCertificateKeyFile string
+
+	// TLSInsecure turns off the verification of the certificate of the server
+	TLSInsecure bool
+
+	// ReadConcern is the level of the read concern, which is local, available, majority,
+	// linearizable or snapshot
+	ReadConcern string
+
+	// WriteConcern is the number of members that must acknowledge a write, majority, or the
+	// name of a tag set
+	WriteConcern string
+
+	// WriteTimeout is how long MongoDB waits for the members of the write concern before it
+	// fails the write
+	WriteTimeout time.Duration
+
+	// SkipIndexes leaves the indexes as they are when connecting. It's meant for migrating the
+	// users of earlier versions, whose usernames and email addresses may not be unique yet;
+	// the migration creates the indexes once they are.
+	SkipIndexes bool
+}
+
+// ConfigFromEnv returns the configuration in the environment. The connection string is taken
+// from MONGO_URI, or built from MONGO_USERNAME, MONGO_PASSWORD, MONGO_HOSTNAME and MONGO_PORT
+// when it isn't set. The other fields are read from MONGO_DATABASE, MONGO_COLLECTION,
+// MONGO_LOGIN_COLLECTION, MONGO_TLS_CA_FILE, MONGO_TLS_CERT_KEY_FILE, MONGO_TLS_INSECURE,
+// MONGO_READ_CONCERN, MONGO_WRITE_CONCERN, MONGO_WRITE_TIMEOUT and MONGO_SKIP_INDEXES.
+func ConfigFromEnv() Config {
+	insecure, _ := strconv.ParseBool(os.Getenv("MONGO_TLS_INSECURE"))
+	timeout, _ := time.ParseDuration(os.Getenv("MONGO_WRITE_TIMEOUT"))
+	skipIndexes, _ := strconv.ParseBool(os.Getenv("MONGO_SKIP_INDEXES"))
+
+	return Config{
+		URI:                   connString(),
+		Database:              envString("MONGO_DATABASE", "acmeserverless"),
+		Collection:            envString("MONGO_COLLECTION", "user"),
+		LoginCollection:       envString("MONGO_LOGIN_COLLECTION", "logins"),
+		TLSCAFile:             os.Getenv("MONGO_TLS_CA_FILE"),
+		TLSCertificateKeyFile: os.Getenv("MONGO_TLS_CERT_KEY_FILE"),
+		TLSInsecure:           insecure,
+		ReadConcern:           os.Getenv("MONGO_READ_CONCERN"),
+		WriteConcern:          os.Getenv("MONGO_WRITE_CONCERN"),
+		WriteTimeout:          timeout,
+		SkipIndexes:           skipIndexes,
+	}
+}
+
+// connString returns the connection string in MONGO_URI, or the mongodb+srv connection string
+// of the host in MONGO_HOSTNAME when it isn't set
+func connString() string {
+	if uri := os.Getenv("MONGO_URI"); len(uri) > 0 {
+		return uri
+	}
+
+	username := os.Getenv("MONGO_USERNAME")
+	password := os.Getenv("MONGO_PASSWORD")
+	hostname := os.Getenv("MONGO_HOSTNAME")
+	port := os.Getenv("MONGO_PORT")
+
+	connString := fmt.Sprintf("mongodb+srv://%s:%s@%s:%s", username, password, hostname, port)
+	if strings.HasSuffix(connString, ":") {
+		connString = connString[:len(connString)-1]
+	}
+
+	return connString
+}
+
+// envString returns the value of the environment variable, or def when it isn't set
+func envString(name string, def string) string {
+	if v := os.Getenv(name); len(v) > 0 {
+		return v
+	}
+	return def
+}
+
+// clientOptions returns the options of the MongoDB client for the configuration
+func (cfg Config) clientOptions() (*options.ClientOptions, error) {
+	if len(cfg.URI) == 0 {
+		return nil, errors.New("the MongoDB connection string is not set")
+	}
+	if len(cfg.Database) == 0 || len(cfg.Collection) == 0 || len(cfg.LoginCollection) == 0 {
+		return nil, errors.New("the MongoDB database and collections are not set")
+	}
+
+	opts := options.Client().ApplyURI(cfg.URI)
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid MongoDB connection string: %s", err.Error())
+	}
+
+	if len(cfg.TLSCAFile) > 0 || len(cfg.TLSCertificateKeyFile) > 0 || cfg.TLSInsecure {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if len(cfg.ReadConcern) > 0 {
+		switch cfg.ReadConcern {
+		case "local", "available", "majority", "linearizable", "snapshot":
+			opts.SetReadConcern(readconcern.New(readconcern.Level(cfg.ReadConcern)))
+		default:
+			return nil, fmt.Errorf("unknown MongoDB read concern %q", cfg.ReadConcern)
+		}
+	}
+
+	if len(cfg.WriteConcern) > 0 || cfg.WriteTimeout > 0 {
+		var wc []writeconcern.Option
+
+		switch n, err := strconv.Atoi(cfg.WriteConcern); {
+		case len(cfg.WriteConcern) == 0:
+		case cfg.WriteConcern == "majority":
+			wc = append(wc, writeconcern.WMajority())
+		case err == nil && n >= 0:
+			wc = append(wc, writeconcern.W(n))
+		case err == nil:
+			return nil, fmt.Errorf("invalid MongoDB write concern %q", cfg.WriteConcern)
+		default:
+			wc = append(wc, writeconcern.WTagSet(cfg.WriteConcern))
+		}
+
+		if cfg.WriteTimeout > 0 {
+			wc = append(wc, writeconcern.WTimeout(cfg.WriteTimeout))
+		}
+
+		opts.SetWriteConcern(writeconcern.New(wc...))
+	}
+
+	return opts, nil
+}
+
+// tlsConfig returns the TLS configuration of the connection to MongoDB
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.TLSInsecure,
+	}
+
+	if len(cfg.TLSCAFile) > 0 {
+		pem, err := ioutil.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading MongoDB CA file: %s", err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MongoDB CA file %s", cfg.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(cfg.TLSCertificateKeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertificateKeyFile, cfg.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading MongoDB certificate and key file: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,35 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// storeError translates the errors of MongoDB that mean the database can't be reached or
+// didn't answer in time into datastore.ErrUnavailable. Other errors are returned as they are.
+func storeError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || err == mongo.ErrClientDisconnected {
+		return datastore.Unavailable(err)
+	}
+
+	if ce, ok := err.(mongo.CommandError); ok {
+		if ce.HasErrorLabel("NetworkError") || ce.HasErrorLabel("TransientTransactionError") || ce.IsMaxTimeMSExpiredError() {
+			return datastore.Unavailable(err)
+		}
+	}
+
+	// The driver returns server selection errors, when no server is available, as text
+	if strings.HasPrefix(err.Error(), "server selection error") {
+		return datastore.Unavailable(err)
+	}
+
+	return err
+}
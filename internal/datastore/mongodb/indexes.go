@@ -0,0 +1,74 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// nameIndex is the index on the normalized name and the ID of users, which searches on name
+// and results sorted by name use
+const nameIndex = "Name_1_SK_1"
+
+// userIndexes are the indexes of the collection of users. User IDs, usernames and email
+// addresses are unique. The indexes on usernames and email addresses are sparse so documents of
+// erased users, which have neither, are not part of the index. The index on the user ID is used
+// to list users in order and the index on the name, which is lower case, is used to search
+// users by a prefix of their name and sort them by name.
+var userIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "SK", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	},
+	{
+		Keys:    bson.D{{Key: "KeyID", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	},
+	{
+		Keys:    bson.D{{Key: "Email", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	},
+	{
+		Keys:    bson.D{{Key: "Name", Value: 1}, {Key: "SK", Value: 1}},
+		Options: options.Index().SetName(nameIndex),
+	},
+}
+
+// loginIndexes are the indexes of the collection of login attempts. Login attempts are listed
+// per user, newest first, and MongoDB removes them once the time in ExpiresAt has passed.
+var loginIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{{Key: "UserID", Value: 1}, {Key: "At", Value: -1}},
+	},
+	{
+		Keys:    bson.D{{Key: "ExpiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	},
+}
+
+// ensureIndexes creates the indexes of the collections when they don't exist yet. Creating an
+// index that already exists with the same keys and options does nothing, so every instance of
+// the service can do this at start. An index with the same keys but other options, like an
+// index on KeyID that isn't unique, is an error, because the manager relies on the options to
+// keep usernames and email addresses unique.
+//
+// Users that were stored by earlier versions can have usernames or email addresses that only
+// differ in case, which the unique indexes don't allow. The error then says how to find and
+// rewrite these users with the migration.
+func (m manager) ensureIndexes(ctx context.Context) error {
+	if _, err := m.users.Indexes().CreateMany(ctx, userIndexes); err != nil {
+		if ce, ok := err.(mongo.CommandError); ok && ce.Code == 11000 {
+			return fmt.Errorf("error creating indexes on collection %s: some usernames or email addresses belong to more than one user, run user-admin -backend mongodb migrate with MONGO_SKIP_INDEXES=true to list them: %s", m.users.Name(), err.Error())
+		}
+		return fmt.Errorf("error creating indexes on collection %s: %s", m.users.Name(), err.Error())
+	}
+
+	if _, err := m.logins.Indexes().CreateMany(ctx, loginIndexes); err != nil {
+		return fmt.Errorf("error creating indexes on collection %s: %s", m.logins.Name(), err.Error())
+	}
+
+	return nil
+}
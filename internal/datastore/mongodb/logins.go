@@ -0,0 +1,92 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// login is a login attempt as it is stored in the logins collection. At orders the history
+// of a user and ExpiresAt is a date, so the TTL index can remove the attempt.
+type login struct {
+	UserID    string    `bson:"UserID"`
+	At        time.Time `bson:"At"`
+	ExpiresAt time.Time `bson:"ExpiresAt"`
+	Payload   string    `bson:"Payload"`
+}
+
+// AddLogin adds a login attempt to the login history of a user in MongoDB. Each attempt is
+// a separate document in the logins collection, which MongoDB removes once it is older than
+// datastore.LoginRetention. A successful attempt is also stored as the last login in the
+// document of the user, which keeps its version.
+func (m manager) AddLogin(ctx context.Context, userID string, attempt user.LoginAttempt) error {
+	payload, err := attempt.Marshal()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err = m.logins.InsertOne(ctx, login{
+		UserID:    userID,
+		At:        now,
+		ExpiresAt: now.Add(datastore.LoginRetention),
+		Payload:   string(payload),
+	})
+	if err != nil || !attempt.Success {
+		return storeError(err)
+	}
+
+	// Erased users have no KeyID and don't get a last login
+	filter := bson.D{
+		{Key: "SK", Value: userID},
+		{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: true}}},
+	}
+
+	_, err = m.users.UpdateOne(ctx, filter, bson.D{{Key: "$max", Value: bson.D{{Key: "LastLoginAt", Value: attempt.At}}}})
+	return storeError(err)
+}
+
+// Logins retrieves the login history of a user from MongoDB, newest first
+func (m manager) Logins(ctx context.Context, userID string, limit int) ([]user.LoginAttempt, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "At", Value: -1}}).SetLimit(int64(limit))
+
+	cursor, err := m.logins.Find(ctx, bson.D{{Key: "UserID", Value: userID}}, opts)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	defer cursor.Close(ctx)
+
+	history := make([]user.LoginAttempt, 0)
+
+	for cursor.Next(ctx) {
+		var doc login
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		attempt, err := user.UnmarshalLoginAttempt(doc.Payload)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, attempt)
+	}
+
+	return history, storeError(cursor.Err())
+}
+
+// deleteLogins removes the login history of a user
+func (m manager) deleteLogins(ctx context.Context, userID string) error {
+	_, err := m.logins.DeleteMany(ctx, bson.D{{Key: "UserID", Value: userID}})
+	return storeError(err)
+}
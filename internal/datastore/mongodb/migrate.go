@@ -0,0 +1,192 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// storedUser is the document of a user as the migration reads it, with the fields the indexes
+// are based on as they are stored
+type storedUser struct {
+	account datastore.Account
+	keyID   string
+	email   string
+	name    string
+	status  string
+
+	// version is the Version field, or 0 for documents that were stored before versions existed
+	version int64
+}
+
+// current returns true if the fields of the document match the user, so it doesn't have to
+// be rewritten
+func (u storedUser) current() bool {
+	return u.version > 0 &&
+		u.keyID == datastore.NormalizeUsername(u.account.Username) &&
+		u.email == datastore.SearchEmail(u.account.User) &&
+		u.name == datastore.SearchName(u.account.User) &&
+		u.status == u.account.State()
+}
+
+// Migrate rewrites the documents of users that were stored by earlier versions, which have the
+// username in KeyID in the case it was registered in and miss some of the Email, Name, Status
+// and Version fields, and creates the indexes once all documents have them. Each document is
+// rewritten only if it still has the version it was read at, so a user that changes while it's
+// migrated makes the migration stop with datastore.ErrConflict; running it again rewrites the
+// user as it is then.
+//
+// Earlier versions compared usernames and email addresses in the case they were registered in,
+// so two users can have usernames or email addresses that only differ in case, which the unique
+// indexes don't allow. Migrate looks for them before it rewrites anything, and when it finds
+// any, it rewrites nothing and returns an error that lists them.
+func (m manager) Migrate(ctx context.Context) (int, error) {
+	usrs, err := m.storedUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := collisions(usrs); err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, usr := range usrs {
+		if usr.current() {
+			continue
+		}
+		if err := m.rewriteUser(ctx, usr); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	return migrated, m.ensureIndexes(ctx)
+}
+
+// storedUsers reads the documents of all users that haven't been erased. The migration can
+// take longer than a single call to the datastore, so the documents are read with the context
+// of the migration.
+func (m manager) storedUsers(ctx context.Context) ([]storedUser, error) {
+	// Erased users have no KeyID and nothing to migrate
+	cur, err := m.users.Find(ctx, bson.D{{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: true}}}})
+	if err != nil {
+		return nil, storeError(err)
+	}
+	defer cur.Close(ctx)
+
+	var usrs []storedUser
+	for cur.Next(ctx) {
+		acct, err := datastore.UnmarshalStoredAccount(cur.Current.Lookup("Payload").StringValue(), lastLogin(cur.Current))
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling user %s: %s", cur.Current.Lookup("SK").StringValue(), err.Error())
+		}
+
+		usr := storedUser{
+			account: acct,
+		}
+		usr.keyID, _ = cur.Current.Lookup("KeyID").StringValueOK()
+		usr.email, _ = cur.Current.Lookup("Email").StringValueOK()
+		usr.name, _ = cur.Current.Lookup("Name").StringValueOK()
+		usr.status, _ = cur.Current.Lookup("Status").StringValueOK()
+		if v, ok := cur.Current.Lookup("Version").Int64OK(); ok {
+			usr.version = v
+		} else if v, ok := cur.Current.Lookup("Version").Int32OK(); ok {
+			usr.version = int64(v)
+		}
+
+		usrs = append(usrs, usr)
+	}
+
+	return usrs, storeError(cur.Err())
+}
+
+// collisions returns datastore.ErrConflict with the usernames and email addresses that, in
+// lower case, belong to more than one user, or nil if there are none
+func collisions(usrs []storedUser) error {
+	owners := make(map[string][]string)
+	for _, usr := range usrs {
+		key := fmt.Sprintf("username %s", datastore.NormalizeUsername(usr.account.Username))
+		owners[key] = append(owners[key], usr.account.ID)
+		if email := datastore.SearchEmail(usr.account.User); len(email) > 0 {
+			key := fmt.Sprintf("email address %s", email)
+			owners[key] = append(owners[key], usr.account.ID)
+		}
+	}
+
+	var shared []string
+	for key, ids := range owners {
+		if len(ids) > 1 {
+			shared = append(shared, fmt.Sprintf("%s is used by users %s", key, strings.Join(ids, ", ")))
+		}
+	}
+
+	if len(shared) == 0 {
+		return nil
+	}
+
+	sort.Strings(shared)
+	return datastore.Conflict("nothing was migrated because %d usernames or email addresses belong to more than one user, change them so each belongs to one user and run the migration again: %s", len(shared), strings.Join(shared, "; "))
+}
+
+// rewriteUser sets the fields the indexes are based on to the values of the user, and gives
+// users that were stored before versions existed version 1, like new users
+func (m manager) rewriteUser(ctx context.Context, usr storedUser) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Documents that were stored before versions existed have no version
+	var version interface{} = usr.version
+	if usr.version == 0 {
+		version = bson.D{{Key: "$exists", Value: false}}
+	}
+
+	filter := bson.D{
+		{Key: "SK", Value: usr.account.ID},
+		{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: true}}},
+		{Key: "Version", Value: version},
+	}
+
+	set := bson.D{
+		{Key: "KeyID", Value: datastore.NormalizeUsername(usr.account.Username)},
+		{Key: "Name", Value: datastore.SearchName(usr.account.User)},
+		{Key: "Status", Value: usr.account.State()},
+	}
+
+	if usr.version == 0 {
+		next := usr.account
+		next.Version = 1
+		payload, err := next.Marshal()
+		if err != nil {
+			return err
+		}
+		set = append(set, bson.E{Key: "Payload", Value: string(payload)}, bson.E{Key: "Version", Value: next.Version})
+	}
+
+	// Users without an email address are not part of the email index
+	update := bson.D{}
+	if email := datastore.SearchEmail(usr.account.User); len(email) > 0 {
+		set = append(set, bson.E{Key: "Email", Value: email})
+	} else {
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{{Key: "Email", Value: ""}}})
+	}
+	update = append(update, bson.E{Key: "$set", Value: set})
+
+	res, err := m.users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return storeError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return datastore.Conflict("user %s has been changed while it was migrated, run the migration again", usr.account.ID)
+	}
+
+	return nil
+}
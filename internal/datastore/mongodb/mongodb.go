@@ -6,124 +6,461 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
-	"strings"
 	"time"
 
-	acmeserverless "github.com/retgits/acme-serverless"
 	"github.com/retgits/acme-serverless-user/internal/datastore"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// The pointer to MongoDB provides the API operation methods for making requests to MongoDB.
-// This specifically creates a single instance of the MongoDB service which can be reused if the
-// container stays warm.
-var dbs *mongo.Collection
-
-// manager is an empty struct that implements the methods of the
-// Manager interface.
-type manager struct{}
+// manager keeps the client of MongoDB, which is reused for all requests if the container stays
+// warm, and the collections the users and their login history are stored in.
+type manager struct {
+	client *mongo.Client
+	users  *mongo.Collection
+	logins *mongo.Collection
+}
 
-// init creates the connection to MongoDB.
 func init() {
-	username := os.Getenv("MONGO_USERNAME")
-	password := os.Getenv("MONGO_PASSWORD")
-	hostname := os.Getenv("MONGO_HOSTNAME")
-	port := os.Getenv("MONGO_PORT")
+	datastore.Register("mongodb", func() (datastore.Manager, error) {
+		return New(ConfigFromEnv())
+	})
+}
 
-	connString := fmt.Sprintf("mongodb+srv://%s:%s@%s:%s", username, password, hostname, port)
-	if strings.HasSuffix(connString, ":") {
-		connString = connString[:len(connString)-1]
+// New creates a new datastore manager using MongoDB as backend. It connects to MongoDB and
+// creates the indexes the manager needs when they don't exist yet, unless cfg.SkipIndexes is set.
+func New(cfg Config) (datastore.Manager, error) {
+	opts, err := cfg.clientOptions()
+	if err != nil {
+		return nil, err
 	}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connString))
+
+	ctx, cancel := context.WithTimeout(context.Background(), datastore.Timeout())
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		log.Fatalf("error connecting to MongoDB: %s", err.Error())
+		return nil, fmt.Errorf("error connecting to MongoDB: %s", err.Error())
+	}
+
+	m := manager{
+		client: client,
+		users:  client.Database(cfg.Database).Collection(cfg.Collection),
+		logins: client.Database(cfg.Database).Collection(cfg.LoginCollection),
+	}
+
+	// The indexes are also the first request to MongoDB, so a server that can't be reached
+	// stops the service at start instead of at its first request
+	if cfg.SkipIndexes {
+		if err := client.Ping(ctx, nil); err != nil {
+			client.Disconnect(ctx)
+			return nil, fmt.Errorf("error connecting to MongoDB: %s", err.Error())
+		}
+		return m, nil
 	}
-	dbs = client.Database("acmeserverless").Collection("user")
+
+	if err := m.ensureIndexes(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	return m, nil
 }
 
-// New creates a new datastore manager using Amazon DynamoDB as backend
-func New() datastore.Manager {
-	return manager{}
+// Close disconnects from MongoDB
+func (m manager) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), datastore.Timeout())
+	defer cancel()
+
+	return m.client.Disconnect(ctx)
 }
 
 // GetUser retrieves a single user from MongoDB based on the userID
-func (m manager) GetUser(userID string) (acmeserverless.User, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+func (m manager) GetUser(ctx context.Context, userID string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
 
-	res := dbs.FindOne(ctx, bson.D{{"SK", userID}})
+	res := m.users.FindOne(ctx, bson.D{{Key: "SK", Value: userID}})
 
 	raw, err := res.DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return datastore.Account{}, datastore.NotFound("no user found with id %s", userID)
+	}
 	if err != nil {
-		return acmeserverless.User{}, fmt.Errorf("unable to decode bytes: %s", err.Error())
+		return datastore.Account{}, storeError(err)
 	}
 
 	payload := raw.Lookup("Payload").StringValue()
-	return acmeserverless.UnmarshalUser(payload)
+	return datastore.UnmarshalStoredAccount(payload, lastLogin(raw))
 }
 
-// FindUser retrieves a single user from DynamoDB based on the username
-func (m manager) FindUser(username string) (acmeserverless.User, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+// FindUser retrieves a single user from MongoDB based on the username. The KeyID of a
+// user is the normalized username, users that were stored before usernames were normalized
+// can still be found with the username in the case it was registered in.
+func (m manager) FindUser(ctx context.Context, username string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	keys := bson.A{datastore.NormalizeUsername(username)}
+	if username != datastore.NormalizeUsername(username) {
+		keys = append(keys, username)
+	}
 
-	res := dbs.FindOne(ctx, bson.D{{"KeyID", username}})
+	res := m.users.FindOne(ctx, bson.D{{Key: "KeyID", Value: bson.D{{Key: "$in", Value: keys}}}})
 
 	raw, err := res.DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return datastore.Account{}, datastore.NotFound("no user found with name %s", username)
+	}
 	if err != nil {
-		return acmeserverless.User{}, fmt.Errorf("unable to decode bytes: %s", err.Error())
+		return datastore.Account{}, storeError(err)
 	}
 
 	payload := raw.Lookup("Payload").StringValue()
 
 	// Return an error if no user was found
 	if len(payload) < 5 {
-		return acmeserverless.User{}, fmt.Errorf("no user found with name %s", username)
+		return datastore.Account{}, datastore.NotFound("no user found with name %s", username)
 	}
 
 	// Create a user struct from the data
-	return acmeserverless.UnmarshalUser(payload)
+	return datastore.UnmarshalStoredAccount(payload, lastLogin(raw))
 }
 
-// AllUsers retrieves all users from DynamoDB
-func (m manager) AllUsers() ([]acmeserverless.User, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	cursor, err := dbs.Find(ctx, bson.D{})
+// FindUserByEmail retrieves a single user from MongoDB based on the email address, using
+// the index on the lower cased email address. Erased users have no email address.
+func (m manager) FindUserByEmail(ctx context.Context, email string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	res := m.users.FindOne(ctx, bson.D{{Key: "Email", Value: datastore.NormalizeEmail(email)}})
+
+	raw, err := res.DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return datastore.Account{}, datastore.NotFound("no user found with email address %s", email)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return datastore.Account{}, storeError(err)
+	}
+
+	payload := raw.Lookup("Payload").StringValue()
+
+	// Return an error if no user was found
+	if len(payload) < 5 {
+		return datastore.Account{}, datastore.NotFound("no user found with email address %s", email)
 	}
 
-	var results []bson.M
+	// Create a user struct from the data
+	return datastore.UnmarshalStoredAccount(payload, lastLogin(raw))
+}
+
+// AllUsers retrieves all users from MongoDB
+func (m manager) AllUsers(ctx context.Context) ([]datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
 
-	if err = cursor.All(ctx, &results); err != nil {
-		log.Fatal(err)
+	// Erased users have no KeyID and are skipped
+	cursor, err := m.users.Find(ctx, bson.D{{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: true}}}})
+	if err != nil {
+		return nil, storeError(err)
 	}
 
-	users := make([]acmeserverless.User, len(results))
+	return decodeUsers(ctx, cursor)
+}
+
+// ListUsers retrieves a page of users from MongoDB, ordered by their ID. The cursor for
+// the next page contains the ID of the last user in the page.
+func (m manager) ListUsers(ctx context.Context, limit int, cursor string) (datastore.Page, error) {
+	limit = datastore.PageSize(limit)
+
+	// Erased users have no KeyID and are skipped
+	filter := bson.D{{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: true}}}}
 
-	for idx, ct := range results {
-		usr, err := acmeserverless.UnmarshalUser(ct["Payload"].(string))
+	if len(cursor) > 0 {
+		var position string
+		if err := datastore.DecodeCursor(cursor, &position); err != nil {
+			return datastore.Page{}, err
+		}
+		filter = append(filter, bson.E{Key: "SK", Value: bson.D{{Key: "$gt", Value: position}}})
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Ask for one more user than needed to know whether there is a next page
+	opts := options.Find().SetSort(bson.D{{Key: "SK", Value: 1}}).SetLimit(int64(limit + 1))
+	cur, err := m.users.Find(ctx, filter, opts)
+	if err != nil {
+		return datastore.Page{}, storeError(err)
+	}
+
+	users, err := decodeUsers(ctx, cur)
+	if err != nil {
+		return datastore.Page{}, err
+	}
+
+	page := datastore.Page{
+		Users: users,
+	}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		next, err := datastore.EncodeCursor(page.Users[limit-1].ID)
+		if err != nil {
+			return datastore.Page{}, err
+		}
+		page.Next = next
+	}
+
+	return page, nil
+}
+
+// decodeUsers reads the users from a cursor one document at a time
+func decodeUsers(ctx context.Context, cursor *mongo.Cursor) ([]datastore.Account, error) {
+	defer cursor.Close(ctx)
+
+	users := make([]datastore.Account, 0)
+
+	for cursor.Next(ctx) {
+		usr, err := datastore.UnmarshalStoredAccount(cursor.Current.Lookup("Payload").StringValue(), lastLogin(cursor.Current))
 		if err != nil {
 			log.Println(fmt.Sprintf("error unmarshalling user data: %s", err.Error()))
 			continue
 		}
-		users[idx] = usr
+		users = append(users, usr)
+	}
+
+	return users, storeError(cursor.Err())
+}
+
+// lastLogin returns the time of the last login in the document of a user, which is kept next
+// to the payload so a login doesn't change the version, or 0 if the user hasn't logged in
+func lastLogin(doc bson.Raw) int64 {
+	at, _ := doc.Lookup("LastLoginAt").Int64OK()
+	return at
+}
+
+// AddUser stores a new user in MongoDB. The unique indexes on the username and email
+// address make sure each of them can only belong to one user.
+func (m manager) AddUser(ctx context.Context, usr datastore.Account) error {
+	doc, err := userDocument(usr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+	_, err = m.users.InsertOne(ctx, doc)
+
+	if isDuplicateKey(err) {
+		return datastore.ErrUserExists
+	}
+
+	return storeError(err)
+}
+
+// AddUsers stores new users in MongoDB with a single unordered InsertMany, so a user that
+// can't be stored doesn't stop the other users of the batch from being stored.
+func (m manager) AddUsers(ctx context.Context, usrs []datastore.Account) ([]error, error) {
+	errs := make([]error, len(usrs))
+
+	// index maps the position of a document in the InsertMany to the position of the user
+	index := make([]int, 0, len(usrs))
+	docs := make([]interface{}, 0, len(usrs))
+
+	for idx, usr := range usrs {
+		doc, err := userDocument(usr)
+		if err != nil {
+			errs[idx] = err
+			continue
+		}
+		index = append(index, idx)
+		docs = append(docs, doc)
+	}
+
+	if len(docs) == 0 {
+		return errs, nil
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+	_, err := m.users.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+
+	if bwe, ok := err.(mongo.BulkWriteException); ok && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			errs[index[we.Index]] = writeError(we.WriteError)
+		}
+		return errs, nil
 	}
 
-	return users, nil
+	return errs, storeError(err)
 }
 
-// AddUser stores a new user in Amazon DynamoDB
-func (m manager) AddUser(usr acmeserverless.User) error {
+// userDocument returns the document of a new user, with the payload and the fields the
+// indexes are based on. New users start at version 1.
+func userDocument(usr datastore.Account) (bson.D, error) {
+	usr.Version = 1
+	usr.CreatedAt = time.Now().Unix()
+	usr.UpdatedAt = usr.CreatedAt
+
 	payload, err := usr.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.D{
+		{Key: "SK", Value: usr.ID},
+		{Key: "KeyID", Value: datastore.NormalizeUsername(usr.Username)},
+		{Key: "PK", Value: "USER"},
+		{Key: "Payload", Value: string(payload)},
+		{Key: "Name", Value: datastore.SearchName(usr.User)},
+		{Key: "Status", Value: usr.State()},
+		{Key: "Version", Value: usr.Version},
+	}
+
+	// Users without an email address are not part of the email index
+	if email := datastore.SearchEmail(usr.User); len(email) > 0 {
+		doc = append(doc, bson.E{Key: "Email", Value: email})
+	}
+
+	return doc, nil
+}
+
+// UpdateUser replaces the payload and the status of a user in MongoDB, together with the
+// email address and name it is found by. Erased users have no username and can't be updated.
+// Only the document with the version the user was read at is updated, so concurrent updates of
+// the same user can't overwrite each other. The unique index on the email address returns
+// datastore.ErrUserExists for an email address that belongs to another user.
+func (m manager) UpdateUser(ctx context.Context, usr datastore.Account) error {
+	next := usr
+	next.Version = usr.Version + 1
+	next.UpdatedAt = time.Now().Unix()
+
+	payload, err := next.Marshal()
 	if err != nil {
 		return err
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	_, err = dbs.InsertOne(ctx, bson.D{{"SK", usr.ID}, {"KeyID", usr.Username}, {"PK", "USER"}, {"Payload", string(payload)}})
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Documents that were stored before versions existed have no version
+	var version interface{} = usr.Version
+	if usr.Version == 0 {
+		version = bson.D{{Key: "$exists", Value: false}}
+	}
+
+	filter := bson.D{
+		{Key: "SK", Value: usr.ID},
+		{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: true}}},
+		{Key: "Version", Value: version},
+	}
+
+	set := bson.D{{Key: "Payload", Value: string(payload)}, {Key: "Name", Value: datastore.SearchName(usr.User)}, {Key: "Status", Value: usr.State()}, {Key: "Version", Value: next.Version}}
 
-	return err
+	// Users without an email address are not part of the email index
+	update := bson.D{}
+	if email := datastore.SearchEmail(usr.User); len(email) > 0 {
+		set = append(set, bson.E{Key: "Email", Value: email})
+	} else {
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{{Key: "Email", Value: ""}}})
+	}
+	update = append(update, bson.E{Key: "$set", Value: set})
+
+	res, err := m.users.UpdateOne(ctx, filter, update)
+	if isDuplicateKey(err) {
+		return datastore.ErrUserExists
+	}
+	if err != nil {
+		return storeError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		// No document matches both when the user doesn't exist and when it has another version
+		n, err := m.users.CountDocuments(ctx, filter[:2])
+		if err != nil {
+			return storeError(err)
+		}
+		if n > 0 {
+			return datastore.ErrVersionConflict
+		}
+		return datastore.NotFound("no user found with id %s", usr.ID)
+	}
+
+	return nil
+}
+
+// DeleteUser removes a user from MongoDB. If anonymize is true, the document is kept
+// but the personal data is erased from the payload and the username, email address, last login
+// and address book are removed so the user can no longer be found and the username and email
+// address can be used by new users. In both cases the login history of the user is removed.
+func (m manager) DeleteUser(ctx context.Context, userID string, anonymize bool) error {
+	if !anonymize {
+		ctx, cancel := datastore.WithTimeout(ctx)
+		defer cancel()
+
+		res, err := m.users.DeleteOne(ctx, bson.D{{Key: "SK", Value: userID}})
+		if err != nil {
+			return storeError(err)
+		}
+
+		if res.DeletedCount == 0 {
+			return datastore.NotFound("no user found with id %s", userID)
+		}
+
+		return m.deleteLogins(ctx, userID)
+	}
+
+	usr, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	erased := usr.Erase(time.Now())
+	erased.Version = usr.Version + 1
+	payload, err := erased.Marshal()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "Payload", Value: string(payload)}, {Key: "Name", Value: ""}, {Key: "Version", Value: erased.Version}}},
+		{Key: "$unset", Value: bson.D{{Key: "KeyID", Value: ""}, {Key: "Email", Value: ""}, {Key: "Status", Value: ""}, {Key: "LastLoginAt", Value: ""}, {Key: "Addresses", Value: ""}}},
+	}
+
+	res, err := m.users.UpdateOne(ctx, bson.D{{Key: "SK", Value: userID}}, update)
+	if err != nil {
+		return storeError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+
+	return m.deleteLogins(ctx, userID)
+}
+
+// isDuplicateKey returns true if the error is caused by a violation of a unique index
+func isDuplicateKey(err error) bool {
+	if we, ok := err.(mongo.WriteException); ok {
+		for _, e := range we.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeError returns ErrUserExists if the write error is caused by a violation of a unique
+// index and the write error itself otherwise
+func writeError(we mongo.WriteError) error {
+	if we.Code == 11000 {
+		return datastore.ErrUserExists
+	}
+	return we
 }
@@ -0,0 +1,161 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retgits/acme-serverless-user/internal/auth"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/datastore/datastoretest"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// legacyPayload is a user as earlier versions stored it, with the username and email address
+// in the case they were registered in
+const legacyPayload = `{"id":"u1","username":"Dwight","password":"beets","firstname":"Dwight","lastname":"Schrute","email":"Dwight@Dunder-Mifflin.com"}`
+
+// testConfig returns the configuration of a new, empty database in the MongoDB in
+// MONGO_TEST_URI, and skips the test when it isn't set. The database is dropped when the test
+// ends.
+func testConfig(t *testing.T) Config {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if len(uri) == 0 {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	cfg := Config{
+		URI:             uri,
+		Database:        fmt.Sprintf("user%d", time.Now().UnixNano()),
+		Collection:      "user",
+		LoginCollection: "logins",
+	}
+
+	t.Cleanup(func() {
+		cfg.SkipIndexes = true
+		m, err := New(cfg)
+		if err != nil {
+			return
+		}
+		m.(manager).users.Database().Drop(context.Background())
+		m.Close()
+	})
+
+	return cfg
+}
+
+// The conformance tests run against a database of their own in the MongoDB in MONGO_TEST_URI
+func TestConformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) datastore.Manager {
+		m, err := New(testConfig(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	})
+}
+
+// open returns a manager for the configuration, which is closed when the test ends
+func open(t *testing.T, cfg Config) manager {
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Close()
+	})
+	return m.(manager)
+}
+
+// insertLegacy stores users the way earlier versions did, with the username in KeyID in the
+// case it was registered in and without the fields the indexes are based on
+func insertLegacy(t *testing.T, m manager, payloads ...string) {
+	for _, payload := range payloads {
+		acct, err := datastore.UnmarshalAccount(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := bson.D{{Key: "SK", Value: acct.ID}, {Key: "KeyID", Value: acct.Username}, {Key: "PK", Value: "USER"}, {Key: "Payload", Value: payload}}
+		if _, err := m.users.InsertOne(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Users that earlier versions stored log in with their username or email address in any case
+// once they have been migrated
+func TestLegacyLogin(t *testing.T) {
+	m := open(t, testConfig(t))
+	ctx := context.Background()
+
+	insertLegacy(t, m, legacyPayload)
+
+	if n, err := m.Migrate(ctx); err != nil || n != 1 {
+		t.Fatalf("Migrate() = %d, %v, want 1 user", n, err)
+	}
+
+	for _, identifier := range []string{"dwight", "DWIGHT", "dwight@dunder-mifflin.com", "Dwight@Dunder-Mifflin.com"} {
+		acct, err := auth.FindAccount(ctx, m, identifier)
+		if err != nil {
+			t.Fatalf("FindAccount(%s): %s", identifier, err.Error())
+		}
+		if err := auth.CheckCredentials(acct, "beets"); err != nil {
+			t.Errorf("CheckCredentials(%s): %s", identifier, err.Error())
+		}
+	}
+
+	// The migrated user can be updated, and its username is taken
+	acct, err := m.GetUser(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Version != 1 {
+		t.Errorf("version = %d, want 1", acct.Version)
+	}
+	if err := m.UpdateUser(ctx, acct); err != nil {
+		t.Errorf("UpdateUser: %s", err.Error())
+	}
+	other := datastore.Account{}
+	other.ID, other.Username, other.Email = "u2", "dwight", "other@dunder-mifflin.com"
+	if err := m.AddUser(ctx, other); !errors.Is(err, datastore.ErrUserExists) {
+		t.Errorf("AddUser with the username of the migrated user = %v, want %v", err, datastore.ErrUserExists)
+	}
+
+	// Migrating again has nothing left to rewrite
+	if n, err := m.Migrate(ctx); err != nil || n != 0 {
+		t.Errorf("second Migrate() = %d, %v, want 0 users", n, err)
+	}
+}
+
+// Usernames that only differ in case stop the service at start and are reported by the
+// migration, which rewrites nothing until they are resolved
+func TestMigrateReportsCollisions(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SkipIndexes = true
+	m := open(t, cfg)
+	ctx := context.Background()
+
+	insertLegacy(t, m, legacyPayload, `{"id":"u2","username":"dwight","password":"secret","email":"schrute@dunder-mifflin.com"}`)
+
+	cfg.SkipIndexes = false
+	if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), "migrate") {
+		t.Errorf("New() with users of earlier versions = %v, want an error that mentions the migration", err)
+	}
+
+	n, err := m.Migrate(ctx)
+	if n != 0 || !errors.Is(err, datastore.ErrConflict) || !strings.Contains(err.Error(), "username dwight is used by users u1, u2") {
+		t.Fatalf("Migrate() = %d, %v, want 0 users and a conflict on dwight", n, err)
+	}
+
+	if _, err := m.users.DeleteOne(ctx, bson.D{{Key: "SK", Value: "u2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.Migrate(ctx); err != nil || n != 1 {
+		t.Fatalf("Migrate() after resolving = %d, %v, want 1 user", n, err)
+	}
+	open(t, cfg)
+}
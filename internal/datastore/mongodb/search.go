@@ -0,0 +1,129 @@
+package mongodb
+
+import (
+	"context"
+	"strings"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxRune is the highest character, so every name that starts with a prefix sorts before the
+// prefix followed by maxRune
+const maxRune = "\U0010FFFF"
+
+// position is the position in the search results a cursor points to. The name is
+// only used when the results are sorted by name, with the ID to break ties.
+type position struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// SearchUsers retrieves a page of users from MongoDB that match the query. Searches on
+// email address use the unique index on the email address, and searches on name and results
+// sorted by name use the index on the name. Searches on name always use that index, so they
+// never scan the whole collection.
+func (m manager) SearchUsers(ctx context.Context, q datastore.Query, limit int, cursor string) (datastore.Page, error) {
+	if err := q.Validate(); err != nil {
+		return datastore.Page{}, err
+	}
+
+	limit = datastore.PageSize(limit)
+	field, desc, _ := q.Order()
+
+	filter := bson.D{}
+
+	if len(q.Email) > 0 {
+		filter = append(filter, bson.E{Key: "Email", Value: datastore.SearchEmail(acmeserverless.User{Email: q.Email})})
+	}
+
+	// The name is matched as a prefix of the lower case name, with a range of names so the
+	// index on the name is scanned from the prefix up to the first name without it
+	if len(q.Name) > 0 {
+		prefix := strings.ToLower(q.Name)
+		filter = append(filter, bson.E{Key: "Name", Value: bson.D{{Key: "$gte", Value: prefix}, {Key: "$lt", Value: prefix + maxRune}}})
+	}
+
+	// Erased users have no KeyID, and users stored before statuses existed have no
+	// status and are active
+	switch q.Status {
+	case "":
+	case datastore.StatusErased:
+		filter = append(filter, bson.E{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: false}}})
+	case datastore.StatusActive:
+		filter = append(filter,
+			bson.E{Key: "KeyID", Value: bson.D{{Key: "$exists", Value: true}}},
+			bson.E{Key: "Status", Value: bson.D{{Key: "$in", Value: bson.A{datastore.StatusActive, nil}}}},
+		)
+	default:
+		filter = append(filter, bson.E{Key: "Status", Value: q.Status})
+	}
+
+	dir, op := 1, "$gt"
+	if desc {
+		dir, op = -1, "$lt"
+	}
+
+	sort := bson.D{{Key: "SK", Value: dir}}
+	if field == datastore.SortName {
+		sort = bson.D{{Key: "Name", Value: dir}, {Key: "SK", Value: dir}}
+	}
+
+	if len(cursor) > 0 {
+		var pos position
+		if err := datastore.DecodeSearchCursor(cursor, q, &pos); err != nil {
+			return datastore.Page{}, err
+		}
+
+		after := bson.D{{Key: "SK", Value: bson.D{{Key: op, Value: pos.ID}}}}
+		if field == datastore.SortName {
+			after = bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "Name", Value: bson.D{{Key: op, Value: pos.Name}}}},
+				bson.D{{Key: "Name", Value: pos.Name}, {Key: "SK", Value: bson.D{{Key: op, Value: pos.ID}}}},
+			}}}
+		}
+		filter = bson.D{{Key: "$and", Value: bson.A{filter, after}}}
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Ask for one more user than needed to know whether there is a next page
+	opts := options.Find().SetSort(sort).SetLimit(int64(limit + 1))
+	if len(q.Name) > 0 {
+		opts.SetHint(nameIndex)
+	}
+	cur, err := m.users.Find(ctx, filter, opts)
+	if err != nil {
+		return datastore.Page{}, storeError(err)
+	}
+
+	users, err := decodeUsers(ctx, cur)
+	if err != nil {
+		return datastore.Page{}, err
+	}
+
+	page := datastore.Page{
+		Users: users,
+	}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		last := page.Users[limit-1]
+
+		pos := position{ID: last.ID}
+		if field == datastore.SortName {
+			pos.Name = datastore.SearchName(last.User)
+		}
+
+		next, err := datastore.EncodeSearchCursor(q, pos)
+		if err != nil {
+			return datastore.Page{}, err
+		}
+		page.Next = next
+	}
+
+	return page, nil
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/lib/pq"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// The error codes of PostgreSQL that the data store handles itself
+const (
+	uniqueViolation     = pq.ErrorCode("23505")
+	foreignKeyViolation = pq.ErrorCode("23503")
+)
+
+// storeError translates the errors of PostgreSQL that mean the database can't be reached, is
+// overloaded or didn't answer in time into datastore.ErrUnavailable. Other errors are returned
+// as they are. PostgreSQL reports a query that was cancelled as an error of its own, so the
+// context of the call tells whether the deadline has passed or the request was cancelled.
+func storeError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return datastore.Unavailable(err)
+	case context.Canceled:
+		return ctx.Err()
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || err == driver.ErrBadConn {
+		return datastore.Unavailable(err)
+	}
+
+	if pqerr, ok := err.(*pq.Error); ok {
+		switch pqerr.Code.Class() {
+		// connection_exception, insufficient_resources and operator_intervention, which
+		// includes a server that is shutting down or starting up
+		case "08", "53", "57":
+			return datastore.Unavailable(err)
+		}
+		return err
+	}
+
+	if _, ok := err.(net.Error); ok {
+		return datastore.Unavailable(err)
+	}
+
+	return err
+}
+
+// isCode returns true if err is an error of PostgreSQL with the code
+func isCode(err error, code pq.ErrorCode) bool {
+	pqerr, ok := err.(*pq.Error)
+	return ok && pqerr.Code == code
+}
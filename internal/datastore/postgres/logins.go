@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// AddLogin adds a login attempt to the login history of a user in PostgreSQL. Each attempt is a
+// row in the logins table. PostgreSQL doesn't remove rows by itself, so the attempts of the
+// user that are older than datastore.LoginRetention are removed at the same time. A successful
+// attempt is also stored in the last_login_at column of the user, which keeps its version.
+func (m manager) AddLogin(ctx context.Context, userID string, attempt user.LoginAttempt) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := m.db.ExecContext(ctx, `INSERT INTO logins (user_id, at, expires_at, attempted_at, ip, user_agent, success, method, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		userID, now, now.Add(datastore.LoginRetention), attempt.At, attempt.IP, attempt.UserAgent, attempt.Success, attempt.Method, attempt.Reason)
+	if isCode(err, foreignKeyViolation) {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	_, err = m.db.ExecContext(ctx, `DELETE FROM logins WHERE user_id = $1 AND expires_at <= $2`, userID, now)
+	if err != nil || !attempt.Success {
+		return storeError(ctx, err)
+	}
+
+	// Erased users have no username and don't get a last login
+	_, err = m.db.ExecContext(ctx, `UPDATE users SET last_login_at = GREATEST(last_login_at, $1) WHERE id = $2 AND username IS NOT NULL`, attempt.At, userID)
+	return storeError(ctx, err)
+}
+
+// Logins retrieves the login history of a user from PostgreSQL, newest first
+func (m manager) Logins(ctx context.Context, userID string, limit int) ([]user.LoginAttempt, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// A limit of NULL returns all rows
+	max := sql.NullInt64{Int64: int64(limit), Valid: limit > 0}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT attempted_at, ip, user_agent, success, method, reason FROM logins
+		WHERE user_id = $1 AND expires_at > $2 ORDER BY at DESC, id DESC LIMIT $3`, userID, time.Now(), max)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	history := make([]user.LoginAttempt, 0)
+
+	for rows.Next() {
+		var a user.LoginAttempt
+		if err := rows.Scan(&a.At, &a.IP, &a.UserAgent, &a.Success, &a.Method, &a.Reason); err != nil {
+			return nil, storeError(ctx, err)
+		}
+		history = append(history, a)
+	}
+
+	return history, storeError(ctx, rows.Err())
+}
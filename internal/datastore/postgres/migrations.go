@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration is a change of the schema. Migrations are applied in order of their version, and
+// each migration is applied only once. Once a migration has been released it must not change;
+// changes to the schema are added as a new migration with the next version.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations are the changes of the schema, oldest first
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create users, addresses and logins",
+		statements: []string{
+			// Usernames and email addresses are stored normalized, so the unique indexes make
+			// sure they only belong to one user regardless of case. Erased users have no
+			// username, email address or status, and NULLs are not part of a unique index. A
+			// login sets the last login without changing the payload or the version, so it
+			// doesn't conflict with updates of the user.
+			`CREATE TABLE users (
+				id            TEXT PRIMARY KEY,
+				username      TEXT,
+				email         TEXT,
+				name          TEXT NOT NULL DEFAULT '',
+				status        TEXT,
+				version       BIGINT NOT NULL,
+				payload       JSONB NOT NULL,
+				created_at    TIMESTAMPTZ NOT NULL,
+				updated_at    TIMESTAMPTZ NOT NULL,
+				last_login_at BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE UNIQUE INDEX users_username_key ON users (username)`,
+			`CREATE UNIQUE INDEX users_email_key ON users (email)`,
+			`CREATE INDEX users_name_idx ON users (name, id)`,
+			`CREATE INDEX users_status_idx ON users (status)`,
+			`CREATE TABLE addresses (
+				user_id          TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				id               TEXT NOT NULL,
+				name             TEXT NOT NULL,
+				line1            TEXT NOT NULL,
+				line2            TEXT NOT NULL DEFAULT '',
+				city             TEXT NOT NULL,
+				region           TEXT NOT NULL DEFAULT '',
+				postal_code      TEXT NOT NULL DEFAULT '',
+				country          TEXT NOT NULL,
+				default_shipping BOOLEAN NOT NULL DEFAULT FALSE,
+				default_billing  BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (user_id, id)
+			)`,
+			// PostgreSQL has no TTL, so attempts that have expired are removed when the next
+			// attempt of the user is added and are skipped when the history is read
+			`CREATE TABLE logins (
+				id           BIGSERIAL PRIMARY KEY,
+				user_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				at           TIMESTAMPTZ NOT NULL,
+				expires_at   TIMESTAMPTZ NOT NULL,
+				attempted_at BIGINT NOT NULL,
+				ip           TEXT NOT NULL DEFAULT '',
+				user_agent   TEXT NOT NULL DEFAULT '',
+				success      BOOLEAN NOT NULL,
+				method       TEXT NOT NULL DEFAULT '',
+				reason       TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX logins_user_at_idx ON logins (user_id, at DESC, id DESC)`,
+		},
+	},
+}
+
+// migrationLock is the key of the advisory lock that is held while the schema is migrated, so
+// services that start at the same time don't apply the same migration twice
+const migrationLock = 7466723
+
+// migrate applies the migrations that haven't been applied yet. All migrations are applied in a
+// single transaction, so the schema is either fully migrated or not changed at all.
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		for _, stmt := range m.statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("error applying migration %d (%s): %s", m.version, m.description, err.Error())
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, description) VALUES ($1, $2)`, m.version, m.description); err != nil {
+			return err
+		}
+
+		log.Printf("applied migration %d: %s", m.version, m.description)
+	}
+
+	return tx.Commit()
+}
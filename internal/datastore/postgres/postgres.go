@@ -0,0 +1,497 @@
+// Package postgres leverages PostgreSQL, an open source relational database, to store data. Users,
+// their addresses and their login history are kept in separate tables, and the schema is created
+// and upgraded with the migrations that are part of the package.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// manager keeps the pool of connections to PostgreSQL, which is reused for all requests if the
+// container stays warm.
+type manager struct {
+	db *sql.DB
+}
+
+// Config is the configuration of the pool of connections to PostgreSQL
+type Config struct {
+	// URL is the connection string of the database
+	URL string
+
+	// MaxOpenConns is the maximum number of open connections to the database
+	MaxOpenConns int
+
+	// MaxIdleConns is the maximum number of idle connections in the pool
+	MaxIdleConns int
+
+	// ConnMaxLifetime is the maximum time a connection is reused
+	ConnMaxLifetime time.Duration
+}
+
+// ConfigFromEnv returns the configuration in the environment. The connection string is taken
+// from POSTGRES_URL, or built from POSTGRES_HOST, POSTGRES_PORT, POSTGRES_USER,
+// POSTGRES_PASSWORD, POSTGRES_DB and POSTGRES_SSLMODE when it isn't set. The size of the pool
+// is set with POSTGRES_MAX_OPEN_CONNS, POSTGRES_MAX_IDLE_CONNS and POSTGRES_CONN_MAX_LIFETIME.
+func ConfigFromEnv() Config {
+	return Config{
+		URL:             connString(),
+		MaxOpenConns:    envInt("POSTGRES_MAX_OPEN_CONNS", 10),
+		MaxIdleConns:    envInt("POSTGRES_MAX_IDLE_CONNS", 5),
+		ConnMaxLifetime: envDuration("POSTGRES_CONN_MAX_LIFETIME", 30*time.Minute),
+	}
+}
+
+func init() {
+	datastore.Register("postgres", func() (datastore.Manager, error) {
+		return New(ConfigFromEnv())
+	})
+}
+
+// connString returns the connection string of the database
+func connString() string {
+	if u := os.Getenv("POSTGRES_URL"); len(u) > 0 {
+		return u
+	}
+
+	port := os.Getenv("POSTGRES_PORT")
+	if len(port) == 0 {
+		port = "5432"
+	}
+
+	database := os.Getenv("POSTGRES_DB")
+	if len(database) == 0 {
+		database = "acmeserverless"
+	}
+
+	sslmode := os.Getenv("POSTGRES_SSLMODE")
+	if len(sslmode) == 0 {
+		sslmode = "require"
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD")),
+		Host:     net.JoinHostPort(os.Getenv("POSTGRES_HOST"), port),
+		Path:     "/" + database,
+		RawQuery: url.Values{"sslmode": {sslmode}}.Encode(),
+	}
+
+	return u.String()
+}
+
+// envInt returns the number in the environment variable, or def when it isn't set or isn't a
+// valid, positive number
+func envInt(name string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// envDuration returns the duration in the environment variable, or def when it isn't set or
+// isn't a valid, positive duration
+func envDuration(name string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// New creates a new datastore manager using PostgreSQL as backend. It creates the pool of
+// connections and migrates the schema.
+func New(cfg Config) (datastore.Manager, error) {
+	pool, err := sql.Open("postgres", cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to PostgreSQL: %s", err.Error())
+	}
+
+	pool.SetMaxOpenConns(cfg.MaxOpenConns)
+	pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	pool.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), datastore.Timeout())
+	defer cancel()
+
+	if err := migrate(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error migrating the PostgreSQL schema: %s", err.Error())
+	}
+
+	return manager{db: pool}, nil
+}
+
+// Close closes the pool of connections to PostgreSQL
+func (m manager) Close() error {
+	return m.db.Close()
+}
+
+// GetUser retrieves a single user from PostgreSQL based on the userID
+func (m manager) GetUser(ctx context.Context, userID string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	return m.queryUser(ctx, `SELECT payload, last_login_at FROM users WHERE id = $1`, userID, "id")
+}
+
+// FindUser retrieves a single user from PostgreSQL based on the username, using the unique
+// index on the normalized username
+func (m manager) FindUser(ctx context.Context, username string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	return m.queryUser(ctx, `SELECT payload, last_login_at FROM users WHERE username = $1`, datastore.NormalizeUsername(username), "name")
+}
+
+// FindUserByEmail retrieves a single user from PostgreSQL based on the email address, using
+// the unique index on the normalized email address. Erased users have no email address.
+func (m manager) FindUserByEmail(ctx context.Context, email string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	return m.queryUser(ctx, `SELECT payload, last_login_at FROM users WHERE email = $1`, datastore.NormalizeEmail(email), "email address")
+}
+
+// queryUser runs a query for a single user. The key is the kind of value the user is looked up
+// by, for the error when no user is found.
+func (m manager) queryUser(ctx context.Context, query string, value string, key string) (datastore.Account, error) {
+	var payload string
+	var lastLoginAt int64
+
+	err := m.db.QueryRowContext(ctx, query, value).Scan(&payload, &lastLoginAt)
+	if err == sql.ErrNoRows {
+		return datastore.Account{}, datastore.NotFound("no user found with %s %s", key, value)
+	}
+	if err != nil {
+		return datastore.Account{}, storeError(ctx, err)
+	}
+
+	return datastore.UnmarshalStoredAccount(payload, lastLoginAt)
+}
+
+// AllUsers retrieves all users from PostgreSQL, ordered by their ID
+func (m manager) AllUsers(ctx context.Context) ([]datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Erased users have no username and are skipped
+	rows, err := m.db.QueryContext(ctx, `SELECT payload, last_login_at FROM users WHERE username IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	users, err := scanUsers(rows)
+	return users, storeError(ctx, err)
+}
+
+// ListUsers retrieves a page of users from PostgreSQL, ordered by their ID. The cursor for
+// the next page contains the ID of the last user in the page.
+func (m manager) ListUsers(ctx context.Context, limit int, cursor string) (datastore.Page, error) {
+	limit = datastore.PageSize(limit)
+
+	var position string
+	if len(cursor) > 0 {
+		if err := datastore.DecodeCursor(cursor, &position); err != nil {
+			return datastore.Page{}, err
+		}
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Ask for one more user than needed to know whether there is a next page
+	rows, err := m.db.QueryContext(ctx, `SELECT payload, last_login_at FROM users WHERE username IS NOT NULL AND id > $1 ORDER BY id LIMIT $2`, position, limit+1)
+	if err != nil {
+		return datastore.Page{}, storeError(ctx, err)
+	}
+
+	users, err := scanUsers(rows)
+	if err != nil {
+		return datastore.Page{}, storeError(ctx, err)
+	}
+
+	page := datastore.Page{
+		Users: users,
+	}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		next, err := datastore.EncodeCursor(page.Users[limit-1].ID)
+		if err != nil {
+			return datastore.Page{}, err
+		}
+		page.Next = next
+	}
+
+	return page, nil
+}
+
+// scanUsers reads the payloads and last logins of the users from the rows one row at a time
+func scanUsers(rows *sql.Rows) ([]datastore.Account, error) {
+	defer rows.Close()
+
+	users := make([]datastore.Account, 0)
+
+	for rows.Next() {
+		var payload string
+		var lastLoginAt int64
+		if err := rows.Scan(&payload, &lastLoginAt); err != nil {
+			return nil, err
+		}
+
+		usr, err := datastore.UnmarshalStoredAccount(payload, lastLoginAt)
+		if err != nil {
+			log.Println(fmt.Sprintf("error unmarshalling user data: %s", err.Error()))
+			continue
+		}
+		users = append(users, usr)
+	}
+
+	return users, rows.Err()
+}
+
+// insertUser is the statement that stores a new user. Users that conflict with a stored user,
+// on the ID, username or email address, are not stored.
+const insertUser = `INSERT INTO users (id, username, email, name, status, version, payload, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT DO NOTHING`
+
+// AddUser stores a new user in PostgreSQL. The unique indexes on the username and email
+// address make sure each of them can only belong to one user.
+func (m manager) AddUser(ctx context.Context, usr datastore.Account) error {
+	args, err := userArgs(usr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, insertUser, args...)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	return inserted(res)
+}
+
+// AddUsers stores new users in PostgreSQL in a single transaction. Users that conflict with a
+// stored user or with a user earlier in the batch are skipped, and don't stop the other users
+// of the batch from being stored.
+func (m manager) AddUsers(ctx context.Context, usrs []datastore.Account) ([]error, error) {
+	errs := make([]error, len(usrs))
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertUser)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer stmt.Close()
+
+	for idx, usr := range usrs {
+		args, err := userArgs(usr)
+		if err != nil {
+			errs[idx] = err
+			continue
+		}
+
+		res, err := stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return nil, storeError(ctx, err)
+		}
+		errs[idx] = inserted(res)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	return errs, nil
+}
+
+// userArgs returns the values of the columns of a new user, in the order of insertUser. New
+// users start at version 1.
+func userArgs(usr datastore.Account) ([]interface{}, error) {
+	now := time.Now()
+	usr.Version = 1
+	usr.CreatedAt = now.Unix()
+	usr.UpdatedAt = usr.CreatedAt
+
+	payload, err := usr.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{
+		usr.ID,
+		datastore.NormalizeUsername(usr.Username),
+		nullString(datastore.SearchEmail(usr.User)),
+		datastore.SearchName(usr.User),
+		usr.State(),
+		usr.Version,
+		string(payload),
+		now,
+		now,
+	}, nil
+}
+
+// inserted returns ErrUserExists when insertUser didn't store the user
+func inserted(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return datastore.ErrUserExists
+	}
+	return nil
+}
+
+// nullString returns NULL for an empty string, so users without an email address are not part
+// of the unique index on email addresses
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: len(s) > 0}
+}
+
+// UpdateUser replaces the payload and the status of a user in PostgreSQL, together with the email
+// address and name it is found by. Erased users have no username and can't be updated. Only the
+// row with the version the user was read at is updated, so concurrent updates of the same user
+// can't overwrite each other. An email address that belongs to another user returns
+// datastore.ErrUserExists.
+func (m manager) UpdateUser(ctx context.Context, usr datastore.Account) error {
+	now := time.Now()
+	next := usr
+	next.Version = usr.Version + 1
+	next.UpdatedAt = now.Unix()
+
+	payload, err := next.Marshal()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, `UPDATE users SET payload = $1, email = $2, name = $3, status = $4, version = $5, updated_at = $6
+		WHERE id = $7 AND username IS NOT NULL AND version = $8`,
+		string(payload), nullString(datastore.SearchEmail(usr.User)), datastore.SearchName(usr.User), usr.State(), next.Version, now, usr.ID, usr.Version)
+	if isCode(err, uniqueViolation) {
+		return datastore.ErrUserExists
+	}
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	if n == 0 {
+		// No row matches both when the user doesn't exist and when it has another version
+		var exists bool
+		err := m.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND username IS NOT NULL)`, usr.ID).Scan(&exists)
+		if err != nil {
+			return storeError(ctx, err)
+		}
+		if exists {
+			return datastore.ErrVersionConflict
+		}
+		return datastore.NotFound("no user found with id %s", usr.ID)
+	}
+
+	return nil
+}
+
+// DeleteUser removes a user from PostgreSQL, together with the address book and login history
+// of the user. If anonymize is true, the row is kept but the personal data is erased from the
+// payload and the username, email address, name and last login are removed so the user can no
+// longer be found and the username and email address can be used by new users.
+func (m manager) DeleteUser(ctx context.Context, userID string, anonymize bool) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	if !anonymize {
+		// The address book and login history are removed by the foreign keys
+		res, err := m.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+		if err != nil {
+			return storeError(ctx, err)
+		}
+		return deleted(res, userID)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var payload string
+	err = tx.QueryRowContext(ctx, `SELECT payload FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&payload)
+	if err == sql.ErrNoRows {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	usr, err := datastore.UnmarshalAccount(payload)
+	if err != nil {
+		return err
+	}
+
+	erased := usr.Erase(time.Now())
+	erased.Version = usr.Version + 1
+	data, err := erased.Marshal()
+	if err != nil {
+		return err
+	}
+
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE users SET payload = $1, username = NULL, email = NULL, name = '', status = NULL, last_login_at = 0, version = $2, updated_at = now() WHERE id = $3`, []interface{}{string(data), erased.Version, userID}},
+		{`DELETE FROM addresses WHERE user_id = $1`, []interface{}{userID}},
+		{`DELETE FROM logins WHERE user_id = $1`, []interface{}{userID}},
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return storeError(ctx, err)
+		}
+	}
+
+	return storeError(ctx, tx.Commit())
+}
+
+// deleted returns an error when a statement didn't remove the user
+func deleted(res sql.Result, userID string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+	return nil
+}
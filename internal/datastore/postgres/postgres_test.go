@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/datastore/datastoretest"
+)
+
+// The conformance tests need a database they can empty, so they only run when its
+// connection string is set in POSTGRES_TEST_URL
+func TestConformance(t *testing.T) {
+	url := os.Getenv("POSTGRES_TEST_URL")
+	if len(url) == 0 {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+
+	datastoretest.Run(t, func(t *testing.T) datastore.Manager {
+		m, err := New(Config{URL: url, MaxOpenConns: 5, MaxIdleConns: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := m.(manager).db.ExecContext(context.Background(), `TRUNCATE logins, addresses, users`); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	})
+}
@@ -0,0 +1,76 @@
+package datastore
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory creates the manager of a datastore from its configuration in the environment. A
+// factory is only called when its datastore is selected, so datastores connect to their
+// database in the factory and not when their package is imported.
+type Factory func() (Manager, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a datastore available by name. Datastores call Register from the init function
+// of their package, so a program can use every datastore whose package it imports. Register
+// panics if it is called twice with the same name or if factory is nil.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("datastore: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("datastore: Register called twice for datastore " + name)
+	}
+	factories[name] = factory
+}
+
+// Backends returns the names of the registered datastores, sorted
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Open returns the manager of the registered datastore with the name, or the error of the
+// datastore when it can't be opened
+func Open(name string) (Manager, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown datastore %q, available datastores are %s", name, strings.Join(Backends(), ", "))
+	}
+
+	return factory()
+}
+
+// FromEnv returns the manager of the datastore named in the environment variable DATASTORE, or
+// of the datastore def when the variable isn't set
+func FromEnv(def string) (Manager, error) {
+	name := os.Getenv("DATASTORE")
+	if len(name) == 0 {
+		name = def
+	}
+
+	log.Printf("using datastore %s", name)
+	return Open(name)
+}
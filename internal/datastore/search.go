@@ -0,0 +1,117 @@
+package datastore
+
+import (
+	"context"
+	"strings"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+)
+
+const (
+	// SortID orders users by their ID, this is the default order
+	SortID = "id"
+
+	// SortName orders users by their last name and first name
+	SortName = "name"
+)
+
+// ErrInvalidQuery is returned when a query has a status or sort order that is not supported
+var ErrInvalidQuery = Invalid("invalid query")
+
+// Searcher is implemented by data stores that can search for users, on top of the
+// methods of the Manager interface.
+type Searcher interface {
+	// SearchUsers retrieves a page of at most limit users that match the query,
+	// starting after the position the cursor points to.
+	SearchUsers(ctx context.Context, q Query, limit int, cursor string) (Page, error)
+}
+
+// Query describes the users to search for. Empty fields match all users.
+type Query struct {
+	// Email matches users with this email address, regardless of case
+	Email string
+
+	// Name matches users whose name, in the form "lastname firstname", starts with this
+	// prefix, regardless of case
+	Name string
+
+	// Status matches users with this status
+	Status string
+
+	// Sort is the order of the users, either SortID or SortName. A "-" in front of the
+	// order sorts descending.
+	Sort string
+}
+
+// Empty returns true if the query matches all users in the default order
+func (q Query) Empty() bool {
+	return q == Query{}
+}
+
+// Validate returns ErrInvalidQuery if the query has a status or sort order that is not supported
+func (q Query) Validate() error {
+	if _, _, err := q.Order(); err != nil {
+		return err
+	}
+
+	if len(q.Status) > 0 && q.Status != StatusErased && !ValidStatus(q.Status) {
+		return ErrInvalidQuery
+	}
+	return nil
+}
+
+// Order returns the field to sort on and whether the order is descending
+func (q Query) Order() (string, bool, error) {
+	field := strings.TrimPrefix(q.Sort, "-")
+	desc := strings.HasPrefix(q.Sort, "-")
+
+	switch field {
+	case "":
+		return SortID, desc, nil
+	case SortID, SortName:
+		return field, desc, nil
+	default:
+		return "", false, ErrInvalidQuery
+	}
+}
+
+// normalized returns the query in the form the data stores match it in, so queries that find
+// the same users in the same order are equal
+func (q Query) normalized() Query {
+	field, desc, _ := q.Order()
+	if desc {
+		field = "-" + field
+	}
+
+	return Query{
+		Email:  SearchEmail(acmeserverless.User{Email: q.Email}),
+		Name:   strings.ToLower(q.Name),
+		Status: q.Status,
+		Sort:   field,
+	}
+}
+
+// SearchName returns the name a user can be found by, which is the last name and first
+// name in lower case. Data stores keep it next to the user so it can be indexed.
+func SearchName(usr acmeserverless.User) string {
+	return strings.ToLower(strings.TrimSpace(usr.Lastname + " " + usr.Firstname))
+}
+
+// SearchEmail returns the email address a user can be found by, which is the email address in
+// lower case. Data stores keep it next to the user so it can be indexed.
+func SearchEmail(usr acmeserverless.User) string {
+	return NormalizeEmail(usr.Email)
+}
+
+// NormalizeUsername returns the username in the form data stores keep and compare it in,
+// so usernames that only differ in case, like Peter and peter, belong to the same user.
+// The user keeps the username as it was registered.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// NormalizeEmail returns the email address in the form data stores keep and compare it in,
+// so Peter@Acme.com and peter@acme.com belong to the same user
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
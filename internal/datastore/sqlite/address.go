@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// Addresses retrieves the address book of a user from SQLite. Each address is a row in the
+// addresses table.
+func (m manager) Addresses(ctx context.Context, userID string) ([]user.Address, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, `SELECT id, name, line1, line2, city, region, postal_code, country, default_shipping, default_billing
+		FROM addresses WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	book := make([]user.Address, 0)
+
+	for rows.Next() {
+		var a user.Address
+		err := rows.Scan(&a.ID, &a.Name, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country, &a.DefaultShipping, &a.DefaultBilling)
+		if err != nil {
+			return nil, storeError(ctx, err)
+		}
+		book = append(book, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	// The ID is text, so 10 comes before 2
+	datastore.SortAddresses(book)
+
+	return book, nil
+}
+
+// SaveAddresses adds, replaces and removes the addresses of a user in a single transaction. The
+// transaction holds the write lock of the database, and fails when the user doesn't exist or
+// has been erased.
+func (m manager) SaveAddresses(ctx context.Context, userID string, added []user.Address, changed []user.Address, removed []string) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = ? AND username IS NOT NULL`, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	// Other changes of the address book wait for the lock, so the address book can't change
+	// between this check and the commit
+	if err := checkAddresses(ctx, tx, userID, added, changed); err != nil {
+		return err
+	}
+
+	for _, a := range append(append([]user.Address{}, added...), changed...) {
+		_, err := tx.ExecContext(ctx, `INSERT INTO addresses (user_id, id, name, line1, line2, city, region, postal_code, country, default_shipping, default_billing)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (user_id, id) DO UPDATE SET name = excluded.name, line1 = excluded.line1, line2 = excluded.line2,
+				city = excluded.city, region = excluded.region, postal_code = excluded.postal_code, country = excluded.country,
+				default_shipping = excluded.default_shipping, default_billing = excluded.default_billing`,
+			userID, a.ID, a.Name, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country, a.DefaultShipping, a.DefaultBilling)
+		if err != nil {
+			return storeError(ctx, err)
+		}
+	}
+
+	for _, addressID := range removed {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM addresses WHERE user_id = ? AND id = ?`, userID, addressID); err != nil {
+			return storeError(ctx, err)
+		}
+	}
+
+	return storeError(ctx, tx.Commit())
+}
+
+// checkAddresses returns datastore.ErrAddressBookChanged when one of the added addresses is
+// already in the address book of the user, or one of the changed addresses isn't
+func checkAddresses(ctx context.Context, tx *sql.Tx, userID string, added []user.Address, changed []user.Address) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM addresses WHERE user_id = ?`, userID)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	book := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return storeError(ctx, err)
+		}
+		book[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return storeError(ctx, err)
+	}
+
+	for _, a := range added {
+		if book[a.ID] {
+			return datastore.ErrAddressBookChanged
+		}
+	}
+	for _, a := range changed {
+		if !book[a.ID] {
+			return datastore.ErrAddressBookChanged
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// storeError translates the errors of SQLite that mean the database is locked by another
+// connection for longer than the busy timeout, or can't be read or written, into
+// datastore.ErrUnavailable. Other errors are returned as they are. SQLite reports a statement
+// that was interrupted as an error of its own, so the context of the call tells whether the
+// deadline has passed or the request was cancelled.
+func storeError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return datastore.Unavailable(err)
+	case context.Canceled:
+		return ctx.Err()
+	}
+
+	if code, ok := errorCode(err); ok {
+		// The primary result code is in the lowest byte of an extended result code
+		switch code & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED, sqlite3.SQLITE_IOERR, sqlite3.SQLITE_FULL, sqlite3.SQLITE_CANTOPEN:
+			return datastore.Unavailable(err)
+		}
+	}
+
+	return err
+}
+
+// errorCode returns the extended result code of an error of SQLite
+func errorCode(err error) (int, bool) {
+	lerr, ok := err.(*sqlite.Error)
+	if !ok {
+		return 0, false
+	}
+	return lerr.Code(), true
+}
+
+// isCode returns true if err is an error of SQLite with the extended result code
+func isCode(err error, code int) bool {
+	c, ok := errorCode(err)
+	return ok && c == code
+}
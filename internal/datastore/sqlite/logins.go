@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// AddLogin adds a login attempt to the login history of a user in SQLite. Each attempt is a row
+// in the logins table. SQLite doesn't remove rows by itself, so the attempts of the user that
+// are older than datastore.LoginRetention are removed at the same time. A successful attempt is
+// also stored in the last_login_at column of the user, which keeps its version.
+func (m manager) AddLogin(ctx context.Context, userID string, attempt user.LoginAttempt) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := m.db.ExecContext(ctx, `INSERT INTO logins (user_id, at, expires_at, attempted_at, ip, user_agent, success, method, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, now.UnixNano(), now.Add(datastore.LoginRetention).UnixNano(), attempt.At, attempt.IP, attempt.UserAgent, attempt.Success, attempt.Method, attempt.Reason)
+	if isCode(err, sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY) {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	_, err = m.db.ExecContext(ctx, `DELETE FROM logins WHERE user_id = ? AND expires_at <= ?`, userID, now.UnixNano())
+	if err != nil || !attempt.Success {
+		return storeError(ctx, err)
+	}
+
+	// Erased users have no username and don't get a last login
+	_, err = m.db.ExecContext(ctx, `UPDATE users SET last_login_at = MAX(last_login_at, ?) WHERE id = ? AND username IS NOT NULL`, attempt.At, userID)
+	return storeError(ctx, err)
+}
+
+// Logins retrieves the login history of a user from SQLite, newest first
+func (m manager) Logins(ctx context.Context, userID string, limit int) ([]user.LoginAttempt, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// A negative limit returns all rows
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT attempted_at, ip, user_agent, success, method, reason FROM logins
+		WHERE user_id = ? AND expires_at > ? ORDER BY at DESC, id DESC LIMIT ?`, userID, time.Now().UnixNano(), limit)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	history := make([]user.LoginAttempt, 0)
+
+	for rows.Next() {
+		var a user.LoginAttempt
+		if err := rows.Scan(&a.At, &a.IP, &a.UserAgent, &a.Success, &a.Method, &a.Reason); err != nil {
+			return nil, storeError(ctx, err)
+		}
+		history = append(history, a)
+	}
+
+	return history, storeError(ctx, rows.Err())
+}
@@ -0,0 +1,127 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// position is the position in the search results a cursor points to. The name is
+// only used when the results are sorted by name, with the ID to break ties.
+type position struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// SearchUsers retrieves a page of users from SQLite that match the query. Searches on email
+// address use the unique index on the email address, searches on name and results sorted by
+// name use the index on the name.
+func (m manager) SearchUsers(ctx context.Context, q datastore.Query, limit int, cursor string) (datastore.Page, error) {
+	if err := q.Validate(); err != nil {
+		return datastore.Page{}, err
+	}
+
+	limit = datastore.PageSize(limit)
+	field, desc, _ := q.Order()
+
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	// arg adds an argument to the query and returns its placeholder
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "?"
+	}
+
+	if len(q.Email) > 0 {
+		where = append(where, "email = "+arg(datastore.SearchEmail(acmeserverless.User{Email: q.Email})))
+	}
+
+	// The name is matched as a prefix. LIKE wildcards in the name are escaped, so they match
+	// themselves. SQLite has no default escape character, so it is set explicitly.
+	if len(q.Name) > 0 {
+		replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+		where = append(where, "name LIKE "+arg(replacer.Replace(strings.ToLower(q.Name))+"%")+` ESCAPE '\'`)
+	}
+
+	// Erased users have no username, and users stored before statuses existed have no
+	// status and are active
+	switch q.Status {
+	case "":
+	case datastore.StatusErased:
+		where = append(where, "username IS NULL")
+	case datastore.StatusActive:
+		where = append(where, "username IS NOT NULL AND (status = "+arg(datastore.StatusActive)+" OR status IS NULL)")
+	default:
+		where = append(where, "status = "+arg(q.Status))
+	}
+
+	dir, op := "ASC", ">"
+	if desc {
+		dir, op = "DESC", "<"
+	}
+
+	order := fmt.Sprintf("id %s", dir)
+	if field == datastore.SortName {
+		order = fmt.Sprintf("name %s, id %s", dir, dir)
+	}
+
+	if len(cursor) > 0 {
+		var pos position
+		if err := datastore.DecodeSearchCursor(cursor, q, &pos); err != nil {
+			return datastore.Page{}, err
+		}
+
+		if field == datastore.SortName {
+			where = append(where, fmt.Sprintf("(name, id) %s (%s, %s)", op, arg(pos.Name), arg(pos.ID)))
+		} else {
+			where = append(where, fmt.Sprintf("id %s %s", op, arg(pos.ID)))
+		}
+	}
+
+	query := "SELECT payload, last_login_at FROM users"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	// Ask for one more user than needed to know whether there is a next page
+	query += fmt.Sprintf(" ORDER BY %s LIMIT %s", order, arg(limit+1))
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return datastore.Page{}, storeError(ctx, err)
+	}
+
+	users, err := scanUsers(rows)
+	if err != nil {
+		return datastore.Page{}, storeError(ctx, err)
+	}
+
+	page := datastore.Page{
+		Users: users,
+	}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		last := page.Users[limit-1]
+
+		pos := position{ID: last.ID}
+		if field == datastore.SortName {
+			pos.Name = datastore.SearchName(last.User)
+		}
+
+		next, err := datastore.EncodeSearchCursor(q, pos)
+		if err != nil {
+			return datastore.Page{}, err
+		}
+		page.Next = next
+	}
+
+	return page, nil
+}
@@ -0,0 +1,454 @@
+// Package sqlite leverages SQLite, an embedded relational database, to store data in a single file,
+// so the User service can run as a single binary without an external database. The driver is
+// written in Go and doesn't need cgo. The database uses write-ahead logging, so users can be read
+// while another request changes them, and the schema is created and upgraded with the migrations
+// that are part of the package.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// manager keeps the connections to the database file, which are reused for all requests if the
+// process stays alive.
+type manager struct {
+	db *sql.DB
+}
+
+// Config is the configuration of the SQLite database
+type Config struct {
+	// Path is the path of the database file. The file is created when it doesn't exist.
+	Path string
+}
+
+// ConfigFromEnv returns the configuration in the environment variable SQLITE_PATH, with the
+// database file acmeserverless.db in the working directory when it isn't set
+func ConfigFromEnv() Config {
+	path := os.Getenv("SQLITE_PATH")
+	if len(path) == 0 {
+		path = "acmeserverless.db"
+	}
+
+	return Config{
+		Path: path,
+	}
+}
+
+func init() {
+	datastore.Register("sqlite", func() (datastore.Manager, error) {
+		return New(ConfigFromEnv())
+	})
+}
+
+// dsn returns the data source name of the database file. Every connection uses write-ahead
+// logging and enforces foreign keys, waits for other connections that are writing instead of
+// failing right away, and starts its transactions with the write lock so two transactions can't
+// both read a user and then fail to change it.
+func dsn(path string) string {
+	params := url.Values{
+		"_pragma": {
+			"journal_mode(WAL)",
+			"synchronous(NORMAL)",
+			"foreign_keys(1)",
+			fmt.Sprintf("busy_timeout(%d)", busyTimeout/time.Millisecond),
+		},
+		"_txlock": {"immediate"},
+	}
+
+	return path + "?" + params.Encode()
+}
+
+// busyTimeout is how long a connection waits for the lock held by another connection
+const busyTimeout = 5 * time.Second
+
+// New creates a new datastore manager using SQLite as backend. It opens the database file and
+// migrates the schema.
+func New(cfg Config) (datastore.Manager, error) {
+	pool, err := sql.Open("sqlite", dsn(cfg.Path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening SQLite database %s: %s", cfg.Path, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), datastore.Timeout())
+	defer cancel()
+
+	if err := migrate(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error migrating the SQLite schema: %s", err.Error())
+	}
+
+	return manager{db: pool}, nil
+}
+
+// Close closes the connections to the database file
+func (m manager) Close() error {
+	return m.db.Close()
+}
+
+// GetUser retrieves a single user from SQLite based on the userID
+func (m manager) GetUser(ctx context.Context, userID string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	return m.queryUser(ctx, `SELECT payload, last_login_at FROM users WHERE id = ?`, userID, "id")
+}
+
+// FindUser retrieves a single user from SQLite based on the username, using the unique index
+// on the normalized username
+func (m manager) FindUser(ctx context.Context, username string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	return m.queryUser(ctx, `SELECT payload, last_login_at FROM users WHERE username = ?`, datastore.NormalizeUsername(username), "name")
+}
+
+// FindUserByEmail retrieves a single user from SQLite based on the email address, using the
+// unique index on the normalized email address. Erased users have no email address.
+func (m manager) FindUserByEmail(ctx context.Context, email string) (datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	return m.queryUser(ctx, `SELECT payload, last_login_at FROM users WHERE email = ?`, datastore.NormalizeEmail(email), "email address")
+}
+
+// queryUser runs a query for a single user. The key is the kind of value the user is looked up
+// by, for the error when no user is found.
+func (m manager) queryUser(ctx context.Context, query string, value string, key string) (datastore.Account, error) {
+	var payload string
+	var lastLoginAt int64
+
+	err := m.db.QueryRowContext(ctx, query, value).Scan(&payload, &lastLoginAt)
+	if err == sql.ErrNoRows {
+		return datastore.Account{}, datastore.NotFound("no user found with %s %s", key, value)
+	}
+	if err != nil {
+		return datastore.Account{}, storeError(ctx, err)
+	}
+
+	return datastore.UnmarshalStoredAccount(payload, lastLoginAt)
+}
+
+// AllUsers retrieves all users from SQLite, ordered by their ID
+func (m manager) AllUsers(ctx context.Context) ([]datastore.Account, error) {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Erased users have no username and are skipped
+	rows, err := m.db.QueryContext(ctx, `SELECT payload, last_login_at FROM users WHERE username IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	users, err := scanUsers(rows)
+	return users, storeError(ctx, err)
+}
+
+// ListUsers retrieves a page of users from SQLite, ordered by their ID. The cursor for the next
+// page contains the ID of the last user in the page.
+func (m manager) ListUsers(ctx context.Context, limit int, cursor string) (datastore.Page, error) {
+	limit = datastore.PageSize(limit)
+
+	var position string
+	if len(cursor) > 0 {
+		if err := datastore.DecodeCursor(cursor, &position); err != nil {
+			return datastore.Page{}, err
+		}
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	// Ask for one more user than needed to know whether there is a next page
+	rows, err := m.db.QueryContext(ctx, `SELECT payload, last_login_at FROM users WHERE username IS NOT NULL AND id > ? ORDER BY id LIMIT ?`, position, limit+1)
+	if err != nil {
+		return datastore.Page{}, storeError(ctx, err)
+	}
+
+	users, err := scanUsers(rows)
+	if err != nil {
+		return datastore.Page{}, storeError(ctx, err)
+	}
+
+	page := datastore.Page{
+		Users: users,
+	}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		next, err := datastore.EncodeCursor(page.Users[limit-1].ID)
+		if err != nil {
+			return datastore.Page{}, err
+		}
+		page.Next = next
+	}
+
+	return page, nil
+}
+
+// scanUsers reads the payloads and last logins of the users from the rows one row at a time
+func scanUsers(rows *sql.Rows) ([]datastore.Account, error) {
+	defer rows.Close()
+
+	users := make([]datastore.Account, 0)
+
+	for rows.Next() {
+		var payload string
+		var lastLoginAt int64
+		if err := rows.Scan(&payload, &lastLoginAt); err != nil {
+			return nil, err
+		}
+
+		usr, err := datastore.UnmarshalStoredAccount(payload, lastLoginAt)
+		if err != nil {
+			log.Println(fmt.Sprintf("error unmarshalling user data: %s", err.Error()))
+			continue
+		}
+		users = append(users, usr)
+	}
+
+	return users, rows.Err()
+}
+
+// insertUser is the statement that stores a new user. Users that conflict with a stored user,
+// on the ID, username or email address, are not stored.
+const insertUser = `INSERT INTO users (id, username, email, name, status, version, payload, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT DO NOTHING`
+
+// AddUser stores a new user in SQLite. The unique indexes on the username and email address
+// make sure each of them can only belong to one user.
+func (m manager) AddUser(ctx context.Context, usr datastore.Account) error {
+	args, err := userArgs(usr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, insertUser, args...)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	return inserted(res)
+}
+
+// AddUsers stores new users in SQLite in a single transaction, which is much faster than a
+// transaction per user. Users that conflict with a stored user or with a user earlier in the
+// batch are skipped, and don't stop the other users of the batch from being stored.
+func (m manager) AddUsers(ctx context.Context, usrs []datastore.Account) ([]error, error) {
+	errs := make([]error, len(usrs))
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertUser)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer stmt.Close()
+
+	for idx, usr := range usrs {
+		args, err := userArgs(usr)
+		if err != nil {
+			errs[idx] = err
+			continue
+		}
+
+		res, err := stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return nil, storeError(ctx, err)
+		}
+		errs[idx] = inserted(res)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	return errs, nil
+}
+
+// userArgs returns the values of the columns of a new user, in the order of insertUser. New
+// users start at version 1.
+func userArgs(usr datastore.Account) ([]interface{}, error) {
+	usr.Version = 1
+	usr.CreatedAt = time.Now().Unix()
+	usr.UpdatedAt = usr.CreatedAt
+
+	payload, err := usr.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{
+		usr.ID,
+		datastore.NormalizeUsername(usr.Username),
+		nullString(datastore.SearchEmail(usr.User)),
+		datastore.SearchName(usr.User),
+		usr.State(),
+		usr.Version,
+		string(payload),
+		usr.CreatedAt,
+		usr.UpdatedAt,
+	}, nil
+}
+
+// inserted returns ErrUserExists when insertUser didn't store the user
+func inserted(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return datastore.ErrUserExists
+	}
+	return nil
+}
+
+// nullString returns NULL for an empty string, so users without an email address are not part
+// of the unique index on email addresses
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: len(s) > 0}
+}
+
+// UpdateUser replaces the payload and the status of a user in SQLite, together with the email
+// address and name it is found by. Erased users have no username and can't be updated. Only the
+// row with the version the user was read at is updated, so concurrent updates of the same user
+// can't overwrite each other. An email address that belongs to another user returns
+// datastore.ErrUserExists.
+func (m manager) UpdateUser(ctx context.Context, usr datastore.Account) error {
+	next := usr
+	next.Version = usr.Version + 1
+	next.UpdatedAt = time.Now().Unix()
+
+	payload, err := next.Marshal()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, `UPDATE users SET payload = ?, email = ?, name = ?, status = ?, version = ?, updated_at = ?
+		WHERE id = ? AND username IS NOT NULL AND version = ?`,
+		string(payload), nullString(datastore.SearchEmail(usr.User)), datastore.SearchName(usr.User), usr.State(), next.Version, next.UpdatedAt, usr.ID, usr.Version)
+	if isCode(err, sqlite3.SQLITE_CONSTRAINT_UNIQUE) {
+		return datastore.ErrUserExists
+	}
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	if n == 0 {
+		// No row matches both when the user doesn't exist and when it has another version
+		var exists bool
+		err := m.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = ? AND username IS NOT NULL)`, usr.ID).Scan(&exists)
+		if err != nil {
+			return storeError(ctx, err)
+		}
+		if exists {
+			return datastore.ErrVersionConflict
+		}
+		return datastore.NotFound("no user found with id %s", usr.ID)
+	}
+
+	return nil
+}
+
+// DeleteUser removes a user from SQLite, together with the address book and login history of
+// the user. If anonymize is true, the row is kept but the personal data is erased from the
+// payload and the username, email address, name and last login are removed so the user can no
+// longer be found and the username and email address can be used by new users.
+func (m manager) DeleteUser(ctx context.Context, userID string, anonymize bool) error {
+	ctx, cancel := datastore.WithTimeout(ctx)
+	defer cancel()
+
+	if !anonymize {
+		// The address book and login history are removed by the foreign keys
+		res, err := m.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID)
+		if err != nil {
+			return storeError(ctx, err)
+		}
+		return deleted(res, userID)
+	}
+
+	// Transactions hold the write lock from the start, so the user can't change between reading
+	// and erasing it
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var payload string
+	err = tx.QueryRowContext(ctx, `SELECT payload FROM users WHERE id = ?`, userID).Scan(&payload)
+	if err == sql.ErrNoRows {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	usr, err := datastore.UnmarshalAccount(payload)
+	if err != nil {
+		return err
+	}
+
+	erased := usr.Erase(time.Now())
+	erased.Version = usr.Version + 1
+	data, err := erased.Marshal()
+	if err != nil {
+		return err
+	}
+
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE users SET payload = ?, username = NULL, email = NULL, name = '', status = NULL, last_login_at = 0, version = ?, updated_at = ? WHERE id = ?`, []interface{}{string(data), erased.Version, time.Now().Unix(), userID}},
+		{`DELETE FROM addresses WHERE user_id = ?`, []interface{}{userID}},
+		{`DELETE FROM logins WHERE user_id = ?`, []interface{}{userID}},
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return storeError(ctx, err)
+		}
+	}
+
+	return storeError(ctx, tx.Commit())
+}
+
+// deleted returns an error when a statement didn't remove the user
+func deleted(res sql.Result, userID string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return datastore.NotFound("no user found with id %s", userID)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"github.com/retgits/acme-serverless-user/internal/datastore/datastoretest"
+)
+
+func TestConformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) datastore.Manager {
+		dir, err := ioutil.TempDir("", "sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		m, err := New(Config{Path: filepath.Join(dir, "acmeserverless.db")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	})
+}
@@ -0,0 +1,48 @@
+package datastore
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the time a single call to a data store can take when the environment
+// variable DATASTORE_TIMEOUT isn't set
+const DefaultTimeout = 10 * time.Second
+
+var (
+	timeoutOnce sync.Once
+	timeout     time.Duration
+)
+
+// Timeout returns the time a single call to a data store can take. It is read once from
+// the environment variable DATASTORE_TIMEOUT, as a duration like 5s or 1500ms, and is
+// DefaultTimeout when the variable isn't set or isn't a valid, positive duration.
+func Timeout() time.Duration {
+	timeoutOnce.Do(func() {
+		timeout = DefaultTimeout
+
+		value := os.Getenv("DATASTORE_TIMEOUT")
+		if len(value) == 0 {
+			return
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Printf("invalid DATASTORE_TIMEOUT %q, using %s", value, DefaultTimeout)
+			return
+		}
+		timeout = d
+	})
+
+	return timeout
+}
+
+// WithTimeout returns a context for a single call to a data store. The context is done when
+// the context of the request is done, or when Timeout has passed, whichever comes first. The
+// cancel function must be called once the call has finished.
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, Timeout())
+}
@@ -0,0 +1,14 @@
+// Package emitter contains the interfaces that the User service
+// in the ACME Serverless Fitness Shop needs to send events to other services.
+// In order to add a new service, the EventEmitter interface
+// needs to be implemented.
+package emitter
+
+import user "github.com/retgits/acme-serverless-user"
+
+// EventEmitter is the interface that describes the methods the
+// eventing service needs to implement to be able to work with
+// the ACME Serverless Fitness Shop.
+type EventEmitter interface {
+	Send(e user.UserDeleted) error
+}
@@ -0,0 +1,68 @@
+// Package eventbridge uses Amazon EventBridge as a serverless event bus that makes it easy to connect
+// applications together using data from your own applications, integrated Software-as-a-Service (SaaS)
+// applications, and AWS services.
+package eventbridge
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/emitter"
+)
+
+// Create a single instance of the EventBridge service
+// which can be reused if the container stays warm
+var svc *eventbridge.EventBridge
+
+type responder struct{}
+
+// init creates the connection to EventBridge.
+func init() {
+	awsSession := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(os.Getenv("REGION")),
+	}))
+
+	svc = eventbridge.New(awsSession)
+}
+
+// New creates a new instance of the EventEmitter with EventBridge as the eventing service.
+// The events are sent to the event bus set in the environment variable EVENTBUS.
+func New() emitter.EventEmitter {
+	return responder{}
+}
+
+// Send sends the event to Amazon EventBridge
+func (r responder) Send(e user.UserDeleted) error {
+	payload, err := e.Marshal()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]*eventbridge.PutEventsRequestEntry, 1)
+	entries[0] = &eventbridge.PutEventsRequestEntry{
+		Detail:       aws.String(string(payload)),
+		DetailType:   aws.String(e.Metadata.Type),
+		EventBusName: aws.String(os.Getenv("EVENTBUS")),
+		Resources:    []*string{aws.String(e.Data.UserID)},
+		Source:       aws.String(e.Metadata.Source),
+	}
+
+	event := &eventbridge.PutEventsInput{
+		Entries: entries,
+	}
+
+	output, err := svc.PutEvents(event)
+	if err != nil {
+		return err
+	}
+
+	if aws.Int64Value(output.FailedEntryCount) > 0 {
+		return fmt.Errorf("unable to send event %s: %s", e.Metadata.Type, aws.StringValue(output.Entries[0].ErrorMessage))
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+// Package etag turns the versions of the users of the User service in the ACME Serverless
+// Fitness Shop into entity tags, and checks the If-Match header of requests that update a
+// user against them, so clients can't overwrite changes they haven't seen.
+package etag
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrPreconditionRequired is returned when a request that updates a user has no
+	// If-Match header
+	ErrPreconditionRequired = errors.New("the request must have an If-Match header with the ETag of the user")
+
+	// ErrPreconditionFailed is returned when the If-Match header of a request doesn't match
+	// the current version of the user
+	ErrPreconditionFailed = errors.New("the user has been changed since the ETag in the If-Match header was sent")
+)
+
+// Format returns the entity tag of a version, like "3"
+func Format(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// Check compares the value of an If-Match header to the current version of a user. The
+// header can have a list of entity tags, or * to match any version. Weak entity tags
+// never match, because updates need a strong comparison.
+func Check(header string, version int64) error {
+	header = strings.TrimSpace(header)
+	if len(header) == 0 {
+		return ErrPreconditionRequired
+	}
+
+	if header == "*" {
+		return nil
+	}
+
+	current := Format(version)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == current {
+			return nil
+		}
+	}
+
+	return ErrPreconditionFailed
+}
+
+// IfMatch returns the value of the If-Match header from a map of headers, like the ones
+// API Gateway sends, regardless of the casing of the header name
+func IfMatch(headers map[string]string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, "If-Match") {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package etag
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	if got := Format(3); got != `"3"` {
+		t.Errorf("Format(3) = %s, want \"3\"", got)
+	}
+}
+
+// Only a strong entity tag of the current version, or *, matches
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   error
+	}{
+		{name: "current version", header: `"3"`, want: nil},
+		{name: "any version", header: "*", want: nil},
+		{name: "list with the current version", header: `"1", "3"`, want: nil},
+		{name: "surrounded by spaces", header: ` "3" `, want: nil},
+		{name: "older version", header: `"2"`, want: ErrPreconditionFailed},
+		{name: "weak entity tag", header: `W/"3"`, want: ErrPreconditionFailed},
+		{name: "unquoted", header: "3", want: ErrPreconditionFailed},
+		{name: "missing", header: "", want: ErrPreconditionRequired},
+		{name: "only spaces", header: "  ", want: ErrPreconditionRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Check(tt.header, 3); err != tt.want {
+				t.Errorf("Check(%q, 3) = %v, want %v", tt.header, err, tt.want)
+			}
+		})
+	}
+}
+
+// The header is found regardless of the casing of its name
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		want    string
+	}{
+		{headers: map[string]string{"If-Match": `"1"`}, want: `"1"`},
+		{headers: map[string]string{"if-match": `"2"`}, want: `"2"`},
+		{headers: map[string]string{"IF-MATCH": `"3"`, "If-None-Match": `"4"`}, want: `"3"`},
+		{headers: map[string]string{"If-None-Match": `"4"`}, want: ""},
+		{headers: nil, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := IfMatch(tt.headers); got != tt.want {
+			t.Errorf("IfMatch(%v) = %q, want %q", tt.headers, got, tt.want)
+		}
+	}
+}
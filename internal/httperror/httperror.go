@@ -0,0 +1,31 @@
+// Package httperror translates the errors of the data stores of the User service in the ACME
+// Serverless Fitness Shop into HTTP status codes, so the Cloud Run service and the Lambda
+// functions respond to the same error with the same status code.
+package httperror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// StatusCode returns the HTTP status code of a response to a request that failed with err.
+// Users and addresses that don't exist get an HTTP/404, changes that conflict with the
+// stored data get an HTTP/409, requests the data store can't handle right now get an
+// HTTP/503 and requests the data store considers invalid get an HTTP/422. Any other error
+// gets an HTTP/400.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, datastore.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, datastore.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, datastore.ErrInvalid):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusBadRequest
+	}
+}
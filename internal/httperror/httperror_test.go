@@ -0,0 +1,37 @@
+package httperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+)
+
+// Errors of the data stores get the status code of their kind, also when they're wrapped
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "not found", err: datastore.NotFound("user %s doesn't exist", "u1"), want: http.StatusNotFound},
+		{name: "address not found", err: datastore.ErrAddressNotFound, want: http.StatusNotFound},
+		{name: "user exists", err: datastore.ErrUserExists, want: http.StatusConflict},
+		{name: "version conflict", err: datastore.ErrVersionConflict, want: http.StatusConflict},
+		{name: "wrapped conflict", err: fmt.Errorf("error exporting profile: %w", datastore.ErrUserExists), want: http.StatusConflict},
+		{name: "unavailable", err: datastore.Unavailable(errors.New("throttled")), want: http.StatusServiceUnavailable},
+		{name: "invalid cursor", err: datastore.ErrInvalidCursor, want: http.StatusUnprocessableEntity},
+		{name: "invalid query", err: datastore.ErrInvalidQuery, want: http.StatusUnprocessableEntity},
+		{name: "other error", err: errors.New("unexpected end of JSON input"), want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusCode(tt.err); got != tt.want {
+				t.Errorf("StatusCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
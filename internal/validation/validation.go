@@ -0,0 +1,588 @@
+// Package validation checks the payloads that are sent to the User service in the
+// ACME Serverless Fitness Shop before they're stored. Each check that fails is
+// reported as a separate field error, so clients can show all problems at once.
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	acmeserverless "github.com/retgits/acme-serverless"
+	user "github.com/retgits/acme-serverless-user"
+	"github.com/retgits/acme-serverless-user/internal/datastore"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// RuleJSON is the rule for request bodies that are not a JSON object
+	RuleJSON = "json"
+
+	// RuleUnknown is the rule for fields that are not part of the payload
+	RuleUnknown = "unknown"
+
+	// RuleType is the rule for fields that have the wrong JSON type
+	RuleType = "type"
+
+	// RuleRequired is the rule for fields that must have a value
+	RuleRequired = "required"
+
+	// RuleMinLength is the rule for fields that are too short
+	RuleMinLength = "min_length"
+
+	// RuleMaxLength is the rule for fields that are too long
+	RuleMaxLength = "max_length"
+
+	// RuleCharset is the rule for fields that contain characters that are not allowed
+	RuleCharset = "charset"
+
+	// RuleEmail is the rule for fields that must be a valid email address
+	RuleEmail = "email"
+
+	// RuleOneOf is the rule for fields that must have one of a fixed set of values
+	RuleOneOf = "one_of"
+
+	// RuleFormat is the rule for fields that don't have the format required for their value,
+	// like a postal code that isn't valid in the country of the address
+	RuleFormat = "format"
+
+	// RuleConflict is the rule for fields that can't be set together with another field
+	RuleConflict = "conflict"
+)
+
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 32
+	passwordMinLength = 8
+	passwordMaxLength = 128
+	nameMaxLength     = 64
+	emailMaxLength    = 254
+	reasonMaxLength   = 512
+	addressMaxLength  = 128
+	idMaxLength       = 64
+)
+
+// usernameCharset are the characters a username, and the ID of an imported user, can
+// consist of. They must start with a letter or digit.
+var usernameCharset = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// countryCode is the format of a two letter ISO 3166-1 country code
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// country has the address rules of a country
+type country struct {
+	// region is true if the address needs a state, province or prefecture
+	region bool
+
+	// postalCode is the format of postal codes, or nil if addresses in the country
+	// don't need a postal code
+	postalCode *regexp.Regexp
+}
+
+// countries are the address rules of countries that need more than a name, street and city.
+// Addresses in other countries only need those.
+var countries = map[string]country{
+	"AU": {region: true, postalCode: regexp.MustCompile(`^\d{4}$`)},
+	"BE": {postalCode: regexp.MustCompile(`^\d{4}$`)},
+	"BR": {region: true, postalCode: regexp.MustCompile(`^\d{5}-?\d{3}$`)},
+	"CA": {region: true, postalCode: regexp.MustCompile(`^[A-Za-z]\d[A-Za-z] ?\d[A-Za-z]\d$`)},
+	"DE": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"ES": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"FR": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"GB": {postalCode: regexp.MustCompile(`^[A-Za-z]{1,2}\d[A-Za-z\d]? ?\d[A-Za-z]{2}$`)},
+	"IN": {region: true, postalCode: regexp.MustCompile(`^\d{6}$`)},
+	"IT": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"JP": {region: true, postalCode: regexp.MustCompile(`^\d{3}-?\d{4}$`)},
+	"MX": {region: true, postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"NL": {postalCode: regexp.MustCompile(`^\d{4} ?[A-Za-z]{2}$`)},
+	"US": {region: true, postalCode: regexp.MustCompile(`^\d{5}(-\d{4})?$`)},
+}
+
+// languages are the languages the shop is available in
+var languages = []string{"de-DE", "en-GB", "en-US", "es-ES", "fr-FR", "ja-JP", "nl-NL"}
+
+// currencies are the currencies the shop can show prices in
+var currencies = []string{"AUD", "CAD", "EUR", "GBP", "JPY", "USD"}
+
+// channels are the channels users can get order notifications through
+var channels = []string{user.NotifyEmail, user.NotifySMS, user.NotifyPush}
+
+// Error is returned when a payload doesn't pass validation. It contains an
+// entry for each check that failed.
+type Error struct {
+	Fields []user.FieldError
+}
+
+// Error returns a description of all fields that failed validation
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for idx, f := range e.Fields {
+		msgs[idx] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+	}
+	return fmt.Sprintf("validation failed: %s", strings.Join(msgs, ", "))
+}
+
+// Response returns the error document that is sent back to the client
+func (e *Error) Response(status int) user.ValidationErrorResponse {
+	return user.ValidationErrorResponse{
+		Message: "The request contains invalid fields",
+		Errors:  e.Fields,
+		Status:  status,
+	}
+}
+
+// registration is the payload that is accepted to register a new user
+type registration struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Email     string `json:"email"`
+}
+
+// imported is the payload that is accepted for each user in a bulk import
+type imported struct {
+	ID        string   `json:"id"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	Firstname string   `json:"firstname"`
+	Lastname  string   `json:"lastname"`
+	Email     string   `json:"email"`
+	Roles     []string `json:"roles"`
+	Status    string   `json:"status"`
+
+	// PasswordHash is the bcrypt hash of the password, for users that are imported from
+	// another identity provider
+	PasswordHash string `json:"passwordHash"`
+
+	// PasswordReset is true for users that must set a new password before logging in
+	PasswordReset bool `json:"passwordReset"`
+}
+
+// statusChange is the payload that is accepted to change the status of a user
+type statusChange struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// address is the payload that is accepted to add or replace an address
+type address struct {
+	Name            string `json:"name"`
+	Line1           string `json:"line1"`
+	Line2           string `json:"line2"`
+	City            string `json:"city"`
+	Region          string `json:"region"`
+	PostalCode      string `json:"postalCode"`
+	Country         string `json:"country"`
+	DefaultShipping bool   `json:"defaultShipping"`
+	DefaultBilling  bool   `json:"defaultBilling"`
+}
+
+// preferences is the payload that is accepted to change the preferences of a user
+type preferences struct {
+	Language           *string   `json:"language"`
+	Currency           *string   `json:"currency"`
+	Newsletter         *bool     `json:"newsletter"`
+	OrderNotifications *[]string `json:"orderNotifications"`
+}
+
+// Registration parses and validates the payload to register a new user. If the
+// payload is not valid, the error is of type *Error.
+func Registration(data []byte) (acmeserverless.User, error) {
+	var r registration
+	v := &validator{}
+
+	if !v.decode(data, &r, "username", "password", "firstname", "lastname", "email") {
+		return acmeserverless.User{}, v.err()
+	}
+
+	v.username("username", r.Username)
+	v.password("password", r.Password)
+	v.name("firstname", r.Firstname)
+	v.name("lastname", r.Lastname)
+	v.email("email", r.Email)
+
+	return acmeserverless.User{
+		Username:  r.Username,
+		Password:  r.Password,
+		Firstname: r.Firstname,
+		Lastname:  r.Lastname,
+		Email:     r.Email,
+	}, v.err()
+}
+
+// Import parses and validates a single user in a bulk import. The user is checked with
+// the same rules as a registration. The ID, roles and status are optional: users without
+// an ID keep an empty ID and users without a status are active. Instead of a password,
+// a user can have the bcrypt hash of the password or be marked to reset the password.
+// If the payload is not valid, the error is of type *Error.
+func Import(data []byte) (datastore.Account, error) {
+	var r imported
+	v := &validator{}
+
+	if !v.decode(data, &r, "id", "username", "password", "firstname", "lastname", "email", "roles", "status", "passwordHash", "passwordReset") {
+		return datastore.Account{}, v.err()
+	}
+
+	if !v.invalid["id"] && len(r.ID) > 0 && v.length("id", r.ID, 1, idMaxLength) && !usernameCharset.MatchString(r.ID) {
+		v.add("id", RuleCharset, "must start with a letter or digit and can only contain letters, digits, '.', '_' and '-'")
+	}
+	v.username("username", r.Username)
+	switch {
+	case v.invalid["passwordHash"] || len(r.PasswordHash) > 0:
+		v.passwordHash("passwordHash", r.PasswordHash)
+		if len(r.Password) > 0 {
+			v.add("password", RuleConflict, "can't be set together with passwordHash")
+		}
+	case r.PasswordReset && len(r.Password) == 0:
+		// The user sets a password before logging in for the first time
+	default:
+		v.password("password", r.Password)
+	}
+	v.name("firstname", r.Firstname)
+	v.name("lastname", r.Lastname)
+	v.email("email", r.Email)
+	for _, role := range r.Roles {
+		v.oneOf("roles", role, datastore.RoleAdmin)
+	}
+	if !v.invalid["status"] && len(r.Status) > 0 {
+		v.oneOf("status", r.Status, datastore.StatusPending, datastore.StatusActive, datastore.StatusLocked, datastore.StatusDisabled)
+	}
+
+	acct := datastore.Account{
+		User: acmeserverless.User{
+			ID:        r.ID,
+			Username:  r.Username,
+			Password:  r.Password,
+			Firstname: r.Firstname,
+			Lastname:  r.Lastname,
+			Email:     r.Email,
+		},
+	}
+	for _, role := range r.Roles {
+		acct.SetRole(role, true)
+	}
+	if r.Status != datastore.StatusActive {
+		acct.Status = r.Status
+	}
+	acct.PasswordHash = r.PasswordHash
+	acct.PasswordReset = r.PasswordReset
+
+	return acct, v.err()
+}
+
+// StatusChange parses and validates the payload to change the status of a user. It
+// returns the new status and the reason for the change. If the payload is not valid,
+// the error is of type *Error.
+func StatusChange(data []byte) (string, string, error) {
+	var r statusChange
+	v := &validator{}
+
+	if !v.decode(data, &r, "status", "reason") {
+		return "", "", v.err()
+	}
+
+	v.oneOf("status", r.Status, datastore.StatusPending, datastore.StatusActive, datastore.StatusLocked, datastore.StatusDisabled)
+	if v.required("reason", r.Reason) {
+		v.length("reason", r.Reason, 1, reasonMaxLength)
+	}
+
+	return r.Status, strings.TrimSpace(r.Reason), v.err()
+}
+
+// Address parses and validates the payload to add or replace an address. Which fields are
+// required, and the format of the postal code, depend on the country of the address. If
+// the payload is not valid, the error is of type *Error.
+func Address(data []byte) (user.Address, error) {
+	var r address
+	v := &validator{}
+
+	if !v.decode(data, &r, "name", "line1", "line2", "city", "region", "postalCode", "country", "defaultShipping", "defaultBilling") {
+		return user.Address{}, v.err()
+	}
+
+	r.Country = strings.ToUpper(strings.TrimSpace(r.Country))
+	r.PostalCode = strings.TrimSpace(r.PostalCode)
+
+	v.text("name", r.Name, true)
+	v.text("line1", r.Line1, true)
+	v.text("line2", r.Line2, false)
+	v.text("city", r.City, true)
+
+	if v.required("country", r.Country) && !countryCode.MatchString(r.Country) {
+		v.add("country", RuleFormat, "must be a two letter ISO 3166-1 country code")
+	}
+
+	c := countries[r.Country]
+	v.text("region", r.Region, c.region)
+	v.text("postalCode", r.PostalCode, c.postalCode != nil)
+	if c.postalCode != nil && len(r.PostalCode) > 0 && !v.invalid["postalCode"] && !c.postalCode.MatchString(r.PostalCode) {
+		v.add("postalCode", RuleFormat, "is not a valid postal code in %s", r.Country)
+	}
+
+	return user.Address{
+		Name:            r.Name,
+		Line1:           r.Line1,
+		Line2:           r.Line2,
+		City:            r.City,
+		Region:          r.Region,
+		PostalCode:      r.PostalCode,
+		Country:         r.Country,
+		DefaultShipping: r.DefaultShipping,
+		DefaultBilling:  r.DefaultBilling,
+	}, v.err()
+}
+
+// Preferences parses and validates the payload to change the preferences of a user. Fields
+// that are not part of the payload get their default value. If the payload is not valid,
+// the error is of type *Error.
+func Preferences(data []byte) (user.Preferences, error) {
+	var r preferences
+	v := &validator{}
+
+	if !v.decode(data, &r, "language", "currency", "newsletter", "orderNotifications") {
+		return user.Preferences{}, v.err()
+	}
+
+	p := user.DefaultPreferences()
+
+	if r.Language != nil && !v.invalid["language"] {
+		p.Language = *r.Language
+		v.oneOf("language", p.Language, languages...)
+	}
+
+	if r.Currency != nil && !v.invalid["currency"] {
+		p.Currency = *r.Currency
+		v.oneOf("currency", p.Currency, currencies...)
+	}
+
+	if r.Newsletter != nil && !v.invalid["newsletter"] {
+		p.Newsletter = *r.Newsletter
+	}
+
+	if r.OrderNotifications != nil && !v.invalid["orderNotifications"] {
+		p.OrderNotifications = *r.OrderNotifications
+		v.channels("orderNotifications", p.OrderNotifications)
+	}
+
+	return p, v.err()
+}
+
+// Password validates a new password for a user. If the password is not valid, the error
+// is of type *Error.
+func Password(password string) error {
+	v := &validator{}
+	v.password("password", password)
+	return v.err()
+}
+
+// validator collects the field errors of a single payload
+type validator struct {
+	fields []user.FieldError
+
+	// invalid are the fields that couldn't be decoded, no other rules are checked for them
+	invalid map[string]bool
+}
+
+// add records a field that failed a rule
+func (v *validator) add(field string, rule string, format string, a ...interface{}) {
+	v.fields = append(v.fields, user.FieldError{
+		Field:   field,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// err returns an *Error if any field failed validation, or nil otherwise
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &Error{Fields: v.fields}
+}
+
+// decode parses the JSON object in data into dst. Fields that are not in allowed
+// are reported as unknown. It returns false if the payload is not a JSON object.
+func (v *validator) decode(data []byte, dst interface{}, allowed ...string) bool {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || raw == nil {
+		v.add("", RuleJSON, "request body must be a JSON object")
+		return false
+	}
+
+	known := make(map[string]bool, len(allowed))
+	for _, a := range allowed {
+		known[a] = true
+	}
+
+	// Report unknown fields in a stable order
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !known[k] {
+			v.add(k, RuleUnknown, "unknown field")
+		}
+	}
+
+	// Decode each field separately so every field with a wrong type is reported
+	v.invalid = make(map[string]bool)
+	for _, k := range allowed {
+		val, ok := raw[k]
+		if !ok {
+			continue
+		}
+
+		field, _ := json.Marshal(map[string]json.RawMessage{k: val})
+		if err := json.Unmarshal(field, dst); err != nil {
+			v.add(k, RuleType, "must be a %s", kind(dst, k))
+			v.invalid[k] = true
+		}
+	}
+
+	return true
+}
+
+// required checks the field has a value. Fields that couldn't be decoded have
+// already been reported and fail without a new error.
+func (v *validator) required(field string, value string) bool {
+	if v.invalid[field] {
+		return false
+	}
+	if len(strings.TrimSpace(value)) == 0 {
+		v.add(field, RuleRequired, "is required")
+		return false
+	}
+	return true
+}
+
+// length checks the number of characters of the field is between min and max
+func (v *validator) length(field string, value string, min int, max int) bool {
+	n := utf8.RuneCountInString(value)
+	if n < min {
+		v.add(field, RuleMinLength, "must be at least %d characters", min)
+		return false
+	}
+	if n > max {
+		v.add(field, RuleMaxLength, "must be at most %d characters", max)
+		return false
+	}
+	return true
+}
+
+// username checks the length and the characters of a username
+func (v *validator) username(field string, value string) {
+	if !v.required(field, value) || !v.length(field, value, usernameMinLength, usernameMaxLength) {
+		return
+	}
+	if !usernameCharset.MatchString(value) {
+		v.add(field, RuleCharset, "must start with a letter or digit and can only contain letters, digits, '.', '_' and '-'")
+	}
+}
+
+// passwordHash checks that the value is a bcrypt hash
+func (v *validator) passwordHash(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+	if _, err := bcrypt.Cost([]byte(value)); err != nil {
+		v.add(field, RuleFormat, "must be a bcrypt hash")
+	}
+}
+
+// password checks the length of a password
+func (v *validator) password(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+	v.length(field, value, passwordMinLength, passwordMaxLength)
+}
+
+// name checks the length of a first or last name
+func (v *validator) name(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+	v.length(field, value, 1, nameMaxLength)
+}
+
+// email checks that the value is a single address as described in RFC 5322,
+// without a display name
+func (v *validator) email(field string, value string) {
+	if !v.required(field, value) || !v.length(field, value, 1, emailMaxLength) {
+		return
+	}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value || addr.Name != "" {
+		v.add(field, RuleEmail, "must be a valid email address")
+	}
+}
+
+// oneOf checks that the value is one of the allowed values
+func (v *validator) oneOf(field string, value string, allowed ...string) {
+	if !v.required(field, value) {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, RuleOneOf, "must be one of %s", strings.Join(allowed, ", "))
+}
+
+// text checks the length of a line of an address. Optional fields can be left empty.
+func (v *validator) text(field string, value string, required bool) {
+	if v.invalid[field] || (!required && len(value) == 0) {
+		return
+	}
+	if !v.required(field, value) {
+		return
+	}
+	v.length(field, value, 1, addressMaxLength)
+}
+
+// channels checks that each notification channel is known and appears only once
+func (v *validator) channels(field string, values []string) {
+	seen := make(map[string]bool, len(values))
+	for _, c := range values {
+		if seen[c] {
+			v.add(field, RuleOneOf, "must not contain %s more than once", c)
+			return
+		}
+		seen[c] = true
+		v.oneOf(field, c, channels...)
+	}
+}
+
+// kind returns the name of the JSON type of the field of dst with the JSON name field
+func kind(dst interface{}, field string) string {
+	t := reflect.TypeOf(dst).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if strings.Split(f.Tag.Get("json"), ",")[0] != field {
+			continue
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		switch ft.Kind() {
+		case reflect.Bool:
+			return "boolean"
+		case reflect.Slice:
+			return "list of strings"
+		}
+	}
+	return "string"
+}
@@ -0,0 +1,221 @@
+package validation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	user "github.com/retgits/acme-serverless-user"
+)
+
+// hash is a bcrypt hash, which imported users can have instead of a password
+const hash = "$2a$04$8hzvCDkuTBKLeyvJIqaKXO56.8ALoiY3OU3eLTJk5NED.bpAbP5XG"
+
+// failed returns the field and rule of each check that failed, like username:min_length
+func failed(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	verr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error %v is a %T, want *Error", err, err)
+	}
+
+	var rules []string
+	for _, f := range verr.Fields {
+		rules = append(rules, f.Field+":"+f.Rule)
+	}
+	return rules
+}
+
+func TestRegistration(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "valid",
+			body: `{"username":"dwight","password":"beets1234","firstname":"Dwight","lastname":"Schrute","email":"dwight@dunder-mifflin.com"}`,
+		},
+		{
+			name: "not an object",
+			body: `["dwight"]`,
+			want: []string{":json"},
+		},
+		{
+			name: "every field fails",
+			body: `{"username":"-d","password":"beets","firstname":"","lastname":" ","email":"Dwight <dwight@dunder-mifflin.com>","role":"admin"}`,
+			want: []string{"role:unknown", "username:min_length", "password:min_length", "firstname:required", "lastname:required", "email:email"},
+		},
+		{
+			name: "wrong types are not checked further",
+			body: `{"username":42,"password":true,"firstname":"Dwight","lastname":"Schrute","email":"dwight@dunder-mifflin.com"}`,
+			want: []string{"username:type", "password:type"},
+		},
+		{
+			name: "charset and length",
+			body: `{"username":"dwight schrute","password":"` + strings.Repeat("b", 129) + `","firstname":"Dwight","lastname":"Schrute","email":"dwight"}`,
+			want: []string{"username:charset", "password:max_length", "email:email"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Registration([]byte(tt.body))
+			if got := failed(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Registration(%s) failed %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	fields := `"username":"dwight","firstname":"Dwight","lastname":"Schrute","email":"dwight@dunder-mifflin.com"`
+
+	tests := []struct {
+		name  string
+		body  string
+		want  []string
+		reset bool
+	}{
+		{name: "password", body: `{` + fields + `,"password":"beets1234"}`},
+		{name: "password hash", body: `{` + fields + `,"passwordHash":"` + hash + `"}`},
+		{name: "password reset", body: `{` + fields + `,"passwordReset":true}`, reset: true},
+		{name: "no password", body: `{` + fields + `}`, want: []string{"password:required"}},
+		{name: "reset turned off", body: `{` + fields + `,"passwordReset":false}`, want: []string{"password:required"}},
+		{name: "reset that isn't a boolean", body: `{` + fields + `,"passwordReset":"maybe"}`, want: []string{"passwordReset:type", "password:required"}},
+		{name: "hash that isn't bcrypt", body: `{` + fields + `,"passwordHash":"5f4dcc3b5aa765d61d8327deb882cf99"}`, want: []string{"passwordHash:format"}},
+		{name: "password and hash", body: `{` + fields + `,"password":"beets1234","passwordHash":"` + hash + `"}`, want: []string{"password:conflict"}},
+		{name: "id, roles and status", body: `{` + fields + `,"password":"beets1234","id":"u1","roles":["admin"],"status":"locked"}`},
+		{name: "invalid id, role and status", body: `{` + fields + `,"password":"beets1234","id":"-u1","roles":["manager"],"status":"erased"}`, want: []string{"id:charset", "roles:one_of", "status:one_of"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acct, err := Import([]byte(tt.body))
+			if got := failed(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Import(%s) failed %v, want %v", tt.body, got, tt.want)
+			}
+			if acct.PasswordReset != tt.reset {
+				t.Errorf("Import(%s) passwordReset = %t, want %t", tt.body, acct.PasswordReset, tt.reset)
+			}
+		})
+	}
+}
+
+func TestStatusChange(t *testing.T) {
+	tests := []struct {
+		body   string
+		want   []string
+		reason string
+	}{
+		{body: `{"status":"disabled","reason":" chargeback "}`, reason: "chargeback"},
+		{body: `{"status":"erased","reason":"gone"}`, want: []string{"status:one_of"}, reason: "gone"},
+		{body: `{"status":"active"}`, want: []string{"reason:required"}},
+		{body: `{}`, want: []string{"status:required", "reason:required"}},
+	}
+
+	for _, tt := range tests {
+		_, reason, err := StatusChange([]byte(tt.body))
+		if got := failed(t, err); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("StatusChange(%s) failed %v, want %v", tt.body, got, tt.want)
+		}
+		if reason != tt.reason {
+			t.Errorf("StatusChange(%s) reason = %q, want %q", tt.body, reason, tt.reason)
+		}
+	}
+}
+
+// The fields an address needs depend on its country
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "US", body: `{"name":"Dwight Schrute","line1":"1725 Slough Avenue","city":"Scranton","region":"PA","postalCode":"18505","country":"us"}`},
+		{name: "US without state", body: `{"name":"Dwight Schrute","line1":"1725 Slough Avenue","city":"Scranton","postalCode":"18505","country":"US"}`, want: []string{"region:required"}},
+		{name: "US postal code", body: `{"name":"Dwight Schrute","line1":"1725 Slough Avenue","city":"Scranton","region":"PA","postalCode":"1850","country":"US"}`, want: []string{"postalCode:format"}},
+		{name: "NL", body: `{"name":"Dwight Schrute","line1":"Damrak 1","city":"Amsterdam","postalCode":"1012 LG","country":"NL"}`},
+		{name: "country without rules", body: `{"name":"Dwight Schrute","line1":"Main Street 1","city":"Dublin","country":"IE"}`},
+		{name: "country code", body: `{"name":"Dwight Schrute","line1":"Main Street 1","city":"Dublin","country":"Ireland"}`, want: []string{"country:format"}},
+		{name: "empty", body: `{}`, want: []string{"name:required", "line1:required", "city:required", "country:required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Address([]byte(tt.body))
+			if got := failed(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Address(%s) failed %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+// Preferences that are left out get their default value
+func TestPreferences(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		want  []string
+		prefs user.Preferences
+	}{
+		{
+			name:  "defaults",
+			body:  `{}`,
+			prefs: user.DefaultPreferences(),
+		},
+		{
+			name:  "all fields",
+			body:  `{"language":"nl-NL","currency":"EUR","newsletter":true,"orderNotifications":["sms","push"]}`,
+			prefs: user.Preferences{Language: "nl-NL", Currency: "EUR", Newsletter: true, OrderNotifications: []string{"sms", "push"}},
+		},
+		{
+			name: "unknown values",
+			body: `{"language":"xx-XX","currency":"BTC","orderNotifications":["fax"]}`,
+			want: []string{"language:one_of", "currency:one_of", "orderNotifications:one_of"},
+		},
+		{
+			name: "repeated channel",
+			body: `{"orderNotifications":["sms","sms"]}`,
+			want: []string{"orderNotifications:one_of"},
+		},
+		{
+			name: "wrong types",
+			body: `{"newsletter":"yes","orderNotifications":"sms"}`,
+			want: []string{"newsletter:type", "orderNotifications:type"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs, err := Preferences([]byte(tt.body))
+			if got := failed(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Preferences(%s) failed %v, want %v", tt.body, got, tt.want)
+			}
+			if err == nil && !reflect.DeepEqual(prefs, tt.prefs) {
+				t.Errorf("Preferences(%s) = %+v, want %+v", tt.body, prefs, tt.prefs)
+			}
+		})
+	}
+}
+
+func TestPassword(t *testing.T) {
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{password: "beets1234"},
+		{password: "beets", want: []string{"password:min_length"}},
+		{password: "", want: []string{"password:required"}},
+		{password: strings.Repeat("b", 129), want: []string{"password:max_length"}},
+	}
+
+	for _, tt := range tests {
+		if got := failed(t, Password(tt.password)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Password(%q) failed %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package user
+
+import "encoding/json"
+
+const (
+	// LoginMethodUsername is the method of logins with a username
+	LoginMethodUsername = "username"
+
+	// LoginMethodEmail is the method of logins with an email address
+	LoginMethodEmail = "email"
+)
+
+// LoginAttempt is a single attempt of a user to log in, successful or not
+type LoginAttempt struct {
+	// At is the Unix timestamp of the attempt
+	At int64 `json:"at"`
+
+	// IP is the IP address the attempt was made from
+	IP string `json:"ip,omitempty"`
+
+	// UserAgent is the user agent of the client the attempt was made with
+	UserAgent string `json:"userAgent,omitempty"`
+
+	// Success is true if the user got new tokens
+	Success bool `json:"success"`
+
+	// Method is how the user identified, LoginMethodUsername or LoginMethodEmail
+	Method string `json:"method"`
+
+	// Reason explains why an attempt failed
+	Reason string `json:"reason,omitempty"`
+}
+
+// UnmarshalLoginAttempt parses the JSON-encoded data and stores the result in a LoginAttempt
+func UnmarshalLoginAttempt(data string) (LoginAttempt, error) {
+	var r LoginAttempt
+	err := json.Unmarshal([]byte(data), &r)
+	return r, err
+}
+
+// Marshal returns the JSON encoding of LoginAttempt
+func (r *LoginAttempt) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,51 @@
+package user
+
+import "encoding/json"
+
+const (
+	// NotifyEmail sends order notifications by email
+	NotifyEmail = "email"
+
+	// NotifySMS sends order notifications by text message
+	NotifySMS = "sms"
+
+	// NotifyPush sends order notifications as push notifications to the app
+	NotifyPush = "push"
+)
+
+// Preferences are the settings of a user
+type Preferences struct {
+	// Language is the preferred language of the user, as a language tag like en-US
+	Language string `json:"language"`
+
+	// Currency is the three letter ISO 4217 code of the currency prices are shown in
+	Currency string `json:"currency"`
+
+	// Newsletter is true if the user wants to receive the newsletter
+	Newsletter bool `json:"newsletter"`
+
+	// OrderNotifications are the channels the user is notified through about orders
+	OrderNotifications []string `json:"orderNotifications"`
+}
+
+// DefaultPreferences returns the preferences of users that haven't changed their preferences
+func DefaultPreferences() Preferences {
+	return Preferences{
+		Language:           "en-US",
+		Currency:           "USD",
+		Newsletter:         false,
+		OrderNotifications: []string{NotifyEmail},
+	}
+}
+
+// UnmarshalPreferences parses the JSON-encoded data and stores the result in Preferences
+func UnmarshalPreferences(data string) (Preferences, error) {
+	var r Preferences
+	err := json.Unmarshal([]byte(data), &r)
+	return r, err
+}
+
+// Marshal returns the JSON encoding of Preferences
+func (r *Preferences) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -48,6 +48,12 @@ type GenericConfig struct {
 
 	// WavefrontToken is your Wavefront API token
 	WavefrontToken string `json:"wavefronttoken"`
+
+	// EventBus is the name of the Amazon EventBridge event bus to send events to
+	EventBus string `json:"eventbus"`
+
+	// CursorKey is the key that signs pagination cursors
+	CursorKey string `json:"cursorkey"`
 }
 
 func main() {
@@ -70,6 +76,11 @@ func main() {
 		conf.RequireObject("generic", &genericConfig)
 		genericConfig.Region = region
 
+		// Listing users fails without the key that signs cursors, so don't deploy without it
+		if len(genericConfig.CursorKey) < 16 {
+			return fmt.Errorf("awsconfig:generic.cursorkey must be set to a secret of at least 16 bytes")
+		}
+
 		// Create a map[string]pulumi.Input of the tags
 		// the first four tags come from the configuration file
 		// the last two are derived from this deployment
@@ -83,11 +94,22 @@ func main() {
 
 		// functions are the functions that need to be deployed
 		functions := []string{
+			"lambda-user-address-add",
+			"lambda-user-address-all",
+			"lambda-user-address-delete",
+			"lambda-user-address-get",
+			"lambda-user-address-update",
 			"lambda-user-all",
+			"lambda-user-delete",
+			"lambda-user-export",
 			"lambda-user-get",
 			"lambda-user-login",
+			"lambda-user-logins",
+			"lambda-user-preferences-get",
+			"lambda-user-preferences-update",
 			"lambda-user-refreshtoken",
 			"lambda-user-register",
+			"lambda-user-status",
 			"lambda-user-verifytoken",
 		}
 
@@ -128,6 +150,20 @@ func main() {
 			return err
 		}
 
+		// eventPolicy is a policy template, derived from AWS SAM, to allow apps
+		// to send events to Amazon EventBridge
+		iamFactory.ClearPolicies()
+		iamFactory.AddEventBridgePutEventsPolicy(genericConfig.EventBus)
+		eventPolicy, err := iamFactory.GetPolicyStatement()
+		if err != nil {
+			return err
+		}
+
+		// eventFunctions are the functions that send events to other services
+		eventFunctions := map[string]bool{
+			"lambda-user-delete": true,
+		}
+
 		roles := make(map[string]*iam.Role)
 
 		// Create a new IAM role for each Lambda function
@@ -163,6 +199,18 @@ func main() {
 				return err
 			}
 
+			// Add the EventBridge policy
+			if eventFunctions[function] {
+				_, err = iam.NewRolePolicy(ctx, fmt.Sprintf("ACMEServerlessUserEventPolicy-%s", function), &iam.RolePolicyArgs{
+					Name:   pulumi.String(fmt.Sprintf("ACMEServerlessUserEventPolicy-%s", function)),
+					Role:   role.Name,
+					Policy: pulumi.String(eventPolicy),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
 			ctx.Export(fmt.Sprintf("%s-role::Arn", function), role.Arn)
 			roles[function] = role
 		}
@@ -175,6 +223,8 @@ func main() {
 		variables["VERSION"] = tags.Version
 		variables["STAGE"] = pulumi.String(ctx.Stack())
 		variables["TABLE"] = pulumi.String(dynamoTable.Name)
+		variables["EVENTBUS"] = pulumi.String(genericConfig.EventBus)
+		variables["CURSOR_KEY"] = pulumi.String(genericConfig.CursorKey)
 		variables["WAVEFRONT_URL"] = pulumi.String(genericConfig.WavefrontURL)
 		variables["WAVEFRONT_API_TOKEN"] = pulumi.String(genericConfig.WavefrontToken)
 
@@ -204,6 +254,188 @@ func main() {
 
 		ctx.Export("lambda-user-all::Arn", userAllFunction.Arn)
 
+		// Create the AddressAdd function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-address-add", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to add an address to the address book of a user in DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-address-add", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-address-add"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-address-add/lambda-user-address-add.zip"),
+			Role:        roles["lambda-user-address-add"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userAddressAddFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-address-add", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-address-add::Arn", userAddressAddFunction.Arn)
+
+		// Create the AddressAll function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-address-all", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to get the address book of a user from DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-address-all", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-address-all"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-address-all/lambda-user-address-all.zip"),
+			Role:        roles["lambda-user-address-all"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userAddressAllFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-address-all", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-address-all::Arn", userAddressAllFunction.Arn)
+
+		// Create the AddressDelete function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-address-delete", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to delete an address from the address book of a user in DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-address-delete", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-address-delete"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-address-delete/lambda-user-address-delete.zip"),
+			Role:        roles["lambda-user-address-delete"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userAddressDeleteFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-address-delete", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-address-delete::Arn", userAddressDeleteFunction.Arn)
+
+		// Create the AddressGet function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-address-get", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to get an address from the address book of a user from DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-address-get", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-address-get"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-address-get/lambda-user-address-get.zip"),
+			Role:        roles["lambda-user-address-get"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userAddressGetFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-address-get", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-address-get::Arn", userAddressGetFunction.Arn)
+
+		// Create the AddressUpdate function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-address-update", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to update an address in the address book of a user in DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-address-update", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-address-update"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-address-update/lambda-user-address-update.zip"),
+			Role:        roles["lambda-user-address-update"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userAddressUpdateFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-address-update", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-address-update::Arn", userAddressUpdateFunction.Arn)
+
+		// Create the Delete function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-delete", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to delete a user from DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-delete", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-delete"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-delete/lambda-user-delete.zip"),
+			Role:        roles["lambda-user-delete"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userDeleteFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-delete", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-delete::Arn", userDeleteFunction.Arn)
+
+		// Create the Export function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-export", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to export all data of a user from DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-export", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-export"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-export/lambda-user-export.zip"),
+			Role:        roles["lambda-user-export"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userExportFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-export", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-export::Arn", userExportFunction.Arn)
+
 		// Create the Get function
 		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-get", ctx.Stack()))
 		environment = lambda.FunctionEnvironmentArgs{
@@ -256,6 +488,84 @@ func main() {
 
 		ctx.Export("lambda-user-login::Arn", userLoginFunction.Arn)
 
+		// Create the Logins function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-logins", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to get the login history of a user from DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-logins", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-logins"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-logins/lambda-user-logins.zip"),
+			Role:        roles["lambda-user-logins"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userLoginsFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-logins", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-logins::Arn", userLoginsFunction.Arn)
+
+		// Create the PreferencesGet function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-preferences-get", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to get the preferences of a user from DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-preferences-get", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-preferences-get"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-preferences-get/lambda-user-preferences-get.zip"),
+			Role:        roles["lambda-user-preferences-get"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userPreferencesGetFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-preferences-get", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-preferences-get::Arn", userPreferencesGetFunction.Arn)
+
+		// Create the PreferencesUpdate function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-preferences-update", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to update the preferences of a user in DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-preferences-update", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-preferences-update"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-preferences-update/lambda-user-preferences-update.zip"),
+			Role:        roles["lambda-user-preferences-update"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userPreferencesUpdateFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-preferences-update", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-preferences-update::Arn", userPreferencesUpdateFunction.Arn)
+
 		// Create the RefreshToken function
 		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-refreshtoken", ctx.Stack()))
 		environment = lambda.FunctionEnvironmentArgs{
@@ -309,6 +619,32 @@ func main() {
 
 		ctx.Export("lambda-user-register::Arn", userRegisterFunction.Arn)
 
+		// Create the Status function
+		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-status", ctx.Stack()))
+		environment = lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap(variables),
+		}
+
+		functionArgs = &lambda.FunctionArgs{
+			Description: pulumi.String("A Lambda function to change the status of a user in DynamoDB"),
+			Runtime:     pulumi.String("go1.x"),
+			Name:        pulumi.String(fmt.Sprintf("%s-lambda-user-status", ctx.Stack())),
+			MemorySize:  pulumi.Int(256),
+			Timeout:     pulumi.Int(10),
+			Handler:     pulumi.String("lambda-user-status"),
+			Environment: environment,
+			Code:        pulumi.NewFileArchive("../cmd/lambda-user-status/lambda-user-status.zip"),
+			Role:        roles["lambda-user-status"].Arn,
+			Tags:        pulumi.Map(tagMap),
+		}
+
+		userStatusFunction, err := lambda.NewFunction(ctx, fmt.Sprintf("%s-lambda-user-status", ctx.Stack()), functionArgs)
+		if err != nil {
+			return err
+		}
+
+		ctx.Export("lambda-user-status::Arn", userStatusFunction.Arn)
+
 		// Create the VerifyToken function
 		variables["FUNCTION_NAME"] = pulumi.String(fmt.Sprintf("%s-lambda-user-verifytoken", ctx.Stack()))
 		environment = lambda.FunctionEnvironmentArgs{
@@ -411,6 +747,268 @@ func main() {
 				fmt.Println(err)
 			}
 
+			i7, err := apigateway.NewIntegration(ctx, "DeleteUserAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("DELETE"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userDeleteFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "DeleteUserAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userDeleteFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/DELETE/users/*", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/export")
+
+			i8, err := apigateway.NewIntegration(ctx, "ExportUserAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("GET"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userExportFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "ExportUserAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userExportFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/GET/users/*/export", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/status")
+
+			i9, err := apigateway.NewIntegration(ctx, "ChangeUserStatusAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("PUT"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userStatusFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "ChangeUserStatusAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userStatusFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/PUT/users/*/status", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/addresses")
+
+			i10, err := apigateway.NewIntegration(ctx, "AddAddressAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("POST"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userAddressAddFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "AddAddressAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userAddressAddFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/POST/users/*/addresses", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/addresses")
+
+			i11, err := apigateway.NewIntegration(ctx, "GetAddressesAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("GET"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userAddressAllFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "GetAddressesAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userAddressAllFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/GET/users/*/addresses", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/addresses/{addressId}")
+
+			i12, err := apigateway.NewIntegration(ctx, "DeleteAddressAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("DELETE"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userAddressDeleteFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "DeleteAddressAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userAddressDeleteFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/DELETE/users/*/addresses/*", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/addresses/{addressId}")
+
+			i13, err := apigateway.NewIntegration(ctx, "GetAddressAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("GET"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userAddressGetFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "GetAddressAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userAddressGetFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/GET/users/*/addresses/*", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/addresses/{addressId}")
+
+			i14, err := apigateway.NewIntegration(ctx, "UpdateAddressAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("PUT"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userAddressUpdateFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "UpdateAddressAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userAddressUpdateFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/PUT/users/*/addresses/*", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/preferences")
+
+			i15, err := apigateway.NewIntegration(ctx, "GetPreferencesAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("GET"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userPreferencesGetFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "GetPreferencesAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userPreferencesGetFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/GET/users/*/preferences", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/preferences")
+
+			i16, err := apigateway.NewIntegration(ctx, "UpdatePreferencesAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("PUT"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userPreferencesUpdateFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "UpdatePreferencesAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userPreferencesUpdateFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/PUT/users/*/preferences", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			resource = gw.MustGetGatewayResource(ctx, id, "/users/{id}/logins")
+
+			i17, err := apigateway.NewIntegration(ctx, "GetLoginsAPIIntegration", &apigateway.IntegrationArgs{
+				HttpMethod:            pulumi.String("GET"),
+				IntegrationHttpMethod: pulumi.String("POST"),
+				ResourceId:            pulumi.String(resource.Id),
+				RestApi:               gateway.ID(),
+				Type:                  pulumi.String("AWS_PROXY"),
+				Uri:                   userLoginsFunction.InvokeArn,
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			_, err = lambda.NewPermission(ctx, "GetLoginsAPIPermission", &lambda.PermissionArgs{
+				Action:    pulumi.String("lambda:InvokeFunction"),
+				Function:  userLoginsFunction.Name,
+				Principal: pulumi.String("apigateway.amazonaws.com"),
+				SourceArn: pulumi.Sprintf("arn:aws:execute-api:%s:%s:%s/*/GET/users/*/logins", genericConfig.Region, genericConfig.AccountID, gateway.ID()),
+			})
+			if err != nil {
+				fmt.Println(err)
+			}
+
 			resource = gw.MustGetGatewayResource(ctx, id, "/login")
 
 			i3, err := apigateway.NewIntegration(ctx, "LoginUserAPIIntegration", &apigateway.IntegrationArgs{
@@ -513,7 +1111,7 @@ func main() {
 				RestApi:          gateway.ID(),
 				StageDescription: pulumi.String("Prod Stage"),
 				StageName:        pulumi.String("Prod"),
-			}, pulumi.DependsOn([]pulumi.Resource{i1, i2, i3, i4, i5, i6}))
+			}, pulumi.DependsOn([]pulumi.Resource{i1, i2, i3, i4, i5, i6, i7, i8, i9, i10, i11, i12, i13, i14, i15, i16, i17}))
 			if err != nil {
 				fmt.Println(err)
 			}
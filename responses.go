@@ -0,0 +1,304 @@
+package user
+
+import (
+	"encoding/json"
+)
+
+// DeleteUserResponse is sent back to the front-end service after a user has been deleted
+type DeleteUserResponse struct {
+	// Message is a status message indicating success or failure
+	Message string `json:"message"`
+
+	// ResourceID represents the user that has been deleted
+	ResourceID string `json:"resourceId"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of DeleteUserResponse
+func (r *DeleteUserResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UserExport is the personal data export of a user. It contains all data the User service keeps
+// about the user, grouped by the kind of data.
+type UserExport struct {
+	// UserID is the unique identifier of the user the data belongs to
+	UserID string `json:"userId"`
+
+	// ExportedAt is the Unix timestamp at which the export was created
+	ExportedAt int64 `json:"exportedAt"`
+
+	// Data contains the data of the user, keyed by the kind of data
+	Data map[string]interface{} `json:"data"`
+}
+
+// Marshal returns the JSON encoding of UserExport
+func (r *UserExport) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// FieldError describes a single field in a request that didn't pass validation
+type FieldError struct {
+	// Field is the name of the field in the request, or empty when the error concerns the request as a whole
+	Field string `json:"field"`
+
+	// Rule is the name of the validation rule the field failed, like required or max_length
+	Rule string `json:"rule"`
+
+	// Message is a human readable description of the error
+	Message string `json:"message"`
+}
+
+// ValidationErrorResponse is sent back to the front-end service when a request doesn't pass
+// validation. It lists each field that failed, together with the rule it failed.
+type ValidationErrorResponse struct {
+	// Message is a status message indicating failure
+	Message string `json:"message"`
+
+	// Errors are the fields that failed validation
+	Errors []FieldError `json:"errors"`
+
+	// Status is the HTTP status code indicating failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of ValidationErrorResponse
+func (r *ValidationErrorResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UserList is the response struct for the reply to the API call to list users. It contains a
+// single page of users.
+type UserList struct {
+	// Data are the users in this page, as PublicUser or, for admins, as AdminUser
+	Data []interface{} `json:"data"`
+
+	// Next is the cursor to get the next page of users, it is empty when there are no more users
+	Next string `json:"next,omitempty"`
+}
+
+// Marshal returns the JSON encoding of UserList
+func (r *UserList) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UserDetailsResponse is the response struct for the reply to the API call to get the details of a user
+type UserDetailsResponse struct {
+	// User are the details about the user, as PublicUser or, for admins, as AdminUser
+	User interface{} `json:"data"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of UserDetailsResponse
+func (r *UserDetailsResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// PublicUser is the view of a user that is sent back to callers. Only the fields listed
+// here are sent, so the password and other credentials never leave the User service.
+type PublicUser struct {
+	// ID is the unique identifier of the user in the shop
+	ID string `json:"id"`
+
+	// Username is the username of the user
+	Username string `json:"username"`
+
+	// Firstname is the firstname of the user
+	Firstname string `json:"firstname"`
+
+	// Lastname is the lastname of the user
+	Lastname string `json:"lastname"`
+
+	// Email is the email address of the user
+	Email string `json:"email"`
+}
+
+// AdminUser is the view of a user that is sent back to admins. On top of the public
+// view it contains the data needed to manage the account, but no credentials.
+type AdminUser struct {
+	PublicUser
+
+	// Roles are the roles that have been assigned to the user
+	Roles []string `json:"roles"`
+
+	// Status is the status of the account
+	Status string `json:"status"`
+
+	// ErasedAt is the Unix timestamp at which the personal data of the user has been erased
+	ErasedAt int64 `json:"erasedAt,omitempty"`
+
+	// CreatedAt is the Unix timestamp at which the user has registered or was imported
+	CreatedAt int64 `json:"createdAt,omitempty"`
+
+	// UpdatedAt is the Unix timestamp at which the user was last changed
+	UpdatedAt int64 `json:"updatedAt,omitempty"`
+
+	// LastLoginAt is the Unix timestamp at which the user last logged in
+	LastLoginAt int64 `json:"lastLoginAt,omitempty"`
+}
+
+// AddressResponse is the response struct for the reply to the API calls that return a single address
+type AddressResponse struct {
+	// Address is the address
+	Address Address `json:"data"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of AddressResponse
+func (r *AddressResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// AddressList is the response struct for the reply to the API call to list the addresses of a user
+type AddressList struct {
+	// Data are the addresses in the address book of the user
+	Data []Address `json:"data"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of AddressList
+func (r *AddressList) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// LoginList is the response struct for the reply to the API call to list the login history of a user
+type LoginList struct {
+	// Data are the login attempts of the user, newest first
+	Data []LoginAttempt `json:"data"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of LoginList
+func (r *LoginList) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// DeleteAddressResponse is sent back to the front-end service after an address has been deleted
+type DeleteAddressResponse struct {
+	// Message is a status message indicating success or failure
+	Message string `json:"message"`
+
+	// ResourceID represents the address that has been deleted
+	ResourceID string `json:"resourceId"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of DeleteAddressResponse
+func (r *DeleteAddressResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// LoginResponse is the response struct for the reply to the API calls to login and to refresh
+// a token. It contains the preferences of the user, so the front-end doesn't need to get them
+// separately.
+type LoginResponse struct {
+	// AccessToken is the JWT access token containing information and claims
+	AccessToken string `json:"access_token"`
+
+	// RefreshToken is the token needed to refresh the access token
+	RefreshToken string `json:"refresh_token"`
+
+	// Preferences are the preferences of the user
+	Preferences Preferences `json:"preferences"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int64 `json:"status"`
+}
+
+// Marshal returns the JSON encoding of LoginResponse
+func (r *LoginResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// PreferencesResponse is the response struct for the reply to the API calls to get and change
+// the preferences of a user
+type PreferencesResponse struct {
+	// Preferences are the preferences of the user
+	Preferences Preferences `json:"data"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of PreferencesResponse
+func (r *PreferencesResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// ImportResult is the outcome of importing a single row of a bulk import
+type ImportResult struct {
+	// Row is the number of the row in the file, starting at 1. The header of a CSV file is not a row.
+	Row int `json:"row"`
+
+	// ID is the ID of the user
+	ID string `json:"id,omitempty"`
+
+	// Username is the username of the user
+	Username string `json:"username,omitempty"`
+
+	// Status is the outcome of the row: imported, valid, invalid, exists or failed
+	Status string `json:"status"`
+
+	// Message describes why the row wasn't imported, or what must be done for a user that
+	// was imported without a password
+	Message string `json:"message,omitempty"`
+
+	// PasswordReset is true when the user was imported without a password and can't log in
+	// until an operator sets a new password
+	PasswordReset bool `json:"passwordReset,omitempty"`
+
+	// Errors are the fields of the row that failed validation
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// ImportSummary counts the outcomes of the rows of a bulk import
+type ImportSummary struct {
+	Rows     int `json:"rows"`
+	Imported int `json:"imported"`
+	Valid    int `json:"valid"`
+	Invalid  int `json:"invalid"`
+	Exists   int `json:"exists"`
+	Failed   int `json:"failed"`
+
+	// PasswordReset counts the imported users that can't log in until an operator sets a
+	// new password
+	PasswordReset int `json:"passwordReset"`
+
+	// LastRow is the number of the last row that has been handled. An import that stopped
+	// can be resumed after this row.
+	LastRow int `json:"lastRow"`
+}
+
+// ImportResponse is the response struct for the reply to the API call to import users. It
+// contains the summary of the import and the rows that were not imported.
+type ImportResponse struct {
+	// Summary counts the outcomes of all rows
+	Summary ImportSummary `json:"summary"`
+
+	// Results are the rows that were not imported and the imported users that must get a new
+	// password, or all rows in a dry run
+	Results []ImportResult `json:"results"`
+
+	// Message describes why the import stopped before the end of the file
+	Message string `json:"message,omitempty"`
+
+	// Status is the HTTP status code indicating success or failure
+	Status int `json:"status"`
+}
+
+// Marshal returns the JSON encoding of ImportResponse
+func (r *ImportResponse) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}